@@ -3,16 +3,25 @@ package main
 import (
     "context"
     "encoding/json"
+    "errors"
     "flag"
     "fmt"
     "io"
+    "net"
+    "net/http"
     "os"
+    "strconv"
+    "strings"
     "time"
 
+    "github.com/frnwtr/tailwhale/internal/caddy"
     "github.com/frnwtr/tailwhale/internal/core"
     "github.com/frnwtr/tailwhale/internal/dockerx"
-    "github.com/frnwtr/tailwhale/internal/fsx"
+    "github.com/frnwtr/tailwhale/internal/envoy"
     "github.com/frnwtr/tailwhale/internal/appconfig"
+    "github.com/frnwtr/tailwhale/internal/fsx"
+    "github.com/frnwtr/tailwhale/internal/mapx"
+    "github.com/frnwtr/tailwhale/internal/proxy"
     traefik "github.com/frnwtr/tailwhale/internal/traefik"
     ts "github.com/frnwtr/tailwhale/internal/tailscale"
 )
@@ -23,6 +32,9 @@ var Version = "0.0.0-dev"
 var out io.Writer = os.Stdout
 var errOut io.Writer = os.Stderr
 
+// newProvider returns the container provider; tests replace it.
+var newProvider = dockerx.NewProvider
+
 func usage() {
     fmt.Fprintln(out, "TailWhale CLI")
     fmt.Fprintln(out, "Usage: tailwhale <command> [flags]")
@@ -31,6 +43,10 @@ func usage() {
     fmt.Fprintln(out, "  list        Show exposed services")
     fmt.Fprintln(out, "  sync        Perform a full sync")
     fmt.Fprintln(out, "  watch       Run in daemon/watch mode")
+    fmt.Fprintln(out, "  proxy       Serve HTTPS and TLS/TCP for discovered services without Traefik")
+    fmt.Fprintln(out, "  shift       Move a group's traffic between variants, e.g. shift api --to green --percent 50")
+    fmt.Fprintln(out, "  funnel      Show, plan or apply the Funnel endpoints of Mode C services: funnel status|plan|on|off")
+    fmt.Fprintln(out, "  entrypoints Print the Traefik static entry points of port-routed services")
     fmt.Fprintln(out)
     fmt.Fprintln(out, "Flags:")
     fmt.Fprintln(out, "  -h, --help  Show help")
@@ -58,97 +74,487 @@ func run(args []string) int {
     case "list":
         fs := flag.NewFlagSet("list", flag.ContinueOnError)
         fs.SetOutput(errOut)
+        cfgPath := fs.String("config", "", "path to JSON config file (clientCAs, host, tailnet, state, routing)")
         jsonOut := fs.Bool("json", false, "output JSON")
         fromFile := fs.String("from-file", "", "load containers from JSON file (for testing)")
+        statePath := fs.String("state", core.DefaultStatePath, "runtime state file with the weights set by shift")
+        host := fs.String("host", "", "host name for mode A/C (default: from tailscale status)")
+        tailnet := fs.String("tailnet", "", "tailnet name, e.g. tail1234 for tail1234.ts.net (default: from tailscale status)")
+        tsSocket := fs.String("tailscale-socket", "", "tailscaled's LocalAPI socket (default "+ts.DefaultSocket+")")
+        routing := fs.String("routing", "", "default Mode A routing: subdomain, path (host.tailnet.ts.net/<service>/) or port (host.tailnet.ts.net:<port>); tailwhale.routing labels override it")
         if err := fs.Parse(args[1:]); err != nil {
             return 2
         }
+        fileCfg := applyConfig(fs, *cfgPath, configFlags)
+        canIssue := resolveIdentity(host, tailnet, *tsSocket)
         var provider dockerx.Provider
         if *fromFile != "" {
             provider = &dockerx.FileProvider{Path: *fromFile}
         } else {
             provider = dockerx.NewProvider()
         }
-        svcs, err := core.Discover(provider, "host", "tn")
+        infos, err := provider.List()
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        svcs := core.Discovery{Host: *host, Tailnet: *tailnet, Routing: *routing}.FromInfos(infos)
+        st, err := core.LoadState(*statePath)
         if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        // The ports sync would record; list writes nothing.
+        if _, err := st.AllocatePorts(svcs, core.PublishedPorts(infos), time.Now()); err != nil { fmt.Fprintf(errOut, "warning: %v\n", err) }
+        svcs = st.Apply(svcs)
         if *jsonOut {
             enc := json.NewEncoder(out)
             enc.SetIndent("", "  ")
             _ = enc.Encode(svcs)
         } else {
             fmt.Fprintf(out, "%d services\n", len(svcs))
+            refused, shared := core.SharedHostTLS(svcs)
             for _, s := range svcs {
-                fmt.Fprintf(out, "- %s (%s) %s\n", s.Name, s.ID, s.Host)
+                mtls := ""
+                switch {
+                case s.TLS.ClientCA == "":
+                case len(fileCfg.ClientCAs[s.TLS.ClientCA]) == 0:
+                    // Traefik gets no router for it: serving it without client verification would fail open.
+                    mtls = " [mtls: " + s.TLS.ClientCA + ", CA not configured: not routed]"
+                case refused[s.Name]:
+                    mtls = " [mtls: " + s.TLS.ClientCA + ", host shared with other tls labels: not routed]"
+                default:
+                    mtls = " [mtls: " + s.TLS.ClientCA + "]"
+                }
+                addr := s.Host
+                if s.Routing == core.RoutingPath || s.Routing == core.RoutingPort { addr = s.URL() }
+                fmt.Fprintf(out, "- %s (%s) %s%s%s\n", s.Name, s.ID, addr, mtls, variantSummary(s))
+                for _, w := range s.Warnings { fmt.Fprintf(errOut, "warning: %v\n", core.ServiceWarning{Service: s.Name, Message: w}) }
             }
+            for _, w := range core.UncertifiableHosts(svcs, canIssue) { fmt.Fprintf(errOut, "warning: %v\n", w) }
+            for _, w := range shared { fmt.Fprintf(errOut, "warning: %v\n", w) }
         }
         return 0
     case "sync":
         fs := flag.NewFlagSet("sync", flag.ContinueOnError)
         fs.SetOutput(errOut)
         cfgPath := fs.String("config", "", "path to JSON config file")
-        host := fs.String("host", "host", "host name for mode A/C")
-        tailnet := fs.String("tailnet", "tn", "tailnet name")
-        tlsPath := fs.String("tls-path", "traefik/tls.yml", "path to write Traefik TLS yaml")
+        host := fs.String("host", "", "host name for mode A/C (default: from tailscale status)")
+        tailnet := fs.String("tailnet", "", "tailnet name, e.g. tail1234 for tail1234.ts.net (default: from tailscale status)")
+        tlsPath := fs.String("tls-path", "traefik/tls.yml", "Traefik dynamic config file to merge into (.yml or .toml)")
         certDir := fs.String("cert-dir", "/var/lib/tailwhale/certs", "directory for issued certs (stub)")
+        tlsDir := fs.String("tls-dir", "", "write one file per service into this directory (Traefik providers.file.directory) instead of --tls-path")
+        traefikContainer := fs.String("traefik-container", "", "Traefik container name, or auto; certificate paths are rewritten through its mounts")
+        inlineCerts := fs.Bool("inline-certs", false, "embed certificate and key PEM in the dynamic config (written 0600) so Traefik needs no access to --cert-dir")
+        acmeJSON := fs.String("acme-json", "", "also export certificates into this Traefik acme.json store (written 0600)")
+        acmeResolver := fs.String("acme-resolver", traefik.DefaultACMEResolver, "certificate resolver name used in --acme-json")
+        proxy := fs.String("proxy", "traefik", "reverse proxy backend: traefik, caddy, template or envoy (watch only)")
+        caddyAdmin := fs.String("caddy-admin", caddy.DefaultAdmin, "Caddy admin API the caddy backend loads its config into")
+        caddyConfig := fs.String("caddy-config", "", "write the Caddy JSON config to this file instead of loading it through the admin API")
+        tmplPath := fs.String("template", "", "text/template file rendered by the template backend")
+        tmplOutput := fs.String("template-output", "", "file the template backend writes")
+        reload := fs.String("reload", "", "command run after the template output changed, e.g. \"nginx -s reload\"")
+        var reloadCmd []string // the config file's command, kept as given
+        verifyAPI := fs.String("verify-api", "", "Traefik API URL (e.g. http://traefik:8080) to verify the written config against; rejected configs are rolled back")
+        verifyProbe := fs.String("verify-probe", "", "Traefik TLS entry point (host:port) to check served certificates by SNI during verification")
+        verifyTimeout := fs.Duration("verify-timeout", 10*time.Second, "how long to wait for Traefik to load the config")
+        statePath := fs.String("state", core.DefaultStatePath, "runtime state file with the weights set by shift")
+        tsSocket := fs.String("tailscale-socket", "", "issue certificates through tailscaled's LocalAPI on this socket (e.g. "+ts.DefaultSocket+") instead of reading them from --cert-dir")
+        routing := fs.String("routing", "", "default Mode A routing: subdomain, path (host.tailnet.ts.net/<service>/) or port (host.tailnet.ts.net:<port>); tailwhale.routing labels override it")
         if err := fs.Parse(args[1:]); err != nil {
             return 2
         }
         // Merge config file (if provided) with flags (flags override)
-        if *cfgPath != "" {
-            if c, err := appconfig.Load(*cfgPath); err == nil {
-                if fs.Lookup("host").Value.String() == "host" && c.Host != "" { *host = c.Host }
-                if fs.Lookup("tailnet").Value.String() == "tn" && c.Tailnet != "" { *tailnet = c.Tailnet }
-                if fs.Lookup("tls-path").Value.String() == "traefik/tls.yml" && c.TLSPath != "" { *tlsPath = c.TLSPath }
-                if fs.Lookup("cert-dir").Value.String() == "/var/lib/tailwhale/certs" && c.CertDir != "" { *certDir = c.CertDir }
+        fileCfg := applyConfig(fs, *cfgPath, configFlags)
+        if fs.Lookup("reload").Value.String() == "" && len(fileCfg.Template.Reload) > 0 { reloadCmd = fileCfg.Template.Reload }
+        canIssue := resolveIdentity(host, tailnet, *tsSocket)
+        if *proxy == "envoy" {
+            fmt.Fprintln(errOut, "the envoy backend streams xDS to Envoy: run it with watch")
+            return 2
+        }
+        if reloadCmd == nil { reloadCmd = strings.Fields(*reload) }
+        backend, target, err := proxyBackend(*proxy, backendOpts{*caddyAdmin, *caddyConfig, *tmplPath, *tmplOutput, reloadCmd}, fileCfg)
+        if err != nil { fmt.Fprintln(errOut, err); return 2 }
+        provider := newProvider()
+        orch := core.Orchestrator{Provider: provider, Host: *host, Tailnet: *tailnet, Manager: certManager(*certDir, *tsSocket), State: *statePath, Routing: *routing, Report: reporter(), CanIssue: canIssue}
+        orch.HostRoutedOnly = *proxy == "caddy" || *proxy == "envoy"
+        orch.Unenforced = unenforced(*proxy)
+        if backend != nil {
+            orch.Backend = backend
+            svcs, _, err := orch.SyncOnce(context.Background())
+            if err != nil { fmt.Fprintln(errOut, err); return 1 }
+            fmt.Fprintf(out, "Synced %d services\n", len(svcs))
+            fmt.Fprintf(out, "Published %s config to %s\n", *proxy, target)
+            return 0
+        }
+        if *traefikContainer != "" {
+            pm, err := traefikPaths(provider, *traefikContainer, certDirFor(*certDir, *inlineCerts), outputPath(*tlsPath, *tlsDir))
+            if err != nil { fmt.Fprintln(errOut, err); return 1 }
+            if !*inlineCerts { orch.CertPaths = pm }
+        }
+        if *acmeJSON != "" {
+            store := traefik.ACMEStore{Path: *acmeJSON, Resolver: *acmeResolver, Skipped: skippedCert(&orch, *acmeJSON)}
+            orch.ExportCerts = func(t traefik.TLSConfig) error {
+                if err := store.Write(t); err != nil { return fmt.Errorf("failed to write %s: %w", *acmeJSON, err) }
+                return nil
             }
         }
-        orch := core.Orchestrator{Provider: &dockerx.FakeProvider{}, Host: *host, Tailnet: *tailnet, Manager: &ts.FileManager{Dir: *certDir}}
-        svcs, tls, err := orch.SyncOnce(context.Background())
+        mode, target := fileMode(*inlineCerts), outputPath(*tlsPath, *tlsDir)
+        // Merge into the existing file: only TailWhale's marked blocks are replaced
+        var pub traefik.Publisher = traefik.FilePublisher{Path: *tlsPath, Mode: mode}
+        var changes traefik.DirChanges
+        switch {
+        case *verifyAPI != "":
+            if *tlsDir != "" { pub = traefik.DirPublisher{Dir: *tlsDir, Mode: mode} }
+            v := traefik.Verifier{BaseURL: *verifyAPI, Provider: "file", ProbeAddr: *verifyProbe, Timeout: *verifyTimeout}
+            pub = traefik.VerifiedPublisher{Publisher: pub, Verifier: v}
+        case *tlsDir != "":
+            dp := traefik.DirPublisher{Dir: *tlsDir, Mode: mode}
+            pub = traefik.PublisherFunc(func(cfg traefik.Config) (err error) { changes, err = dp.Sync(cfg); return err })
+        }
+        if *inlineCerts { pub = traefik.InlinePublisher{Publisher: pub} }
+        orch.Backend = core.TraefikBackend{Options: traefikOptions(fileCfg), Publisher: traefik.PublisherFunc(func(cfg traefik.Config) error {
+            err := pub.Publish(cfg)
+            var ve *traefik.VerifyError
+            if err != nil && !errors.As(err, &ve) { return fmt.Errorf("failed to write %s: %w", target, err) }
+            return err
+        })}
+        svcs, _, err := orch.SyncOnce(context.Background())
+        var ve *traefik.VerifyError
+        if errors.As(err, &ve) {
+            printReport(errOut, ve.Report)
+            return 1
+        }
         if err != nil { fmt.Fprintln(errOut, err); return 1 }
         fmt.Fprintf(out, "Synced %d services\n", len(svcs))
-        data := traefik.MarshalYAML(tls)
-        if err := fsx.WriteFileAtomic(*tlsPath, data, 0o644); err != nil {
-            fmt.Fprintf(errOut, "failed to write %s: %v\n", *tlsPath, err)
-            return 1
+        switch {
+        case *verifyAPI != "":
+            fmt.Fprintf(out, "Wrote %s (verified by Traefik)\n", target)
+        case *tlsDir != "":
+            fmt.Fprintf(out, "Wrote %d, removed %d files in %s\n", len(changes.Written), len(changes.Removed), *tlsDir)
+        default:
+            fmt.Fprintf(out, "Wrote %s\n", *tlsPath)
         }
-        fmt.Fprintf(out, "Wrote %s (%d bytes)\n", *tlsPath, len(data))
         return 0
     case "watch":
         fs := flag.NewFlagSet("watch", flag.ContinueOnError)
         fs.SetOutput(errOut)
         cfgPath := fs.String("config", "", "path to JSON config file")
-        host := fs.String("host", "host", "host name for mode A/C")
-        tailnet := fs.String("tailnet", "tn", "tailnet name")
-        tlsPath := fs.String("tls-path", "traefik/tls.yml", "path to write Traefik TLS yaml")
+        host := fs.String("host", "", "host name for mode A/C (default: from tailscale status)")
+        tailnet := fs.String("tailnet", "", "tailnet name, e.g. tail1234 for tail1234.ts.net (default: from tailscale status)")
+        tlsPath := fs.String("tls-path", "traefik/tls.yml", "Traefik dynamic config file to merge into (.yml or .toml)")
         certDir := fs.String("cert-dir", "/var/lib/tailwhale/certs", "directory for issued certs (stub)")
+        tlsDir := fs.String("tls-dir", "", "write one file per service into this directory (Traefik providers.file.directory) instead of --tls-path")
+        traefikContainer := fs.String("traefik-container", "", "Traefik container name, or auto; certificate paths are rewritten through its mounts")
+        inlineCerts := fs.Bool("inline-certs", false, "embed certificate and key PEM in the dynamic config (written 0600) so Traefik needs no access to --cert-dir")
+        acmeJSON := fs.String("acme-json", "", "also export certificates into this Traefik acme.json store (written 0600)")
+        acmeResolver := fs.String("acme-resolver", traefik.DefaultACMEResolver, "certificate resolver name used in --acme-json")
+        proxy := fs.String("proxy", "traefik", "reverse proxy backend: traefik, caddy, template or envoy (watch only)")
+        caddyAdmin := fs.String("caddy-admin", caddy.DefaultAdmin, "Caddy admin API the caddy backend loads its config into")
+        caddyConfig := fs.String("caddy-config", "", "write the Caddy JSON config to this file instead of loading it through the admin API")
+        tmplPath := fs.String("template", "", "text/template file rendered by the template backend")
+        tmplOutput := fs.String("template-output", "", "file the template backend writes")
+        reload := fs.String("reload", "", "command run after the template output changed, e.g. \"nginx -s reload\"")
+        var reloadCmd []string // the config file's command, kept as given
+        verifyAPI := fs.String("verify-api", "", "Traefik API URL (e.g. http://traefik:8080) to verify the written config against; rejected configs are rolled back")
+        verifyProbe := fs.String("verify-probe", "", "Traefik TLS entry point (host:port) to check served certificates by SNI during verification")
+        verifyTimeout := fs.Duration("verify-timeout", 10*time.Second, "how long to wait for Traefik to load the config")
+        statePath := fs.String("state", core.DefaultStatePath, "runtime state file with the weights set by shift")
         interval := fs.Duration("interval", 10*time.Second, "sync interval (fallback)")
+        xdsListen := fs.String("xds-listen", ":18000", "gRPC listen address of the xDS server (--proxy envoy)")
+        publish := fs.String("publish", "file", "comma-separated publishers: file (--tls-path or --tls-dir), http (Traefik providers.http), redis (Traefik providers.redis)")
+        listen := fs.String("listen", "127.0.0.1:8081", "listen address for the http publisher (served at /traefik, without authentication); must be loopback with --inline-certs")
+        redisAddr := fs.String("redis-addr", "localhost:6379", "Redis address for the redis publisher")
+        redisPrefix := fs.String("redis-prefix", traefik.DefaultKVPrefix, "key prefix for the redis publisher (Traefik's rootKey)")
+        redisDB := fs.Int("redis-db", 0, "Redis database for the redis publisher")
+        funnel := fs.Bool("funnel", false, "keep Tailscale Funnel in line with the Mode C services on every sync (through --tailscale-socket)")
+        tsSocket := fs.String("tailscale-socket", "", "issue certificates through tailscaled's LocalAPI on this socket (e.g. "+ts.DefaultSocket+") instead of reading them from --cert-dir")
+        routing := fs.String("routing", "", "default Mode A routing: subdomain, path (host.tailnet.ts.net/<service>/) or port (host.tailnet.ts.net:<port>); tailwhale.routing labels override it")
         if err := fs.Parse(args[1:]); err != nil {
             return 2
         }
-        if *cfgPath != "" {
-            if c, err := appconfig.Load(*cfgPath); err == nil {
-                if fs.Lookup("host").Value.String() == "host" && c.Host != "" { *host = c.Host }
-                if fs.Lookup("tailnet").Value.String() == "tn" && c.Tailnet != "" { *tailnet = c.Tailnet }
-                if fs.Lookup("tls-path").Value.String() == "traefik/tls.yml" && c.TLSPath != "" { *tlsPath = c.TLSPath }
-                if fs.Lookup("cert-dir").Value.String() == "/var/lib/tailwhale/certs" && c.CertDir != "" { *certDir = c.CertDir }
+        fileCfg := applyConfig(fs, *cfgPath, configFlags)
+        if fs.Lookup("reload").Value.String() == "" && len(fileCfg.Template.Reload) > 0 { reloadCmd = fileCfg.Template.Reload }
+        canIssue := resolveIdentity(host, tailnet, *tsSocket)
+        if reloadCmd == nil { reloadCmd = strings.Fields(*reload) }
+        backend, _, err := proxyBackend(*proxy, backendOpts{*caddyAdmin, *caddyConfig, *tmplPath, *tmplOutput, reloadCmd}, fileCfg)
+        if err != nil { fmt.Fprintln(errOut, err); return 2 }
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        var pubs traefik.Publishers
+        // verified wraps p so Traefik's API confirms each publish (rolled back otherwise)
+        verified := func(p traefik.Publisher, provider string) traefik.Publisher {
+            if *verifyAPI == "" { return p }
+            return traefik.VerifiedPublisher{Publisher: p, Verifier: traefik.Verifier{BaseURL: *verifyAPI, Provider: provider, ProbeAddr: *verifyProbe, Timeout: *verifyTimeout}}
+        }
+        names := strings.Split(*publish, ",")
+        if backend != nil { names = nil } // other proxies get their config through the backend below
+        for _, name := range names {
+            switch strings.TrimSpace(name) {
+            case "file":
+                if *tlsDir != "" {
+                    pubs = append(pubs, verified(traefik.DirPublisher{Dir: *tlsDir, Mode: fileMode(*inlineCerts)}, "file"))
+                } else {
+                    pubs = append(pubs, verified(traefik.FilePublisher{Path: *tlsPath, Mode: fileMode(*inlineCerts)}, "file"))
+                }
+            case "http":
+                if *inlineCerts && !loopback(*listen) {
+                    fmt.Fprintf(errOut, "--inline-certs with the http publisher serves private keys without authentication: --listen %s must be a loopback address\n", *listen)
+                    return 2
+                }
+                hp := &traefik.HTTPPublisher{}
+                pubs = append(pubs, verified(hp, "http"))
+                mux := http.NewServeMux()
+                mux.Handle("/traefik", hp)
+                srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+                go func(){
+                    if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                        fmt.Fprintf(errOut, "http publisher: %v\n", err)
+                    }
+                }()
+                go func(){ <-ctx.Done(); _ = srv.Close() }()
+                fmt.Fprintf(out, "serving Traefik config on http://%s/traefik\n", *listen)
+            case "redis":
+                rp := traefik.RedisPublisher{Addr: *redisAddr, Password: fileCfg.Redis.Password, DB: *redisDB, Prefix: *redisPrefix}
+                pubs = append(pubs, verified(rp, "redis"))
+                fmt.Fprintf(out, "publishing Traefik config to redis://%s under %s/\n", *redisAddr, *redisPrefix)
+            default:
+                fmt.Fprintf(errOut, "unknown publisher: %s\n", name)
+                return 2
             }
         }
-        provider := dockerx.NewProvider()
-        orch := core.Orchestrator{Provider: provider, Host: *host, Tailnet: *tailnet}
-        // Configure tailscale manager and TLS writer
-        orch.Manager = &ts.FileManager{Dir: *certDir}
-        orch.WriteTLS = func(cfg traefik.TLSConfig) error {
-            data := traefik.MarshalYAML(cfg)
-            return fsx.WriteFileAtomic(*tlsPath, data, 0o644)
+        provider := newProvider()
+        orch := core.Orchestrator{Provider: provider, Host: *host, Tailnet: *tailnet, State: *statePath, Routing: *routing, CanIssue: canIssue}
+        orch.HostRoutedOnly = *proxy == "caddy" || *proxy == "envoy"
+        orch.Unenforced = unenforced(*proxy)
+        if *traefikContainer != "" {
+            pm, err := traefikPaths(provider, *traefikContainer, certDirFor(*certDir, *inlineCerts), outputPath(*tlsPath, *tlsDir))
+            if err != nil { fmt.Fprintln(errOut, err); return 1 }
+            if !*inlineCerts { orch.CertPaths = pm }
         }
-        ctx, cancel := context.WithCancel(context.Background())
-        defer cancel()
+        // Configure tailscale manager and dynamic config publishers (routers, services and tls)
+        orch.Manager = certManager(*certDir, *tsSocket)
+        if *acmeJSON != "" {
+            store := traefik.ACMEStore{Path: *acmeJSON, Resolver: *acmeResolver, Skipped: skippedCert(&orch, *acmeJSON)}
+            orch.ExportCerts = func(t traefik.TLSConfig) error {
+                if err := store.Write(t); err != nil { return fmt.Errorf("acme export: %w", err) }
+                return nil
+            }
+        }
+        if backend == nil {
+            var pub traefik.Publisher = pubs
+            if *inlineCerts { pub = traefik.InlinePublisher{Publisher: pubs} }
+            backend = core.TraefikBackend{Options: traefikOptions(fileCfg), Publisher: pub}
+        }
+        orch.Backend = core.BackendFunc(func(svcs []core.Service, certs traefik.TLSConfig) error {
+            if err := backend.Apply(svcs, certs); err != nil { return fmt.Errorf("publish: %w", err) }
+            return nil
+        })
+        if *funnel {
+            fb := core.FunnelBackend{Client: &ts.LocalClient{Socket: *tsSocket}, Host: core.NodeHostname(*host, *tailnet)}
+            fb.Changed = func(changes []ts.ServeChange){
+                for _, c := range changes { fmt.Fprintf(out, "funnel: %s\n", c) }
+            }
+            orch.Funnel = core.BackendFunc(func(svcs []core.Service, certs traefik.TLSConfig) error {
+                if err := fb.Apply(svcs, certs); err != nil { return fmt.Errorf("funnel: %w", err) }
+                return nil
+            })
+        }
+        if eb, ok := backend.(core.EnvoyBackend); ok {
+            lis, err := net.Listen("tcp", *xdsListen)
+            if err != nil { fmt.Fprintln(errOut, err); return 1 }
+            go func(){
+                if err := eb.Server.Serve(ctx, lis); err != nil { fmt.Fprintf(errOut, "xds server: %v\n", err) }
+            }()
+            fmt.Fprintf(out, "serving xDS on %s\n", *xdsListen)
+        }
+        orch.Report = reporter()
         fmt.Fprintln(out, "watching for container changes...")
-        _ = orch.Watch(ctx, *interval, func(svcs []core.Service, tlsCfg traefik.TLSConfig){
-            _ = tlsCfg // already written via WriteTLS; optionally print summary
+        _ = orch.Watch(ctx, *interval, func(svcs []core.Service, _ traefik.TLSConfig){
             fmt.Fprintf(out, "synced %d services\n", len(svcs))
         })
         return 0
+    case "proxy":
+        fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+        fs.SetOutput(errOut)
+        cfgPath := fs.String("config", "", "path to JSON config file")
+        host := fs.String("host", "", "host name for mode A/C (default: from tailscale status)")
+        tailnet := fs.String("tailnet", "", "tailnet name, e.g. tail1234 for tail1234.ts.net (default: from tailscale status)")
+        certDir := fs.String("cert-dir", "/var/lib/tailwhale/certs", "directory for issued certs (stub)")
+        listen := fs.String("listen", ":443", "TLS listen address shared by HTTP and TCP services")
+        interval := fs.Duration("interval", 10*time.Second, "sync interval (fallback)")
+        statePath := fs.String("state", core.DefaultStatePath, "runtime state file with the weights set by shift")
+        tsSocket := fs.String("tailscale-socket", "", "issue certificates through tailscaled's LocalAPI on this socket (e.g. "+ts.DefaultSocket+") instead of reading them from --cert-dir")
+        routing := fs.String("routing", "", "default Mode A routing: subdomain, path or port; the built-in proxy routes by hostname only and reports path- and port-routed services")
+        if err := fs.Parse(args[1:]); err != nil {
+            return 2
+        }
+        applyConfig(fs, *cfgPath, proxyConfigFlags)
+        canIssue := resolveIdentity(host, tailnet, *tsSocket)
+        mgr := certManager(*certDir, *tsSocket)
+        srv := &proxy.Server{Certs: &proxy.Certificates{Manager: mgr}}
+        lis, err := net.Listen("tcp", *listen)
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        go func(){
+            if err := srv.Serve(ctx, lis); err != nil { fmt.Fprintf(errOut, "proxy: %v\n", err) }
+            cancel()
+        }()
+        orch := core.Orchestrator{Provider: newProvider(), Host: *host, Tailnet: *tailnet, Manager: mgr, State: *statePath, Routing: *routing, CanIssue: canIssue, HostRoutedOnly: true, Unenforced: core.ProxyUnenforced}
+        orch.Backend = core.BackendFunc(func(svcs []core.Service, certs traefik.TLSConfig) error {
+            if err := (core.ProxyBackend{Server: srv}).Apply(svcs, certs); err != nil { return fmt.Errorf("proxy routes: %w", err) }
+            return nil
+        })
+        orch.Report = reporter()
+        fmt.Fprintf(out, "proxying TLS on %s\n", lis.Addr())
+        _ = orch.Watch(ctx, *interval, func(svcs []core.Service, _ traefik.TLSConfig){
+            fmt.Fprintf(out, "routing %d services\n", len(core.ProxyRoutes(svcs)))
+        })
+        return 0
+    case "shift":
+        fs := flag.NewFlagSet("shift", flag.ContinueOnError)
+        fs.SetOutput(errOut)
+        cfgPath := fs.String("config", "", "path to JSON config file")
+        statePath := fs.String("state", core.DefaultStatePath, "runtime state file read by sync, watch and proxy")
+        to := fs.String("to", "", "variant receiving --percent of the group's traffic")
+        percent := fs.Int("percent", 100, "share of the traffic for --to; the other variants split the rest by their current weights")
+        fromFile := fs.String("from-file", "", "load containers from JSON file (for testing)")
+        // The group comes first (shift api --to green), which the flag package would stop at.
+        rest, group := args[1:], ""
+        if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") { group, rest = rest[0], rest[1:] }
+        if err := fs.Parse(rest); err != nil {
+            return 2
+        }
+        if group == "" { group = fs.Arg(0) }
+        if group == "" || *to == "" {
+            fmt.Fprintln(errOut, "usage: tailwhale shift <group> --to <variant> [--percent 100]")
+            return 2
+        }
+        applyConfig(fs, *cfgPath, configFlags)
+        var provider dockerx.Provider
+        if *fromFile != "" {
+            provider = &dockerx.FileProvider{Path: *fromFile}
+        } else {
+            provider = newProvider()
+        }
+        svcs, err := core.Discover(provider, "host", "tn")
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        st, err := core.LoadState(*statePath)
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        svcs = st.Apply(svcs)
+        var svc *core.Service
+        for i := range svcs {
+            if svcs[i].Group == group { svc = &svcs[i] }
+        }
+        if svc == nil { fmt.Fprintf(errOut, "no group %s among the exposed services\n", group); return 1 }
+        weights, err := core.Shift(*svc, *to, *percent)
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        if st.Weights == nil { st.Weights = map[string]map[string]int{} }
+        st.Weights[group] = weights
+        if err := st.Save(*statePath); err != nil { fmt.Fprintf(errOut, "failed to write %s: %v\n", *statePath, err); return 1 }
+        fmt.Fprintf(out, "%s%s\n", group, variantSummary(st.Apply([]core.Service{*svc})[0]))
+        return 0
+    case "funnel":
+        fs := flag.NewFlagSet("funnel", flag.ContinueOnError)
+        fs.SetOutput(errOut)
+        cfgPath := fs.String("config", "", "path to JSON config file")
+        host := fs.String("host", "", "host name (default: from tailscale status)")
+        tailnet := fs.String("tailnet", "", "tailnet name, e.g. tail1234 for tail1234.ts.net (default: from tailscale status)")
+        tsSocket := fs.String("tailscale-socket", "", "tailscaled's LocalAPI socket (default "+ts.DefaultSocket+")")
+        statePath := fs.String("state", core.DefaultStatePath, "runtime state file with the weights set by shift")
+        fromFile := fs.String("from-file", "", "load containers from JSON file (for testing)")
+        // The action comes first (funnel plan --host host1), which the flag package would stop at.
+        rest, action := args[1:], ""
+        if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") { action, rest = rest[0], rest[1:] }
+        if err := fs.Parse(rest); err != nil {
+            return 2
+        }
+        if action == "" { action = fs.Arg(0) }
+        if action != "status" && action != "plan" && action != "on" && action != "off" {
+            fmt.Fprintln(errOut, "usage: tailwhale funnel status|plan|on|off")
+            return 2
+        }
+        applyConfig(fs, *cfgPath, configFlags)
+        client := &ts.LocalClient{Socket: *tsSocket}
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+        if action == "status" {
+            sc, err := client.ServeConfig(ctx)
+            if err != nil { fmt.Fprintln(errOut, err); return 1 }
+            eps := sc.Endpoints()
+            if len(eps) == 0 { fmt.Fprintln(out, "nothing is served") }
+            for _, e := range eps {
+                scope, addr := "tailnet", "https://"+e.HostPort+e.Path
+                if e.Public { scope = "public" }
+                if e.Path == "" { addr = "tcp " + e.HostPort }
+                fmt.Fprintf(out, "%-7s %s -> %s\n", scope, addr, e.Target)
+            }
+            return 0
+        }
+        resolveIdentity(host, tailnet, *tsSocket)
+        node := core.NodeHostname(*host, *tailnet)
+        var mounts []ts.FunnelMount // none for off: every funnel is closed
+        if action != "off" {
+            var provider dockerx.Provider
+            if *fromFile != "" {
+                provider = &dockerx.FileProvider{Path: *fromFile}
+            } else {
+                provider = newProvider()
+            }
+            svcs, err := core.Discover(provider, *host, *tailnet)
+            if err != nil { fmt.Fprintln(errOut, err); return 1 }
+            st, err := core.LoadState(*statePath)
+            if err != nil { fmt.Fprintln(errOut, err); return 1 }
+            svcs = st.Apply(svcs)
+            for _, w := range core.FunnelWarnings(svcs) { fmt.Fprintf(errOut, "warning: %v\n", w) }
+            mounts = core.FunnelMounts(svcs)
+        }
+        var changes []ts.ServeChange
+        var err error
+        if action == "plan" {
+            var sc ts.ServeConfig
+            if sc, err = client.ServeConfig(ctx); err == nil { changes, err = ts.PlanFunnel(sc, node, mounts) }
+        } else {
+            changes, err = client.ReconcileFunnel(ctx, node, mounts)
+        }
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        if len(changes) == 0 { fmt.Fprintln(out, "funnel is up to date") }
+        for _, c := range changes { fmt.Fprintln(out, c) }
+        return 0
+    case "entrypoints":
+        fs := flag.NewFlagSet("entrypoints", flag.ContinueOnError)
+        fs.SetOutput(errOut)
+        cfgPath := fs.String("config", "", "path to JSON config file")
+        statePath := fs.String("state", core.DefaultStatePath, "runtime state file the allocated ports are recorded in")
+        routing := fs.String("routing", "", "default Mode A routing: subdomain, path or port; tailwhale.routing labels override it")
+        output := fs.String("output", "", "write the snippet to this file instead of stdout")
+        fromFile := fs.String("from-file", "", "load containers from JSON file (for testing)")
+        if err := fs.Parse(args[1:]); err != nil {
+            return 2
+        }
+        applyConfig(fs, *cfgPath, configFlags)
+        var provider dockerx.Provider
+        if *fromFile != "" {
+            provider = &dockerx.FileProvider{Path: *fromFile}
+        } else {
+            provider = newProvider()
+        }
+        infos, err := provider.List()
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        // Entry points only depend on ports, so the node's names are not needed.
+        svcs := core.Discovery{Host: "host", Tailnet: "tn", Routing: *routing}.FromInfos(infos)
+        st, err := core.LoadState(*statePath)
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        changed, err := st.AllocatePorts(svcs, core.PublishedPorts(infos), time.Now())
+        if err != nil { fmt.Fprintln(errOut, err); return 1 }
+        if changed {
+            if err := st.Save(*statePath); err != nil { fmt.Fprintf(errOut, "failed to write %s: %v\n", *statePath, err); return 1 }
+        }
+        for _, s := range svcs {
+            for _, w := range s.Warnings { fmt.Fprintf(errOut, "warning: %v\n", core.ServiceWarning{Service: s.Name, Message: w}) }
+        }
+        sc := core.EntryPoints(svcs)
+        if len(sc.EntryPoints) == 0 { fmt.Fprintln(errOut, "no port-routed services") }
+        b := traefik.MarshalStaticYAML(sc)
+        if *output == "" { out.Write(b); return 0 }
+        if err := fsx.WriteFileAtomic(*output, b, 0o644); err != nil { fmt.Fprintf(errOut, "failed to write %s: %v\n", *output, err); return 1 }
+        fmt.Fprintf(out, "Wrote %d entry points to %s\n", len(sc.EntryPoints), *output)
+        return 0
     default:
         fmt.Fprintf(errOut, "unknown command: %s\n\n", args[0])
         usage()
@@ -156,22 +562,232 @@ func run(args []string) int {
     }
 }
 
-func main() {
-    os.Exit(run(os.Args[1:]))
+// nodeStatus reads the live node's status from tailscaled; tests replace it.
+var nodeStatus = func(socket string) (ts.Status, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+    return (&ts.LocalClient{Socket: socket}).Status(ctx)
+}
+
+// resolveIdentity fills host and tailnet left empty by flags and config from the live node,
+// and warns when configured names don't match it. Without tailscaled the placeholders
+// "host" and "tn" are used. It returns the node's ts.Status.CanIssue, or nil when tailscaled
+// can't be read or reports no certificate domains (HTTPS disabled or an older tailscaled).
+func resolveIdentity(host, tailnet *string, socket string) func(string) bool {
+    if socket == "" { socket = ts.DefaultSocket }
+    st, err := nodeStatus(socket)
+    var h, tn string
+    if err == nil { h, tn = st.Identity() }
+    if *host == "" || *tailnet == "" {
+        if err != nil {
+            fmt.Fprintf(errOut, "warning: cannot read the node's names from tailscaled (%v); set --host and --tailnet\n", err)
+        } else if (*host == "" && h == "") || (*tailnet == "" && tn == "") {
+            fmt.Fprintln(errOut, "warning: tailscaled did not report the node's MagicDNS name (logged out?); set --host and --tailnet")
+        }
+    }
+    for _, f := range []struct{ name string; v *string; live, placeholder string }{
+        {"host", host, h, "host"},
+        {"tailnet", tailnet, tn, "tn"},
+    } {
+        switch {
+        case *f.v == "" && f.live != "":
+            *f.v = f.live
+        case *f.v == "":
+            *f.v = f.placeholder
+        case f.live != "" && !strings.EqualFold(*f.v, f.live):
+            fmt.Fprintf(errOut, "warning: %s %q does not match this node (%s.%s.ts.net)\n", f.name, *f.v, h, tn)
+        }
+    }
+    if err != nil || len(st.CertDomains) == 0 { return nil }
+    return st.CanIssue
+}
+
+// certRenewBefore is how long before expiry LocalAPI certificates are reissued.
+const certRenewBefore = 14 * 24 * time.Hour
+
+// certManager issues certificates through tailscaled's LocalAPI when socket is set;
+// otherwise they are expected in certDir already.
+func certManager(certDir, socket string) ts.Manager {
+    if socket == "" { return &ts.FileManager{Dir: certDir} }
+    return &ts.LocalManager{Client: &ts.LocalClient{Socket: socket}, CertDir: certDir, MinRemain: certRenewBefore}
+}
+
+// reporter prints what an orchestrator reports: each service warning once, and every failed sync.
+// Watch serialises its syncs, so the returned function is not called concurrently.
+func reporter() func(error) {
+    seen := map[string]bool{}
+    return func(err error){
+        var w core.ServiceWarning
+        if !errors.As(err, &w) { fmt.Fprintf(errOut, "sync failed: %v\n", err); return }
+        if !seen[err.Error()] {
+            seen[err.Error()] = true
+            fmt.Fprintf(errOut, "warning: %v\n", err)
+        }
+    }
+}
+
+// skippedCert warns through orch's reporter about a host left out of the acme.json store at path.
+func skippedCert(orch *core.Orchestrator, path string) func(string, error) {
+    return func(host string, err error){
+        if orch.Report != nil { orch.Report(core.ServiceWarning{Service: host, Message: fmt.Sprintf("no certificate yet, left out of %s (%v)", path, err)}) }
+    }
+}
+
+// unenforced returns how the backend named by proxy tells which security labels of a
+// service it can't enforce, or nil when it enforces them all (Traefik) or leaves them to the user (templates).
+func unenforced(proxy string) func(core.Service) []string {
+    switch proxy {
+    case "caddy":
+        return core.CaddyUnenforced
+    case "envoy":
+        return core.EnvoyUnenforced
+    }
+    return nil
+}
+
+// traefikOptions maps config file settings onto traefik rendering options.
+func traefikOptions(c appconfig.Config) traefik.Options {
+    if _, ok := traefik.TLSProfiles[strings.ToLower(c.TLSProfile)]; c.TLSProfile != "" && !ok {
+        fmt.Fprintf(errOut, "warning: unknown tlsProfile %q in the config file; using Traefik's defaults\n", c.TLSProfile)
+    }
+    return traefik.Options{
+        EntryPoint:    c.EntryPoints.HTTP,
+        TCPEntryPoint: c.EntryPoints.TCP,
+        UDPEntryPoint: c.EntryPoints.UDP,
+        WebEntryPoint: c.EntryPoints.Web,
+        TLSProfile:    c.TLSProfile,
+        ClientCAs:     c.ClientCAs,
+    }
+}
+
+// outputPath is where file publishers write: the directory when set, else the merged file.
+func outputPath(tlsPath, tlsDir string) string {
+    if tlsDir != "" { return tlsDir }
+    return tlsPath
+}
+
+// backendOpts carries the flags of the backends other than traefik.
+type backendOpts struct {
+    caddyAdmin, caddyConfig string
+    template, output        string
+    reload                  []string // command and arguments
+}
+
+// proxyBackend returns the backend for a --proxy value and where it publishes to.
+// traefik returns a nil backend: callers build a core.TraefikBackend over the publishers
+// their own flags select.
+func proxyBackend(name string, o backendOpts, c appconfig.Config) (core.Backend, string, error) {
+    switch name {
+    case "traefik":
+        return nil, "", nil
+    case "caddy":
+        if o.caddyConfig != "" {
+            return core.CaddyBackend{Options: caddyOptions(c), Publisher: caddy.FilePublisher{Path: o.caddyConfig}}, o.caddyConfig, nil
+        }
+        return core.CaddyBackend{Options: caddyOptions(c), Publisher: caddy.AdminPublisher{URL: o.caddyAdmin}}, o.caddyAdmin, nil
+    case "template":
+        if o.template == "" || o.output == "" { return nil, "", errors.New("template backend needs --template and --template-output") }
+        return core.TemplateBackend{Template: o.template, Output: o.output, Reload: o.reload}, o.output, nil
+    case "envoy":
+        srv, err := envoy.NewServer()
+        if err != nil { return nil, "", err }
+        return core.EnvoyBackend{Server: srv}, "xDS", nil
+    }
+    return nil, "", fmt.Errorf("unknown proxy: %s", name)
+}
+
+func caddyOptions(c appconfig.Config) caddy.Options {
+    return caddy.Options{Listen: c.CaddyListen, AdminListen: c.CaddyAdminListen}
+}
+
+// fileMode keeps dynamic config files private when they hold inlined keys.
+func fileMode(inlineCerts bool) os.FileMode {
+    if inlineCerts { return 0o600 }
+    return 0o644
+}
+
+// loopback reports whether addr (host:port) only accepts connections from this machine.
+func loopback(addr string) bool {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil { return false }
+    if host == "localhost" { return true }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// certDirFor returns the cert dir Traefik must see: none when certificates are inlined.
+func certDirFor(certDir string, inlineCerts bool) string {
+    if inlineCerts { return "" }
+    return certDir
+}
+
+// traefikPaths locates the Traefik container and checks that it sees the cert dir and the dynamic config.
+func traefikPaths(p dockerx.Provider, name, certDir, configPath string) (*core.PathMap, error) {
+    c, err := core.FindTraefik(p, name)
+    if err != nil { return nil, err }
+    pm := core.NewPathMap(c)
+    if err := pm.Check(map[string]string{"cert dir": certDir, "dynamic config": configPath}); err != nil { return nil, err }
+    return &pm, nil
+}
+
+// variantSummary renders a group's weights for list and shift, e.g. " [weights: blue=50 green=50]".
+func variantSummary(s core.Service) string {
+    if len(s.Variants) == 0 { return "" }
+    parts := make([]string, len(s.Variants))
+    for i, v := range s.Variants { parts[i] = fmt.Sprintf("%s=%d", v.Name, v.Weight) }
+    return " [weights: " + strings.Join(parts, " ") + "]"
+}
+
+// printReport lists per-service load errors from a failed verification.
+func printReport(w io.Writer, rep traefik.Report) {
+    fmt.Fprintln(w, "traefik rejected the new config:")
+    for _, name := range mapx.SortedKeys(rep.Errors) {
+        fmt.Fprintf(w, "  %s: %s\n", name, strings.Join(rep.Errors[name], "; "))
+    }
+    for _, name := range rep.Missing { fmt.Fprintf(w, "  %s: not loaded\n", name) }
+    for _, host := range mapx.SortedKeys(rep.CertErrors) { fmt.Fprintf(w, "  %s: %s\n", host, rep.CertErrors[host]) }
+    if rep.RolledBack { fmt.Fprintln(w, "previous config restored") }
 }
 
-type coreTLS = traefik.TLSConfig
+// applyConfig loads the config file at path and fills in the flags of fs that were not
+// given on the command line from values(c); flags fs does not define are ignored.
+func applyConfig(fs *flag.FlagSet, path string, values func(appconfig.Config) map[string]string) appconfig.Config {
+    if path == "" { return appconfig.Config{} }
+    c, err := appconfig.Load(path)
+    if err != nil { return appconfig.Config{} }
+    given := map[string]bool{}
+    fs.Visit(func(f *flag.Flag){ given[f.Name] = true })
+    for name, v := range values(c) {
+        if f := fs.Lookup(name); f != nil && v != "" && !given[name] { _ = f.Value.Set(v) }
+    }
+    return c
+}
 
-func coreYaml(t traefik.TLSConfig) []byte {
-    // tiny helper to print YAML-like output without external deps
-    type tlsCert struct{ CertFile, KeyFile string }
-    type tlsBlock struct{ Certificates []tlsCert }
-    // naive: order unspecified here; kept for preview only
-    var buf []byte
-    buf = append(buf, []byte("tls:\n  certificates:\n")...)
-    for _, c := range t {
-        buf = append(buf, []byte("    - certFile: \""+c.CertFile+"\"\n      keyFile: \""+c.KeyFile+"\"\n")...)
+// configFlags maps flag names to their config file values; empty values leave the flag alone.
+func configFlags(c appconfig.Config) map[string]string {
+    v := map[string]string{
+        "host": c.Host, "tailnet": c.Tailnet, "state": c.StateFile, "tailscale-socket": c.TailscaleSocket, "routing": c.Routing,
+        "tls-path": c.TLSPath, "cert-dir": c.CertDir, "tls-dir": c.TLSDir, "traefik-container": c.TraefikContainer,
+        "acme-json": c.ACMEJSON, "acme-resolver": c.ACMEResolver,
+        "proxy": c.Proxy, "caddy-admin": c.CaddyAdmin, "caddy-config": c.CaddyConfig,
+        "template": c.Template.Path, "template-output": c.Template.Output,
+        "verify-api": c.VerifyAPI, "verify-probe": c.VerifyProbe,
+        "publish": strings.Join(c.Publish, ","), "listen": c.Listen, "xds-listen": c.XDSListen,
+        "redis-addr": c.Redis.Addr, "redis-prefix": c.Redis.Prefix,
     }
-    _ = tlsBlock{}
-    return buf
+    if c.InlineCerts { v["inline-certs"] = "true" }
+    if c.Funnel { v["funnel"] = "true" }
+    if c.Redis.DB != 0 { v["redis-db"] = strconv.Itoa(c.Redis.DB) }
+    return v
+}
+
+// proxyConfigFlags is configFlags for tailwhale proxy, whose --listen is the proxy's own address.
+func proxyConfigFlags(c appconfig.Config) map[string]string {
+    v := configFlags(c)
+    v["listen"] = c.ProxyListen
+    return v
+}
+
+func main() {
+    os.Exit(run(os.Args[1:]))
 }
@@ -2,10 +2,27 @@ package main
 
 import (
     "bytes"
+    "errors"
+    "flag"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
     "strings"
     "testing"
+
+    "github.com/frnwtr/tailwhale/internal/dockerx"
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
 )
 
+func TestMain(m *testing.M) {
+    // Keep the tests independent of a tailscaled running on the machine.
+    nodeStatus = func(string) (ts.Status, error) { return ts.Status{}, errors.New("no tailscaled in tests") }
+    os.Exit(m.Run())
+}
+
 func TestHelp(t *testing.T) {
     var buf bytes.Buffer
     out, errOut = &buf, &buf
@@ -49,3 +66,284 @@ func TestList(t *testing.T) {
         t.Fatalf("unexpected output: %s", buf.String())
     }
 }
+
+func TestListShowsMutualTLS(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    t.Cleanup(func() { out, errOut = nil, nil })
+
+    dir := t.TempDir()
+    path, cfg := filepath.Join(dir, "containers.json"), filepath.Join(dir, "tailwhale.json")
+    data := `[{"ID":"1","Name":"admin","Labels":{"tailwhale.enable":"true","tailwhale.mtls.ca":"internal"},"Ports":[80]},
+              {"ID":"2","Name":"web","Labels":{"tailwhale.enable":"true","tailwhale.tls.profile":"paranoid"},"Ports":[80]}]`
+    if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if code := run([]string{"list", "--from-file", path}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    s := buf.String()
+    if !strings.Contains(s, "- admin (1) admin.host.tn.ts.net [mtls: internal, CA not configured: not routed]") || strings.Contains(s, "web.host.tn.ts.net [mtls") {
+        t.Fatalf("unexpected output: %s", s)
+    }
+    buf.Reset()
+    if err := os.WriteFile(cfg, []byte(`{"clientCAs": {"internal": ["/etc/traefik/ca/internal.pem"]}}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if code := run([]string{"list", "--config", cfg, "--from-file", path}); code != 0 || !strings.Contains(buf.String(), "admin.host.tn.ts.net [mtls: internal]\n") {
+        t.Fatalf("expected a configured CA, got %d: %s", code, buf.String())
+    }
+    if !strings.Contains(s, `warning: web: unknown tailwhale.tls.profile "paranoid"`) {
+        t.Fatalf("expected a warning for the unknown profile: %s", s)
+    }
+}
+
+func TestSyncFailsWhenCertDirNotMountedIntoTraefik(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    newProvider = func() dockerx.Provider {
+        return &dockerx.FakeProvider{Items: []dockerx.Info{{ID: "t", Name: "traefik", Image: "traefik:v3.1", Running: true,
+            Mounts: []dockerx.Mount{{Source: "/srv/traefik", Destination: "/etc/traefik"}}}}}
+    }
+    t.Cleanup(func() { out, errOut, newProvider = nil, nil, dockerx.NewProvider })
+
+    tlsPath := filepath.Join(t.TempDir(), "tls.yml")
+    if code := run([]string{"sync", "--traefik-container", "auto", "--cert-dir", "/var/lib/tailwhale/certs", "--tls-path", tlsPath}); code != 1 {
+        t.Fatalf("expected exit 1, got %d: %s", code, buf.String())
+    }
+    if !strings.Contains(buf.String(), `cert dir /var/lib/tailwhale/certs is not mounted into traefik container "traefik"`) {
+        t.Fatalf("unexpected diagnosis: %s", buf.String())
+    }
+    if _, err := os.Stat(tlsPath); err == nil {
+        t.Fatal("nothing should be written when Traefik cannot read the certificates")
+    }
+}
+
+func TestSyncRoutesDiscoveredContainers(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    newProvider = func() dockerx.Provider {
+        return &dockerx.FakeProvider{Items: []dockerx.Info{{ID: "1", Name: "web", Running: true, Ports: []int{80}, Labels: map[string]string{"tailwhale.enable": "true"}}}}
+    }
+    t.Cleanup(func() { out, errOut, newProvider = nil, nil, dockerx.NewProvider })
+
+    tlsPath := filepath.Join(t.TempDir(), "tls.yml")
+    if code := run([]string{"sync", "--host", "host1", "--tailnet", "tn", "--cert-dir", t.TempDir(), "--tls-path", tlsPath}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    data, err := os.ReadFile(tlsPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(buf.String(), "Synced 1 services") || !strings.Contains(string(data), "web.host1.tn.ts.net") {
+        t.Fatalf("expected the discovered container to be routed, got %s\n%s", buf.String(), data)
+    }
+}
+
+func TestWatchRefusesInlineCertsOverHTTPOnPublicAddress(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    t.Cleanup(func() { out, errOut = nil, nil })
+
+    if code := run([]string{"watch", "--host", "host1", "--tailnet", "tn", "--publish", "http", "--inline-certs", "--listen", ":8081"}); code != 2 {
+        t.Fatalf("expected exit 2, got %d: %s", code, buf.String())
+    }
+    if !strings.Contains(buf.String(), "--listen :8081 must be a loopback address") {
+        t.Fatalf("unexpected message: %s", buf.String())
+    }
+    for _, addr := range []string{"127.0.0.1:8081", "[::1]:8081", "localhost:8081"} {
+        if !loopback(addr) { t.Fatalf("%s should count as loopback", addr) }
+    }
+}
+
+func TestSyncLoadsCaddyConfig(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    t.Cleanup(func() { out, errOut = nil, nil })
+
+    var loads int
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost && r.URL.Path == "/load" { loads++ }
+    }))
+    defer srv.Close()
+    if code := run([]string{"sync", "--proxy", "caddy", "--caddy-admin", srv.URL}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    if loads != 1 || !strings.Contains(buf.String(), "Published caddy config to "+srv.URL) {
+        t.Fatalf("expected one /load, got %d: %s", loads, buf.String())
+    }
+}
+
+func TestShiftWritesWeightsToState(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    t.Cleanup(func() { out, errOut = nil, nil })
+
+    dir := t.TempDir()
+    containers, state := filepath.Join(dir, "containers.json"), filepath.Join(dir, "state.json")
+    data := `[{"ID":"1","Name":"api-blue","Labels":{"tailwhale.enable":"true","tailwhale.group":"api","tailwhale.variant":"blue"},"Ports":[80]},
+              {"ID":"2","Name":"api-green","Labels":{"tailwhale.enable":"true","tailwhale.group":"api","tailwhale.variant":"green","tailwhale.weight":"0"},"Ports":[80]}]`
+    if err := os.WriteFile(containers, []byte(data), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if code := run([]string{"shift", "api", "--to", "green", "--percent", "50", "--state", state, "--from-file", containers}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    if !strings.Contains(buf.String(), "api [weights: blue=50 green=50]") {
+        t.Fatalf("unexpected output: %s", buf.String())
+    }
+    buf.Reset()
+    if code := run([]string{"list", "--state", state, "--from-file", containers}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    if !strings.Contains(buf.String(), "- api (1) api.host.tn.ts.net [weights: blue=50 green=50]") {
+        t.Fatalf("list does not show the shifted weights: %s", buf.String())
+    }
+    if code := run([]string{"shift", "api", "--to", "purple", "--state", state, "--from-file", containers}); code != 1 {
+        t.Fatalf("expected exit 1 for an unknown variant, got %d", code)
+    }
+}
+
+func TestListDerivesHostAndTailnetFromTailscaleStatus(t *testing.T) {
+    var buf, warn bytes.Buffer
+    out, errOut = &buf, &warn
+    prev := nodeStatus
+    nodeStatus = func(string) (ts.Status, error) {
+        return ts.Status{MagicDNSSuffix: "tail1234.ts.net", Self: &ts.PeerStatus{HostName: "box", DNSName: "host1.tail1234.ts.net."}}, nil
+    }
+    t.Cleanup(func() { out, errOut, nodeStatus = nil, nil, prev })
+
+    path := filepath.Join(t.TempDir(), "containers.json")
+    if err := os.WriteFile(path, []byte(`[{"ID":"1","Name":"web","Labels":{"tailwhale.enable":"true"},"Ports":[80]}]`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if code := run([]string{"list", "--from-file", path}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, warn.String())
+    }
+    if !strings.Contains(buf.String(), "- web (1) web.host1.tail1234.ts.net") || warn.Len() != 0 {
+        t.Fatalf("unexpected output: %s / %s", buf.String(), warn.String())
+    }
+    buf.Reset()
+    if code := run([]string{"list", "--from-file", path, "--host", "other"}); code != 0 {
+        t.Fatalf("expected exit 0, got %d", code)
+    }
+    if !strings.Contains(buf.String(), "web.other.tail1234.ts.net") || !strings.Contains(warn.String(), `warning: host "other" does not match this node (host1.tail1234.ts.net)`) {
+        t.Fatalf("expected a mismatch warning: %s / %s", buf.String(), warn.String())
+    }
+}
+
+func TestListWarnsAboutHostnamesTailscaledCannotCertify(t *testing.T) {
+    var buf, warn bytes.Buffer
+    out, errOut = &buf, &warn
+    prev := nodeStatus
+    nodeStatus = func(string) (ts.Status, error) {
+        return ts.Status{MagicDNSSuffix: "tn.ts.net", Self: &ts.PeerStatus{DNSName: "host1.tn.ts.net."}, CertDomains: []string{"host1.tn.ts.net"}}, nil
+    }
+    t.Cleanup(func() { out, errOut, nodeStatus = nil, nil, prev })
+
+    path := filepath.Join(t.TempDir(), "containers.json")
+    data := `[{"ID":"1","Name":"web","Labels":{"tailwhale.enable":"true"},"Ports":[80]},
+              {"ID":"2","Name":"docs","Labels":{"tailwhale.enable":"true","tailwhale.routing":"path"},"Ports":[80]}]`
+    if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if code := run([]string{"list", "--from-file", path}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, warn.String())
+    }
+    if warn.String() != "warning: web: tailscaled cannot issue a certificate for web.host1.tn.ts.net; use tailwhale.routing=path or port to serve it under the node's name\n" {
+        t.Fatalf("unexpected warnings: %q", warn.String())
+    }
+}
+
+func TestEntryPointsAllocatesPortsShownByList(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    t.Cleanup(func() { out, errOut = nil, nil })
+
+    dir := t.TempDir()
+    containers, state := filepath.Join(dir, "containers.json"), filepath.Join(dir, "state.json")
+    data := `[{"ID":"1","Name":"web","Labels":{"tailwhale.enable":"true"},"Ports":[80]},
+              {"ID":"2","Name":"proxy","Labels":{},"Ports":[443],"Published":[8443]}]`
+    if err := os.WriteFile(containers, []byte(data), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if code := run([]string{"entrypoints", "--routing", "port", "--state", state, "--from-file", containers}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    if buf.String() != "entryPoints:\n  tailwhale-8444:\n    address: \":8444\"\n" {
+        t.Fatalf("unexpected snippet:\n%s", buf.String())
+    }
+    buf.Reset()
+    if code := run([]string{"list", "--routing", "port", "--host", "host1", "--tailnet", "tn", "--state", state, "--from-file", containers}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    if !strings.Contains(buf.String(), "- web (1) https://host1.tn.ts.net:8444\n") {
+        t.Fatalf("list does not show the allocated port: %s", buf.String())
+    }
+}
+
+func TestFunnelPlanOnAndStatus(t *testing.T) {
+    var buf bytes.Buffer
+    out, errOut = &buf, &buf
+    t.Cleanup(func() { out, errOut = nil, nil })
+
+    // A LocalAPI serving the serve config on a unix socket; 8443 is left open by hand.
+    serve := []byte(`{"AllowFunnel":{"host1.tn.ts.net:8443":true}}`)
+    dir, err := os.MkdirTemp("", "ts") // short path: unix socket names are limited to ~100 bytes
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func() { os.RemoveAll(dir) })
+    sock := filepath.Join(dir, "tailscaled.sock")
+    l, err := net.Listen("unix", sock)
+    if err != nil { t.Fatal(err) }
+    srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodPost { serve, _ = io.ReadAll(r.Body); return }
+        w.Write(serve)
+    })}
+    go srv.Serve(l)
+    t.Cleanup(func() { srv.Close() })
+
+    containers := filepath.Join(dir, "containers.json")
+    if err := os.WriteFile(containers, []byte(`[{"ID":"1","Name":"site","IP":"10.0.0.1","Labels":{"tailwhale.enable":"true","tailwhale.mode":"C"},"Ports":[80]}]`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    args := []string{"--host", "host1", "--tailnet", "tn", "--tailscale-socket", sock, "--state", filepath.Join(dir, "state.json"), "--from-file", containers}
+    if code := run(append([]string{"funnel", "plan"}, args...)); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    want := "+ serve HTTPS on host1.tn.ts.net:443\n+ https://host1.tn.ts.net:443/ -> http://10.0.0.1:80\n+ funnel host1.tn.ts.net:443\n- funnel host1.tn.ts.net:8443\n"
+    if buf.String() != want || strings.Contains(string(serve), ":443\"") {
+        t.Fatalf("unexpected plan (or it was applied):\n%s", buf.String())
+    }
+    buf.Reset()
+    if code := run(append([]string{"funnel", "on"}, args...)); code != 0 || buf.String() != want {
+        t.Fatalf("expected the planned changes, got %d:\n%s", code, buf.String())
+    }
+    buf.Reset()
+    if code := run([]string{"funnel", "status", "--tailscale-socket", sock}); code != 0 {
+        t.Fatalf("expected exit 0, got %d: %s", code, buf.String())
+    }
+    if buf.String() != "public  https://host1.tn.ts.net:443/ -> http://10.0.0.1:80\n" {
+        t.Fatalf("unexpected status:\n%s", buf.String())
+    }
+    buf.Reset()
+    if code := run(append([]string{"funnel", "off"}, args...)); code != 0 || buf.String() != "- funnel host1.tn.ts.net:443\n" {
+        t.Fatalf("expected the funnel to close, got %d:\n%s", code, buf.String())
+    }
+}
+
+func TestApplyConfigKeepsGivenFlags(t *testing.T) {
+    cfg := filepath.Join(t.TempDir(), "tailwhale.json")
+    if err := os.WriteFile(cfg, []byte(`{"host": "filehost", "tailnet": "filenet", "proxyListen": ":8443", "redis": {"db": 3}}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    fs := flag.NewFlagSet("test", flag.ContinueOnError)
+    host, tailnet := fs.String("host", "", ""), fs.String("tailnet", "", "")
+    listen, db := fs.String("listen", ":443", ""), fs.Int("redis-db", 0, "")
+    if err := fs.Parse([]string{"--host", "flaghost"}); err != nil {
+        t.Fatal(err)
+    }
+    applyConfig(fs, cfg, proxyConfigFlags)
+    if *host != "flaghost" || *tailnet != "filenet" || *listen != ":8443" || *db != 3 {
+        t.Fatalf("got host=%s tailnet=%s listen=%s db=%d", *host, *tailnet, *listen, *db)
+    }
+}
@@ -7,10 +7,70 @@ import (
 
 // Config holds runtime settings. Flags override file values.
 type Config struct {
-    Host    string `json:"host"`
-    Tailnet string `json:"tailnet"`
-    TLSPath string `json:"tlsPath"`
-    CertDir string `json:"certDir"`
+    Host        string      `json:"host"`
+    Tailnet     string      `json:"tailnet"`
+    TLSPath     string      `json:"tlsPath"`
+    TLSDir      string      `json:"tlsDir"` // one file per service instead of TLSPath
+    CertDir     string      `json:"certDir"`
+    EntryPoints EntryPoints `json:"entryPoints"`
+    Publish     []string    `json:"publish"`     // watch publishers: file, http, redis
+    Listen      string      `json:"listen"`      // http publisher listen address (default 127.0.0.1:8081)
+    TLSProfile  string      `json:"tlsProfile"`  // default TLS options profile: modern|intermediate
+    VerifyAPI   string      `json:"verifyAPI"`   // Traefik API used to verify each publish
+    VerifyProbe string      `json:"verifyProbe"` // TLS entry point probed by SNI during verification
+    // TraefikContainer names the Traefik container (or "auto") whose mounts certificate paths are mapped through.
+    TraefikContainer string `json:"traefikContainer"`
+    // InlineCerts embeds certificate and key PEM in the dynamic config instead of file paths.
+    InlineCerts bool `json:"inlineCerts"`
+    // ACMEJSON exports certificates into a Traefik acme.json store under ACMEResolver.
+    ACMEJSON     string `json:"acmeJSON"`
+    ACMEResolver string `json:"acmeResolver"`
+    // Proxy selects the backend: traefik (default), caddy, template or envoy.
+    Proxy            string   `json:"proxy"`
+    CaddyAdmin       string   `json:"caddyAdmin"`       // admin API the caddy backend loads into
+    CaddyConfig      string   `json:"caddyConfig"`      // write the Caddy config here instead
+    CaddyListen      []string `json:"caddyListen"`      // listen addresses of the generated server (default :443)
+    CaddyAdminListen string   `json:"caddyAdminListen"` // admin listen address kept across /load
+    XDSListen        string   `json:"xdsListen"`        // gRPC address of the xDS server (proxy "envoy")
+    ProxyListen      string   `json:"proxyListen"`      // HTTPS address of the built-in proxy (tailwhale proxy)
+    // Template configures the template backend (proxy "template").
+    Template Template `json:"template"`
+    // Redis configures the redis publisher (Traefik providers.redis).
+    Redis Redis `json:"redis"`
+    // TailscaleSocket is tailscaled's LocalAPI socket; when set, certificates are issued through it.
+    TailscaleSocket string `json:"tailscaleSocket"`
+    // Funnel makes watch keep Tailscale Funnel in line with the Mode C services.
+    Funnel bool `json:"funnel"`
+    // Routing is the default Mode A routing strategy: subdomain (default), path or port.
+    Routing string `json:"routing"`
+    // StateFile is the runtime state written by tailwhale shift (default /var/lib/tailwhale/state.json).
+    StateFile string `json:"stateFile"`
+    // ClientCAs names CA bundles (paths readable by Traefik) that tailwhale.mtls.ca labels refer to.
+    ClientCAs map[string][]string `json:"clientCAs"`
+}
+
+// Template points the template backend at a text/template file and its output.
+type Template struct {
+    Path   string   `json:"path"`
+    Output string   `json:"output"`
+    Reload []string `json:"reload"` // command run after the output changed, e.g. ["nginx", "-s", "reload"]
+}
+
+// Redis points the redis publisher at the Redis instance Traefik's KV provider reads.
+type Redis struct {
+    Addr     string `json:"addr"`
+    Password string `json:"password"`
+    DB       int    `json:"db"`
+    Prefix   string `json:"prefix"` // Traefik's rootKey (default traefik)
+}
+
+// EntryPoints names the Traefik entry points generated routers bind to.
+// Empty values fall back to websecure (http, tcp), udp and web (redirect routers).
+type EntryPoints struct {
+    HTTP string `json:"http"`
+    TCP  string `json:"tcp"`
+    UDP  string `json:"udp"`
+    Web  string `json:"web"`
 }
 
 // Load reads a JSON config file. If path is empty, returns zero Config.
@@ -0,0 +1,120 @@
+package caddy
+
+import "sort"
+
+// DefaultServer is the name of the HTTP server TailWhale generates.
+const DefaultServer = "tailwhale"
+
+// DefaultListen is the address the generated server listens on.
+const DefaultListen = ":443"
+
+// Site is one HTTPS host proxied to its upstreams with a TailWhale-managed certificate.
+type Site struct {
+    Host      string
+    Upstreams []string // host:port dial addresses
+    CertFile  string
+    KeyFile   string
+    AllowList []string // client CIDRs; others get 403
+}
+
+// Options tune the generated config.
+type Options struct {
+    Listen      []string // defaults to DefaultListen
+    AdminListen string   // kept in the loaded config so /load does not reset the admin endpoint
+}
+
+// Config is the subset of Caddy's JSON config TailWhale generates.
+type Config struct {
+    Admin *Admin `json:"admin,omitempty"`
+    Apps  Apps   `json:"apps"`
+}
+
+type Admin struct {
+    Listen string `json:"listen,omitempty"`
+}
+
+type Apps struct {
+    HTTP *HTTPApp `json:"http,omitempty"`
+    TLS  *TLSApp  `json:"tls,omitempty"`
+}
+
+type HTTPApp struct {
+    Servers map[string]Server `json:"servers"`
+}
+
+type Server struct {
+    Listen []string `json:"listen"`
+    Routes []Route  `json:"routes"`
+}
+
+type Route struct {
+    Match    []Match   `json:"match,omitempty"`
+    Handle   []Handler `json:"handle"`
+    Terminal bool      `json:"terminal,omitempty"`
+}
+
+type Match struct {
+    Host     []string  `json:"host,omitempty"`
+    RemoteIP *RemoteIP `json:"remote_ip,omitempty"`
+}
+
+type RemoteIP struct {
+    Ranges []string `json:"ranges"`
+}
+
+// Handler covers the reverse_proxy and static_response handlers.
+type Handler struct {
+    Handler    string     `json:"handler"`
+    Upstreams  []Upstream `json:"upstreams,omitempty"`
+    StatusCode int        `json:"status_code,omitempty"`
+}
+
+type Upstream struct {
+    Dial string `json:"dial"`
+}
+
+type TLSApp struct {
+    Certificates Certificates `json:"certificates"`
+}
+
+type Certificates struct {
+    LoadFiles []LoadFile `json:"load_files"`
+}
+
+// LoadFile loads a certificate/key pair from disk; Caddy then skips automatic HTTPS for its names.
+type LoadFile struct {
+    Certificate string   `json:"certificate"`
+    Key         string   `json:"key"`
+    Tags        []string `json:"tags,omitempty"`
+}
+
+// Build renders sites into a Caddy config with one HTTPS server and the certificates to load.
+// Sites without upstreams still get their certificate but no route.
+func Build(sites []Site, opt Options) Config {
+    sorted := append([]Site(nil), sites...)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Host < sorted[j].Host })
+    listen := opt.Listen
+    if len(listen) == 0 { listen = []string{DefaultListen} }
+    srv := Server{Listen: listen, Routes: []Route{}}
+    tls := &TLSApp{Certificates: Certificates{LoadFiles: []LoadFile{}}}
+    for _, s := range sorted {
+        if s.Host == "" { continue }
+        if s.CertFile != "" && s.KeyFile != "" {
+            tls.Certificates.LoadFiles = append(tls.Certificates.LoadFiles, LoadFile{Certificate: s.CertFile, Key: s.KeyFile, Tags: []string{DefaultServer}})
+        }
+        if len(s.Upstreams) == 0 { continue }
+        proxy := Handler{Handler: "reverse_proxy"}
+        for _, u := range s.Upstreams { proxy.Upstreams = append(proxy.Upstreams, Upstream{Dial: u}) }
+        match := Match{Host: []string{s.Host}}
+        if len(s.AllowList) > 0 { match.RemoteIP = &RemoteIP{Ranges: s.AllowList} }
+        srv.Routes = append(srv.Routes, Route{Match: []Match{match}, Handle: []Handler{proxy}, Terminal: true})
+        if len(s.AllowList) > 0 {
+            deny := Handler{Handler: "static_response", StatusCode: 403}
+            srv.Routes = append(srv.Routes, Route{Match: []Match{{Host: []string{s.Host}}}, Handle: []Handler{deny}, Terminal: true})
+        }
+    }
+    cfg := Config{Apps: Apps{HTTP: &HTTPApp{Servers: map[string]Server{DefaultServer: srv}}, TLS: tls}}
+    if opt.AdminListen != "" { cfg.Admin = &Admin{Listen: opt.AdminListen} }
+    return cfg
+}
+
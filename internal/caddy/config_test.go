@@ -0,0 +1,25 @@
+package caddy
+
+import (
+    "encoding/json"
+    "testing"
+)
+
+func TestBuildRendersSitesAndCertificates(t *testing.T){
+    sites := []Site{
+        {Host: "web.tn.ts.net", Upstreams: []string{"172.18.0.2:80"}, CertFile: "/certs/web.crt", KeyFile: "/certs/web.key"},
+        {Host: "admin.tn.ts.net", Upstreams: []string{"admin:8080"}, CertFile: "/certs/admin.crt", KeyFile: "/certs/admin.key", AllowList: []string{"100.64.0.0/10"}},
+        {Host: "idle.tn.ts.net", CertFile: "/certs/idle.crt", KeyFile: "/certs/idle.key"},
+    }
+    b, err := json.Marshal(Build(sites, Options{AdminListen: "0.0.0.0:2019"}))
+    if err != nil { t.Fatal(err) }
+    want := `{"admin":{"listen":"0.0.0.0:2019"},"apps":{"http":{"servers":{"tailwhale":{"listen":[":443"],"routes":[` +
+        `{"match":[{"host":["admin.tn.ts.net"],"remote_ip":{"ranges":["100.64.0.0/10"]}}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"admin:8080"}]}],"terminal":true},` +
+        `{"match":[{"host":["admin.tn.ts.net"]}],"handle":[{"handler":"static_response","status_code":403}],"terminal":true},` +
+        `{"match":[{"host":["web.tn.ts.net"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"172.18.0.2:80"}]}],"terminal":true}]}}},` +
+        `"tls":{"certificates":{"load_files":[` +
+        `{"certificate":"/certs/admin.crt","key":"/certs/admin.key","tags":["tailwhale"]},` +
+        `{"certificate":"/certs/idle.crt","key":"/certs/idle.key","tags":["tailwhale"]},` +
+        `{"certificate":"/certs/web.crt","key":"/certs/web.key","tags":["tailwhale"]}]}}}}`
+    if string(b) != want { t.Fatalf("unexpected config:\n%s", b) }
+}
@@ -0,0 +1,66 @@
+package caddy
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+)
+
+// DefaultAdmin is Caddy's default admin API address.
+const DefaultAdmin = "http://localhost:2019"
+
+// Publisher delivers a rendered config to Caddy.
+type Publisher interface {
+    Publish(cfg Config) error
+}
+
+// AdminPublisher replaces Caddy's running config through the admin API's /load endpoint.
+// /load swaps the whole config, so the Caddy instance must be dedicated to TailWhale.
+type AdminPublisher struct {
+    URL     string // defaults to DefaultAdmin
+    Client  *http.Client
+    Timeout time.Duration
+}
+
+func (p AdminPublisher) Publish(cfg Config) error {
+    body, err := json.Marshal(cfg)
+    if err != nil { return err }
+    timeout := p.Timeout
+    if timeout == 0 { timeout = 10 * time.Second }
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    base := p.URL
+    if base == "" { base = DefaultAdmin }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(base, "/")+"/load", bytes.NewReader(body))
+    if err != nil { return err }
+    req.Header.Set("Content-Type", "application/json")
+    c := p.Client
+    if c == nil { c = http.DefaultClient }
+    resp, err := c.Do(req)
+    if err != nil { return fmt.Errorf("caddy load: %w", err) }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+        return fmt.Errorf("caddy load: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+    }
+    return nil
+}
+
+// FilePublisher writes the config as JSON for `caddy run --config <path>`.
+type FilePublisher struct {
+    Path string
+}
+
+func (p FilePublisher) Publish(cfg Config) error {
+    data, err := json.MarshalIndent(cfg, "", "  ")
+    if err != nil { return err }
+    return fsx.WriteFileAtomic(p.Path, append(data, '\n'), 0o644)
+}
+
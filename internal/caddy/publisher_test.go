@@ -0,0 +1,32 @@
+package caddy
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestAdminPublisherPostsLoad(t *testing.T){
+    var got Config
+    var path, ctype string
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        path, ctype = r.Method+" "+r.URL.Path, r.Header.Get("Content-Type")
+        _ = json.NewDecoder(r.Body).Decode(&got)
+    }))
+    defer srv.Close()
+    cfg := Build([]Site{{Host: "web.tn.ts.net", Upstreams: []string{"web:80"}}}, Options{})
+    if err := (AdminPublisher{URL: srv.URL}).Publish(cfg); err != nil { t.Fatal(err) }
+    if path != "POST /load" || ctype != "application/json" { t.Fatalf("unexpected request: %s (%s)", path, ctype) }
+    if got.Apps.HTTP.Servers[DefaultServer].Routes[0].Match[0].Host[0] != "web.tn.ts.net" { t.Fatalf("unexpected body: %+v", got) }
+}
+
+func TestAdminPublisherReportsCaddyError(t *testing.T){
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, `{"error":"loading config: unknown handler"}`, http.StatusBadRequest)
+    }))
+    defer srv.Close()
+    err := AdminPublisher{URL: srv.URL}.Publish(Build(nil, Options{}))
+    if err == nil || !strings.Contains(err.Error(), "400 Bad Request") || !strings.Contains(err.Error(), "unknown handler") { t.Fatalf("unexpected error: %v", err) }
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+    "strings"
+
+    "github.com/frnwtr/tailwhale/internal/caddy"
+    "github.com/frnwtr/tailwhale/internal/envoy"
+    "github.com/frnwtr/tailwhale/internal/mapx"
+    "github.com/frnwtr/tailwhale/internal/proxy"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
+
+// Backend renders services and their certificates for a reverse proxy and delivers the result.
+type Backend interface {
+    Apply(svcs []Service, certs tcfg.TLSConfig) error
+}
+
+// BackendFunc adapts a function to Backend.
+type BackendFunc func(svcs []Service, certs tcfg.TLSConfig) error
+
+func (f BackendFunc) Apply(svcs []Service, certs tcfg.TLSConfig) error { return f(svcs, certs) }
+
+// TraefikBackend publishes the Traefik dynamic config (routers, services and tls) for services.
+type TraefikBackend struct {
+    Options   tcfg.Options // entry point names and other rendering options
+    Publisher tcfg.Publisher
+}
+
+func (b TraefikBackend) Apply(svcs []Service, certs tcfg.TLSConfig) error {
+    return b.Publisher.Publish(tcfg.Build(Routes(svcs), certs, b.Options))
+}
+
+// CaddyBackend publishes a Caddy config proxying HTTP services. TCP/UDP services are
+// Traefik-only and skipped, as are services with security labels Caddy can't enforce.
+type CaddyBackend struct {
+    Options   caddy.Options
+    Publisher caddy.Publisher
+}
+
+func (b CaddyBackend) Apply(svcs []Service, certs tcfg.TLSConfig) error {
+    return b.Publisher.Publish(caddy.Build(CaddySites(svcs, certs), b.Options))
+}
+
+// CaddySites translates HTTP services routed through the proxy (modes A and C) into Caddy sites.
+// Path- and port-routed services share the node's hostname and are left out, as with Envoy
+// and the built-in proxy; only Traefik and templates route them (see Orchestrator.HostRoutedOnly).
+// Services with labels CaddyUnenforced lists are left out too.
+func CaddySites(svcs []Service, certs tcfg.TLSConfig) []caddy.Site {
+    var out []caddy.Site
+    for _, s := range svcs {
+        if s.Mode == ModeB || !s.hostRouted() || (s.Protocol != "" && s.Protocol != tcfg.ProtocolHTTP) || len(CaddyUnenforced(s)) > 0 { continue }
+        site := caddy.Site{
+            Host:      strings.TrimPrefix(s.Host, "https://"),
+            CertFile:  certs[s.Host].CertFile,
+            KeyFile:   certs[s.Host].KeyFile,
+            Upstreams: s.Upstreams(),
+            AllowList: s.Middlewares.AllowList,
+        }
+        out = append(out, site)
+    }
+    return out
+}
+
+// EnvoyBackend pushes an xDS snapshot (listener, routes, clusters and SDS secrets) to Envoy.
+// Certificates travel inline over SDS, so Envoy needs no access to the cert dir.
+type EnvoyBackend struct {
+    Options envoy.Options
+    Server  envoy.Server
+}
+
+func (b EnvoyBackend) Apply(svcs []Service, certs tcfg.TLSConfig) error {
+    snap, err := envoy.Build(EnvoySites(svcs, certs), b.Options)
+    if err != nil { return err }
+    return b.Server.Push(snap)
+}
+
+// EnvoySites translates HTTP and TCP services routed through the proxy (modes A and C) into Envoy sites.
+// TCP services using TLS passthrough are not supported with Envoy and are skipped, as are
+// services with labels EnvoyUnenforced lists; replicated services are sent to their first
+// replica only, and groups to their first variant with traffic.
+func EnvoySites(svcs []Service, certs tcfg.TLSConfig) []envoy.Site {
+    var out []envoy.Site
+    for _, s := range svcs {
+        if s.Mode == ModeB || !s.hostRouted() || s.Protocol == tcfg.ProtocolUDP || (s.Protocol == tcfg.ProtocolTCP && s.TLS.Passthrough) || len(EnvoyUnenforced(s)) > 0 { continue }
+        address, port := s.Address, s.Port
+        if vs := s.ActiveVariants(); len(vs) > 0 && len(vs[0].Replicas) > 0 { address, port = vs[0].Replicas[0].Address, vs[0].Replicas[0].Port }
+        out = append(out, envoy.Site{
+            Name:     RouteName(s.Name),
+            Host:     strings.TrimPrefix(s.Host, "https://"),
+            Protocol: s.Protocol,
+            Address:  address,
+            Port:     port,
+            CertFile: certs[s.Host].CertFile,
+            KeyFile:  certs[s.Host].KeyFile,
+        })
+    }
+    return out
+}
+
+// ProxyBackend updates the routing table of TailWhale's built-in reverse proxy.
+// Certificates are loaded by the proxy itself, by SNI, so certs is not used.
+type ProxyBackend struct {
+    Server *proxy.Server
+}
+
+func (b ProxyBackend) Apply(svcs []Service, certs tcfg.TLSConfig) error {
+    return b.Server.Update(ProxyRoutes(svcs))
+}
+
+// ProxyRoutes translates HTTP and TCP services routed through the proxy (modes A and C) into built-in proxy routes.
+// Only the allowlist middleware is enforced: services with labels ProxyUnenforced lists are left out.
+func ProxyRoutes(svcs []Service) []proxy.Route {
+    var out []proxy.Route
+    for _, s := range svcs {
+        if s.Mode == ModeB || !s.hostRouted() || s.Port == 0 || s.Address == "" || s.Protocol == tcfg.ProtocolUDP || len(ProxyUnenforced(s)) > 0 { continue }
+        r := proxy.Route{
+            Host:      strings.TrimPrefix(s.Host, "https://"),
+            Upstreams: s.Upstreams(),
+            Scheme:    s.Scheme,
+            AllowList: s.Middlewares.AllowList,
+        }
+        if s.Protocol == tcfg.ProtocolTCP {
+            r.Protocol, r.Scheme, r.Passthrough = proxy.ProtocolTCP, "", s.TLS.Passthrough
+        }
+        out = append(out, r)
+    }
+    return out
+}
+
+// CaddyUnenforced lists the security labels of s Caddy sites can't enforce: all but the allowlist.
+func CaddyUnenforced(s Service) []string { return unenforced(s, LabelAllowList) }
+
+// EnvoyUnenforced lists the security labels of s Envoy sites can't enforce: all of them.
+func EnvoyUnenforced(s Service) []string { return unenforced(s) }
+
+// ProxyUnenforced lists the security labels of s the built-in proxy can't enforce: all but the allowlist.
+func ProxyUnenforced(s Service) []string { return unenforced(s, LabelAllowList) }
+
+// unenforced lists the security labels set on s, apart from those in enforced: the mTLS CA,
+// basic auth, the allowlist and the TLS version and cipher labels. A backend that can't
+// enforce one of them leaves the service out rather than serve it without.
+func unenforced(s Service, enforced ...string) []string {
+    set := map[string]bool{
+        LabelMTLSCA:        s.TLS.ClientCA != "",
+        LabelBasicAuthFile: s.Middlewares.BasicAuthUsersFile != "",
+        LabelAllowList:     len(s.Middlewares.AllowList) > 0,
+        LabelTLSProfile:    s.TLS.Profile != "",
+        LabelTLSMin:        s.TLS.MinVersion != "",
+        LabelTLSCiphers:    len(s.TLS.CipherSuites) > 0,
+    }
+    for _, l := range enforced { delete(set, l) }
+    var out []string
+    for _, l := range mapx.SortedKeys(set) {
+        if set[l] { out = append(out, l) }
+    }
+    return out
+}
+
+// hostRouted reports whether s is told apart by its hostname alone, i.e. not path- or port-routed.
+func (s Service) hostRouted() bool { return s.Routing != RoutingPath && s.Routing != RoutingPort }
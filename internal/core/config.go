@@ -1,13 +1,79 @@
 package core
 
-import "strings"
+import (
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/frnwtr/tailwhale/internal/mapx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
 
 const (
-    LabelEnable = "tailwhale.enable"
-    LabelHost   = "tailwhale.host"
-    LabelMode   = "tailwhale.mode" // values: A|B|C
+    LabelEnable     = "tailwhale.enable"
+    LabelHost       = "tailwhale.host"
+    LabelMode       = "tailwhale.mode"       // values: A|B|C
+    LabelPort       = "tailwhale.port"       // backend port; defaults to the first exposed port
+    LabelProtocol   = "tailwhale.protocol"   // values: http|tcp|udp
+    LabelEntryPoint = "tailwhale.entrypoint" // overrides the Traefik entry point for this service
+    LabelScheme     = "tailwhale.scheme"     // upstream scheme of http services: http|h2c
+    LabelTLS        = "tailwhale.tls"            // tcp services: terminate (default) or passthrough
+    LabelTLSProfile = "tailwhale.tls.profile"    // modern|intermediate
+    LabelTLSMin     = "tailwhale.tls.minVersion" // e.g. 1.2 or VersionTLS12
+    LabelTLSCiphers = "tailwhale.tls.ciphers"    // comma-separated cipher suite names
+    LabelTLSStore   = "tailwhale.tls.store"      // certificate store (Traefik only honours "default")
+    LabelMTLSCA     = "tailwhale.mtls.ca"        // client CA bundle name from the config file
 )
 
+// Load balancing labels for services with several replicas (or a single container).
+const (
+    LabelSticky         = "tailwhale.lb.sticky"               // true or a cookie name
+    LabelHealthPath     = "tailwhale.lb.healthcheck.path"     // e.g. /healthz
+    LabelHealthInterval = "tailwhale.lb.healthcheck.interval" // e.g. 10s
+    LabelHealthTimeout  = "tailwhale.lb.healthcheck.timeout"  // e.g. 3s
+)
+
+// Weighted routing labels: services sharing a group get one hostname and split its traffic.
+const (
+    LabelGroup   = "tailwhale.group"   // group name, used as the hostname's first label
+    LabelWeight  = "tailwhale.weight"  // relative share of the group's traffic (default 1, 0 drains)
+    LabelVariant = "tailwhale.variant" // variant name within the group, e.g. blue or green; defaults to the service name
+)
+
+// Mode A routing labels. Tailscale only issues certificates for the node's own name, so
+// path and port routing serve every service under host.tailnet.ts.net with one certificate.
+const (
+    LabelRouting     = "tailwhale.routing"      // subdomain (default), path or port
+    LabelRoutingPath = "tailwhale.routing.path" // path prefix for path routing; defaults to /<service>
+    LabelRoutingPort = "tailwhale.routing.port" // HTTPS port for port routing
+)
+
+// Mode C labels: where tailscaled publishes the service through Funnel.
+const (
+    LabelFunnelPort = "tailwhale.funnel.port" // 443 (default), 8443 or 10000
+    LabelFunnelPath = "tailwhale.funnel.path" // mount point, default /
+)
+
+// Mode A routing strategies.
+const (
+    RoutingSubdomain = "subdomain" // <service>.<host>.<tailnet>.ts.net
+    RoutingPath      = "path"      // <host>.<tailnet>.ts.net/<service>/
+    RoutingPort      = "port"      // <host>.<tailnet>.ts.net:<port>
+)
+
+// DefaultWeight is the weight of group members without a tailwhale.weight label.
+const DefaultWeight = 1
+
+// Labels set by Docker Compose; containers sharing both are replicas of one service.
+const (
+    LabelComposeProject = "com.docker.compose.project"
+    LabelComposeService = "com.docker.compose.service"
+)
+
+// LabelCollapse controls whether Compose replicas collapse into one <project>-<service> service:
+// true always (a stable name from the first replica on), false never; unset collapses from two replicas.
+const LabelCollapse = "tailwhale.compose.collapse"
+
 // ParseMode maps string labels to ExposureMode.
 func ParseMode(s string) ExposureMode {
     switch strings.ToUpper(strings.TrimSpace(s)) {
@@ -22,3 +88,102 @@ func ParseMode(s string) ExposureMode {
     }
 }
 
+// ParseProtocol normalizes the protocol label; unknown values mean http.
+func ParseProtocol(s string) string {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "tcp":
+        return "tcp"
+    case "udp":
+        return "udp"
+    default:
+        return "http"
+    }
+}
+
+// ParseScheme normalizes the upstream scheme label; unknown values mean http.
+func ParseScheme(s string) string {
+    if strings.EqualFold(strings.TrimSpace(s), "h2c") { return "h2c" }
+    return "http"
+}
+
+// ParseLoadBalancing reads the tailwhale.lb.* labels.
+func ParseLoadBalancing(labels map[string]string) tcfg.LoadBalancing {
+    lb := tcfg.LoadBalancing{
+        HealthPath:     strings.TrimSpace(labels[LabelHealthPath]),
+        HealthInterval: strings.TrimSpace(labels[LabelHealthInterval]),
+        HealthTimeout:  strings.TrimSpace(labels[LabelHealthTimeout]),
+    }
+    switch v := strings.TrimSpace(labels[LabelSticky]); strings.ToLower(v) {
+    case "", "false":
+    case "true":
+        lb.StickyCookie = "true"
+    default:
+        lb.StickyCookie = v
+    }
+    return lb
+}
+
+// ParseRouting normalizes a routing strategy, falling back to def (or subdomain) when empty or unknown.
+func ParseRouting(s, def string) string {
+    switch v := strings.ToLower(strings.TrimSpace(s)); v {
+    case RoutingSubdomain, RoutingPath, RoutingPort:
+        return v
+    }
+    if def == RoutingPath || def == RoutingPort { return def }
+    return RoutingSubdomain
+}
+
+// ParsePathPrefix normalizes a path prefix to /a/b without a trailing slash; empty stays empty.
+func ParsePathPrefix(s string) string {
+    s = strings.Trim(strings.TrimSpace(s), "/")
+    if s == "" { return "" }
+    return "/" + s
+}
+
+// ParseWeight reads a tailwhale.weight value, falling back to DefaultWeight when missing or invalid.
+func ParseWeight(s string) int {
+    if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && n >= 0 { return n }
+    return DefaultWeight
+}
+
+// ParsePort returns the backend port from the label value, falling back to the first known port.
+func ParsePort(s string, ports []int) int {
+    if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && n > 0 && n < 65536 {
+        return n
+    }
+    if len(ports) > 0 {
+        return ports[0]
+    }
+    return 0
+}
+
+// ParseTLS reads the tailwhale.tls.* labels into per-route TLS settings.
+// Unknown profiles, versions and cipher suites are left out and described in warnings.
+func ParseTLS(labels map[string]string) (tcfg.RouteTLS, []string) {
+    t := tcfg.RouteTLS{
+        Profile:     strings.ToLower(strings.TrimSpace(labels[LabelTLSProfile])),
+        MinVersion:  tcfg.NormalizeTLSVersion(labels[LabelTLSMin]),
+        ClientCA:    strings.TrimSpace(labels[LabelMTLSCA]),
+        Passthrough: strings.EqualFold(strings.TrimSpace(labels[LabelTLS]), "passthrough"),
+    }
+    var warnings []string
+    if _, ok := tcfg.TLSProfiles[t.Profile]; t.Profile != "" && !ok {
+        warnings = append(warnings, fmt.Sprintf("unknown %s %q (want %s); using the default profile", LabelTLSProfile, t.Profile, strings.Join(mapx.SortedKeys(tcfg.TLSProfiles), " or ")))
+        t.Profile = ""
+    }
+    if v := strings.TrimSpace(labels[LabelTLSMin]); v != "" && t.MinVersion == "" {
+        warnings = append(warnings, fmt.Sprintf("unknown %s %q (want 1.0 to 1.3); ignored", LabelTLSMin, v))
+    }
+    for _, c := range strings.Split(labels[LabelTLSCiphers], ",") {
+        c = strings.TrimSpace(c)
+        switch {
+        case c == "":
+        case !tcfg.KnownCipherSuite(c):
+            warnings = append(warnings, fmt.Sprintf("unknown cipher suite %q in %s; ignored", c, LabelTLSCiphers))
+        default:
+            t.CipherSuites = append(t.CipherSuites, c)
+        }
+    }
+    return t, warnings
+}
+
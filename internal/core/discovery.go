@@ -1,43 +1,228 @@
 package core
 
 import (
+    "fmt"
     "sort"
+    "strings"
 
     "github.com/frnwtr/tailwhale/internal/dockerx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
 )
 
+// Discovery computes the services to expose on one node from container labels.
+type Discovery struct {
+    Host    string
+    Tailnet string
+    // Routing is the Mode A routing strategy of services without a tailwhale.routing label;
+    // empty means subdomain.
+    Routing string
+}
+
 // Discover returns the list of services to expose based on container labels.
 func Discover(p dockerx.Provider, host, tailnet string) ([]Service, error) {
+    return Discovery{Host: host, Tailnet: tailnet}.Discover(p)
+}
+
+// DiscoverFromInfos computes services from a pre-fetched container list.
+func DiscoverFromInfos(list []dockerx.Info, host, tailnet string) []Service {
+    return Discovery{Host: host, Tailnet: tailnet}.FromInfos(list)
+}
+
+// Discover lists the provider's containers and computes their services.
+func (d Discovery) Discover(p dockerx.Provider) ([]Service, error) {
     list, err := p.List()
     if err != nil {
         return nil, err
     }
-    return DiscoverFromInfos(list, host, tailnet), nil
+    return d.FromInfos(list), nil
 }
 
-// DiscoverFromInfos computes services from a pre-fetched container list.
-func DiscoverFromInfos(list []dockerx.Info, host, tailnet string) []Service {
+// FromInfos computes services from a pre-fetched container list.
+// Compose replicas (same com.docker.compose.project and .service) collapse into one
+// service named <project>-<service> once there are two of them, or from the first one
+// with tailwhale.compose.collapse=true, so scaling changes only its replicas, never its
+// hostname or certificate. Labels are read from the replica with the lowest name; each
+// replica keeps its own backend port. Services sharing a tailwhale.group are then merged
+// into weighted variants of one service.
+func (d Discovery) FromInfos(list []dockerx.Info) []Service {
+    sorted := append([]dockerx.Info(nil), list...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+    collapse := collapsedKeys(sorted)
     var out []Service
-    for _, c := range list {
+    replicas := map[string]int{} // compose key -> index in out
+    for _, c := range sorted {
         if c.Labels[LabelEnable] != "true" {
             continue
         }
+        address := c.Name
+        if c.IP != "" {
+            address = c.IP
+        }
+        replica := Replica{Address: address, Port: ParsePort(c.Labels[LabelPort], c.Ports)}
+        key := composeKey(c.Labels)
+        if !collapse[key] { key = "" }
+        if i, ok := replicas[key]; ok && key != "" {
+            out[i].Replicas = append(out[i].Replicas, replica)
+            continue
+        }
+        name := c.Name
+        if key != "" {
+            name = c.Labels[LabelComposeProject] + "-" + c.Labels[LabelComposeService]
+            replicas[key] = len(out)
+        }
         mode := ParseMode(c.Labels[LabelMode])
         svc := Service{
-            ID:      c.ID,
-            Name:    c.Name,
-            Ports:   c.Ports,
-            Exposed: true,
-            Mode:    mode,
-        }
-        if h := c.Labels[LabelHost]; h != "" {
-            svc.HostAlias = h
-            svc.Host = h
-        } else {
-            svc.Host = HostnameFor(mode, NameInput{Container: c.Name, Host: host, Tailnet: tailnet})
+            ID:            c.ID,
+            Name:          name,
+            Ports:         c.Ports,
+            Port:          replica.Port,
+            Address:       address,
+            Replicas:      []Replica{replica},
+            Protocol:      ParseProtocol(c.Labels[LabelProtocol]),
+            Scheme:        ParseScheme(c.Labels[LabelScheme]),
+            EntryPoint:    c.Labels[LabelEntryPoint],
+            Middlewares:   ParseMiddlewares(c.Labels),
+            TLSStore:      strings.TrimSpace(c.Labels[LabelTLSStore]),
+            LoadBalancing: ParseLoadBalancing(c.Labels),
+            Exposed:       true,
+            Mode:          mode,
+            Group:         strings.TrimSpace(c.Labels[LabelGroup]),
+            Routing:       ParseRouting(c.Labels[LabelRouting], d.Routing),
+            PathPrefix:    ParsePathPrefix(c.Labels[LabelRoutingPath]),
+            ListenPort:    ParsePort(c.Labels[LabelRoutingPort], nil),
+        }
+        if mode == ModeC {
+            svc.FunnelPort = ParsePort(c.Labels[LabelFunnelPort], nil)
+            svc.FunnelPath = ParsePathPrefix(c.Labels[LabelFunnelPath])
         }
+        if svc.Group != "" {
+            variant := strings.TrimSpace(c.Labels[LabelVariant])
+            if variant == "" { variant = name }
+            svc.Variants = []Variant{{Name: variant, Weight: ParseWeight(c.Labels[LabelWeight])}}
+        }
+        svc.TLS, svc.Warnings = ParseTLS(c.Labels)
+        if mode == ModeC && svc.Protocol == tcfg.ProtocolUDP {
+            svc.Warnings = append(svc.Warnings, "Funnel does not carry UDP; the service is only reachable from the tailnet")
+        }
+        svc.HostAlias = c.Labels[LabelHost]
+        if svc.Group == "" { d.route(&svc, name) } // groups are routed by their name in mergeGroups
         out = append(out, svc)
     }
+    out = d.mergeGroups(out)
     sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    return dropRouteCollisions(out)
+}
+
+// dropRouteCollisions keeps the first of the services whose names map to the same
+// RouteName (e.g. "my_app" and "my-app"), since backends would overwrite one with the
+// other; the kept service carries a warning naming the dropped ones.
+func dropRouteCollisions(svcs []Service) []Service {
+    var out []Service
+    seen := map[string]int{} // route name -> index in out
+    for _, s := range svcs {
+        rn := RouteName(s.Name)
+        if i, ok := seen[rn]; ok {
+            out[i].Warnings = append(out[i].Warnings, fmt.Sprintf("%q has the same router name %q and is ignored", s.Name, rn))
+            continue
+        }
+        seen[rn] = len(out)
+        out = append(out, s)
+    }
+    return out
+}
+
+// mergeGroups folds the members of each group into one service named after the group, with
+// one variant per member (members naming the same variant share it). Labels other than the
+// weights come from the member with the lowest name.
+func (d Discovery) mergeGroups(svcs []Service) []Service {
+    var out []Service
+    groups := map[string]int{} // group -> index in out
+    for _, s := range svcs {
+        if s.Group == "" { out = append(out, s); continue }
+        v := s.Variants[0]
+        v.Replicas = s.Replicas
+        i, ok := groups[s.Group]
+        if !ok {
+            groups[s.Group] = len(out)
+            s.Name, s.Variants = s.Group, nil
+            d.route(&s, s.Group)
+            out = append(out, s)
+            i = len(out) - 1
+        }
+        g := &out[i]
+        if j := variantIndex(g.Variants, v.Name); j >= 0 {
+            g.Variants[j].Replicas = append(g.Variants[j].Replicas, v.Replicas...)
+            continue
+        }
+        g.Variants = append(g.Variants, v)
+    }
+    for _, i := range groups {
+        sort.Slice(out[i].Variants, func(a, b int) bool { return out[i].Variants[a].Name < out[i].Variants[b].Name })
+    }
+    return out
+}
+
+// route sets the hostname a service named name is reached at, and settles its routing.
+// Routing only applies to Mode A (paths to http services only): there every service shares
+// the node's name, told apart by path prefix (/<name> unless labelled) or by port.
+func (d Discovery) route(s *Service, name string) {
+    switch {
+    case s.Mode != ModeA:
+        s.Routing = ""
+    case s.Routing == RoutingPath && s.Protocol != tcfg.ProtocolHTTP:
+        s.Routing = RoutingSubdomain
+    }
+    switch {
+    case s.Routing != RoutingPath:
+        s.PathPrefix = ""
+    case s.PathPrefix == "":
+        s.PathPrefix = "/" + RouteName(name)
+    }
+    if s.Routing != RoutingPort { s.ListenPort = 0 }
+    switch {
+    case s.HostAlias != "":
+        s.Host = s.HostAlias
+    case s.Routing == RoutingPath || s.Routing == RoutingPort:
+        s.Host = NodeHostname(d.Host, d.Tailnet)
+    default:
+        s.Host = HostnameFor(s.Mode, NameInput{Container: name, Host: d.Host, Tailnet: d.Tailnet})
+    }
+}
+
+func variantIndex(vs []Variant, name string) int {
+    for i, v := range vs {
+        if v.Name == name { return i }
+    }
+    return -1
+}
+
+// collapsedKeys returns the Compose services whose replicas collapse into one service:
+// those with two or more enabled replicas, or labelled tailwhale.compose.collapse=true on
+// the replica with the lowest name, unless that label is false.
+func collapsedKeys(sorted []dockerx.Info) map[string]bool {
+    count, label := map[string]int{}, map[string]string{}
+    for _, c := range sorted {
+        key := composeKey(c.Labels)
+        if key == "" || c.Labels[LabelEnable] != "true" { continue }
+        if count[key] == 0 { label[key] = strings.ToLower(strings.TrimSpace(c.Labels[LabelCollapse])) }
+        count[key]++
+    }
+    out := map[string]bool{}
+    for key, n := range count {
+        switch label[key] {
+        case "true":
+            out[key] = true
+        case "false":
+        default:
+            out[key] = n >= 2
+        }
+    }
     return out
 }
+
+// composeKey identifies the Compose service a container belongs to; empty outside Compose.
+func composeKey(labels map[string]string) string {
+    project, service := labels[LabelComposeProject], labels[LabelComposeService]
+    if project == "" || service == "" { return "" }
+    return project + "/" + service
+}
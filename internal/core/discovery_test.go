@@ -1,9 +1,11 @@
 package core
 
 import (
+    "strings"
     "testing"
 
     "github.com/frnwtr/tailwhale/internal/dockerx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
 )
 
 func TestDiscoverLabels(t *testing.T){
@@ -28,3 +30,107 @@ func TestDiscoverFromInfos(t *testing.T){
     svcs := DiscoverFromInfos(infos, "host1", "tn")
     if len(svcs) != 2 { t.Fatalf("expected 2, got %d", len(svcs)) }
 }
+
+func composeReplica(id, name string, extra map[string]string) dockerx.Info {
+    labels := map[string]string{LabelEnable:"true", LabelMode:"A", LabelComposeProject:"shop", LabelComposeService:"api"}
+    for k, v := range extra { labels[k] = v }
+    return dockerx.Info{ID: id, Name: name, IP: "172.18.0." + id, Ports: []int{8080}, Labels: labels}
+}
+
+func TestDiscoverCollapsesComposeReplicas(t *testing.T){
+    lb := map[string]string{LabelSticky:"true", LabelHealthPath:"/healthz", LabelHealthInterval:"5s"}
+    infos := []dockerx.Info{
+        composeReplica("3", "shop-api-3", lb),
+        composeReplica("1", "shop-api-1", lb),
+        composeReplica("2", "shop-api-2", lb),
+        {ID:"9", Name:"solo", Labels: map[string]string{LabelEnable:"true", LabelMode:"A"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    if len(svcs) != 2 { t.Fatalf("expected replicas to collapse, got %+v", svcs) }
+    api := svcs[0]
+    if api.Name != "shop-api" || api.Host != "shop-api.host1.tn.ts.net" { t.Fatalf("unexpected service: %+v", api) }
+    if got := api.Upstreams(); len(got) != 3 || got[0] != "172.18.0.1:8080" || got[2] != "172.18.0.3:8080" { t.Fatalf("upstreams = %v", got) }
+
+    routes := Routes(svcs)
+    lbc := tcfg.Build(routes, nil, tcfg.Options{}).HTTP.Services[routes[0].Name].LoadBalancer
+    if len(lbc.Servers) != 3 || lbc.Servers[1].URL != "http://172.18.0.2:8080" { t.Fatalf("servers = %+v", lbc.Servers) }
+    if lbc.Sticky == nil || lbc.Sticky.Cookie == nil || lbc.HealthCheck == nil || lbc.HealthCheck.Path != "/healthz" || lbc.HealthCheck.Interval != "5s" { t.Fatalf("load balancer = %+v", lbc) }
+
+    // Scaling down changes the server list only: same hostname, same certificate.
+    scaled := DiscoverFromInfos(infos[1:], "host1", "tn")
+    if scaled[0].Host != api.Host || len(scaled[0].Replicas) != 2 { t.Fatalf("scaled service = %+v", scaled[0]) }
+}
+
+func TestDiscoverKeepsUnscaledComposeContainersAndReplicaPorts(t *testing.T){
+    // One replica keeps its container name unless collapsing is asked for.
+    solo := DiscoverFromInfos([]dockerx.Info{composeReplica("1", "shop-api-1", nil)}, "host1", "tn")
+    if solo[0].Name != "shop-api-1" { t.Fatalf("unscaled service = %+v", solo[0]) }
+    pinned := DiscoverFromInfos([]dockerx.Info{composeReplica("1", "shop-api-1", map[string]string{LabelCollapse:"true"})}, "host1", "tn")
+    if pinned[0].Name != "shop-api" { t.Fatalf("collapse label ignored: %+v", pinned[0]) }
+    apart := DiscoverFromInfos([]dockerx.Info{composeReplica("1", "shop-api-1", map[string]string{LabelCollapse:"false"}), composeReplica("2", "shop-api-2", nil)}, "host1", "tn")
+    if len(apart) != 2 { t.Fatalf("collapse=false should keep replicas apart: %+v", apart) }
+
+    // Replicas exposing different ports are each reached on their own.
+    other := composeReplica("2", "shop-api-2", nil)
+    other.Ports = []int{9090}
+    svcs := DiscoverFromInfos([]dockerx.Info{composeReplica("1", "shop-api-1", nil), other}, "host1", "tn")
+    if got := svcs[0].Upstreams(); len(got) != 2 || got[0] != "172.18.0.1:8080" || got[1] != "172.18.0.2:9090" { t.Fatalf("upstreams = %v", got) }
+}
+
+func TestDiscoverMergesGroupsIntoWeightedVariants(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"api-v1", IP:"10.0.0.1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelGroup:"api", LabelVariant:"blue", LabelWeight:"90"}},
+        {ID:"2", Name:"api-v2", IP:"10.0.0.2", Ports: []int{8080}, Labels: map[string]string{LabelEnable:"true", LabelGroup:"api", LabelVariant:"green", LabelWeight:"10"}},
+        {ID:"3", Name:"api-v2b", IP:"10.0.0.3", Ports: []int{8080}, Labels: map[string]string{LabelEnable:"true", LabelGroup:"api", LabelVariant:"green", LabelWeight:"10"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    if len(svcs) != 1 || svcs[0].Name != "api" || svcs[0].Host != "api.host1.tn.ts.net" { t.Fatalf("group not merged: %+v", svcs) }
+    vs := svcs[0].Variants
+    if len(vs) != 2 || vs[0].Name != "blue" || vs[0].Weight != 90 || vs[1].Name != "green" || len(vs[1].Replicas) != 2 { t.Fatalf("variants = %+v", vs) }
+
+    routes := Routes(svcs)
+    if len(routes[0].Variants) != 2 || routes[0].Variants[1].Servers[1] != "http://10.0.0.3:8080" || len(routes[0].Servers) != 3 { t.Fatalf("route = %+v", routes[0]) }
+    svc := tcfg.Build(routes, nil, tcfg.Options{}).HTTP.Services["api"]
+    if svc.Weighted == nil || svc.Weighted.Services[0].Name != "api.blue" || svc.Weighted.Services[1].Weight != 10 { t.Fatalf("weighted service = %+v", svc) }
+}
+
+func TestDiscoverKeepsVariantsApartFromStandaloneServices(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"api-v1", IP:"10.0.0.1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelGroup:"api", LabelVariant:"blue"}},
+        {ID:"2", Name:"api-blue", IP:"10.0.0.2", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}},
+        {ID:"3", Name:"my_app", IP:"10.0.0.3", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}},
+        {ID:"4", Name:"my-app", IP:"10.0.0.4", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    if len(svcs) != 3 || svcs[2].Name != "my-app" || len(svcs[2].Warnings) != 1 || !strings.Contains(svcs[2].Warnings[0], `"my_app"`) { t.Fatalf("services = %+v", svcs) }
+    cfg := tcfg.Build(Routes(svcs), nil, tcfg.Options{})
+    if cfg.HTTP.Services["api-blue"].LoadBalancer.Servers[0].URL != "http://10.0.0.2:80" || cfg.HTTP.Services["api.blue"].LoadBalancer.Servers[0].URL != "http://10.0.0.1:80" { t.Fatalf("services = %+v", cfg.HTTP.Services) }
+}
+
+func TestDiscoverRoutesModeAUnderTheNodeName(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"web", IP:"10.0.0.1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}},
+        {ID:"2", Name:"docs", IP:"10.0.0.2", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelRoutingPath:"/handbook/"}},
+        {ID:"3", Name:"db", IP:"10.0.0.3", Ports: []int{5432}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"tcp", LabelRouting:"port", LabelRoutingPort:"15432"}},
+        {ID:"4", Name:"grafana", IP:"10.0.0.4", Ports: []int{3000}, Labels: map[string]string{LabelEnable:"true", LabelRouting:"subdomain"}},
+        {ID:"5", Name:"side", Labels: map[string]string{LabelEnable:"true", LabelMode:"B"}},
+    }
+    svcs := Discovery{Host: "host1", Tailnet: "tn", Routing: RoutingPath}.FromInfos(infos)
+    byName := map[string]Service{}
+    for _, s := range svcs { byName[s.Name] = s }
+    if s := byName["web"]; s.Host != "host1.tn.ts.net" || s.URL() != "https://host1.tn.ts.net/web/" { t.Fatalf("web = %+v", s) }
+    if s := byName["docs"]; s.URL() != "https://host1.tn.ts.net/handbook/" { t.Fatalf("docs = %+v", s) }
+    if s := byName["db"]; s.Routing != RoutingPort || s.URL() != "https://host1.tn.ts.net:15432" { t.Fatalf("db = %+v", s) }
+    if s := byName["grafana"]; s.Host != "grafana.host1.tn.ts.net" || s.PathPrefix != "" { t.Fatalf("label should override the default: %+v", s) }
+    if s := byName["side"]; s.Routing != "" || s.Host != "side.tn.ts.net" { t.Fatalf("mode B is not routed by path: %+v", s) }
+
+    routes := map[string]tcfg.Route{}
+    for _, r := range Routes(svcs) { routes[r.Name] = r }
+    if r := routes["web"]; r.Host != "host1.tn.ts.net" || r.PathPrefix != "/web" { t.Fatalf("web route = %+v", r) }
+    if r := routes["db"]; r.EntryPoint != "tailwhale-15432" || r.Host != "host1.tn.ts.net" { t.Fatalf("db route = %+v", r) }
+
+    // One node certificate serves every path- and port-routed service.
+    tls := Orchestrator{}.certs(svcs)
+    if len(tls) != 3 { t.Fatalf("expected the node and grafana certificates plus side's, got %v", tls) }
+    if len(CaddySites(svcs, tls)) != 1 { t.Fatal("caddy cannot route by path or port and should only get grafana") }
+}
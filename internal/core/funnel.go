@@ -0,0 +1,71 @@
+package core
+
+import (
+    "context"
+    "strings"
+    "time"
+
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+// FunnelMounts returns the public endpoints Mode C services need from Funnel: each HTTP
+// service is mounted at its tailwhale.funnel.path (default /) on its tailwhale.funnel.port
+// (default 443), and tailscaled proxies it to the first replica. A TCP service takes its
+// whole port, forwarded to the first replica with TLS terminated by tailscaled unless it
+// is passed through. Funnel carries no UDP (discovery warns about those services), and
+// services without a port get none. Funnel traffic never passes through Traefik, so
+// services with labels it would skip get none either (see FunnelWarnings).
+func FunnelMounts(svcs []Service) []ts.FunnelMount {
+    var out []ts.FunnelMount
+    for _, s := range svcs {
+        upstreams := s.Upstreams()
+        if s.Mode != ModeC || s.Protocol == tcfg.ProtocolUDP || len(upstreams) == 0 || len(funnelBypassed(s)) > 0 { continue }
+        m := ts.FunnelMount{Port: s.FunnelPort, Path: s.FunnelPath, Backend: "http://" + upstreams[0]}
+        if m.Path == "" { m.Path = "/" }
+        if s.Protocol == tcfg.ProtocolTCP { m = ts.FunnelMount{Port: s.FunnelPort, Backend: "tcp://" + upstreams[0], Passthrough: s.TLS.Passthrough} }
+        if m.Port == 0 { m.Port = 443 }
+        out = append(out, m)
+    }
+    return out
+}
+
+// FunnelWarnings reports the Mode C services FunnelMounts leaves out: tailscaled would
+// proxy their public traffic straight to the container, past the middlewares and TLS
+// options Traefik applies to them.
+func FunnelWarnings(svcs []Service) []ServiceWarning {
+    var out []ServiceWarning
+    for _, s := range svcs {
+        if s.Mode != ModeC || s.Protocol == tcfg.ProtocolUDP { continue }
+        if labels := funnelBypassed(s); len(labels) > 0 {
+            out = append(out, ServiceWarning{Service: s.Name, Message: strings.Join(labels, ", ") + " would not apply to Funnel traffic, which bypasses Traefik; not funneled"})
+        }
+    }
+    return out
+}
+
+// funnelBypassed lists the labels of s that Funnel traffic would skip: its security labels
+// and the header middlewares.
+func funnelBypassed(s Service) []string {
+    labels := unenforced(s)
+    if len(s.Middlewares.Headers) > 0 { labels = append(labels, LabelHeaders+"*") }
+    if s.Middlewares.STSSeconds > 0 { labels = append(labels, LabelHSTS) }
+    return labels
+}
+
+// FunnelBackend keeps tailscaled's serve config in line with the Mode C services: exactly
+// their endpoints are public, and every other port is closed to the Internet.
+type FunnelBackend struct {
+    Client *ts.LocalClient
+    Host   string // the node's MagicDNS name, e.g. host1.tn.ts.net
+    // Changed, when set, receives the changes a sync applied.
+    Changed func([]ts.ServeChange)
+}
+
+func (b FunnelBackend) Apply(svcs []Service, _ tcfg.TLSConfig) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    changes, err := b.Client.ReconcileFunnel(ctx, b.Host, FunnelMounts(svcs))
+    if err == nil && len(changes) > 0 && b.Changed != nil { b.Changed(changes) }
+    return err
+}
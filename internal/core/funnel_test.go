@@ -0,0 +1,31 @@
+package core
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/frnwtr/tailwhale/internal/dockerx"
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+func TestFunnelMountsFromModeCServices(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"site", IP:"10.0.0.1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMode:"C"}},
+        {ID:"2", Name:"hooks", IP:"10.0.0.2", Ports: []int{8080}, Labels: map[string]string{LabelEnable:"true", LabelMode:"C", LabelFunnelPort:"10000", LabelFunnelPath:"hooks/"}},
+        {ID:"3", Name:"mqtt", IP:"10.0.0.3", Ports: []int{8883}, Labels: map[string]string{LabelEnable:"true", LabelMode:"C", LabelProtocol:"tcp", LabelFunnelPort:"8443"}},
+        {ID:"4", Name:"internal", IP:"10.0.0.4", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelFunnelPort:"443"}},
+        {ID:"5", Name:"voip", IP:"10.0.0.5", Ports: []int{5060}, Labels: map[string]string{LabelEnable:"true", LabelMode:"C", LabelProtocol:"udp"}},
+        {ID:"6", Name:"admin", IP:"10.0.0.6", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMode:"C", LabelFunnelPort:"8443", LabelAllowList:"tailnet", LabelTLSMin:"1.3"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    got := FunnelMounts(svcs)
+    want := []ts.FunnelMount{
+        {Port: 10000, Path: "/hooks", Backend: "http://10.0.0.2:8080"},
+        {Port: 8443, Backend: "tcp://10.0.0.3:8883"},
+        {Port: 443, Path: "/", Backend: "http://10.0.0.1:80"},
+    }
+    if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] { t.Fatalf("mounts = %+v", got) }
+    if w := svcs[5].Warnings; svcs[5].Name != "voip" || len(w) != 1 || !strings.Contains(w[0], "UDP") { t.Fatalf("voip = %+v", svcs[5]) }
+    w := FunnelWarnings(svcs)
+    if len(w) != 1 || w[0].Error() != "admin: tailwhale.middlewares.allowlist, tailwhale.tls.minVersion would not apply to Funnel traffic, which bypasses Traefik; not funneled" { t.Fatalf("warnings = %v", w) }
+}
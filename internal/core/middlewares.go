@@ -0,0 +1,52 @@
+package core
+
+import (
+    "strconv"
+    "strings"
+
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
+
+// Middleware labels. Header labels take the header name as suffix,
+// e.g. tailwhale.middlewares.headers.X-Frame-Options=DENY.
+const (
+    LabelMiddlewares   = "tailwhale.middlewares."
+    LabelAllowList     = LabelMiddlewares + "allowlist"           // true|tailnet or comma-separated CIDRs
+    LabelBasicAuthFile = LabelMiddlewares + "basicauth.usersfile" // htpasswd file path as seen by Traefik
+    LabelHeaders       = LabelMiddlewares + "headers."
+    LabelHSTS          = LabelMiddlewares + "hsts"                // true or max-age seconds
+    LabelRedirect      = LabelMiddlewares + "redirect"            // true adds an HTTP->HTTPS redirect router
+)
+
+// defaultSTSSeconds is used when the hsts label is simply "true" (one year).
+const defaultSTSSeconds = 31536000
+
+// ParseMiddlewares reads tailwhale.middlewares.* labels.
+func ParseMiddlewares(labels map[string]string) tcfg.Middlewares {
+    var m tcfg.Middlewares
+    switch v := strings.TrimSpace(labels[LabelAllowList]); strings.ToLower(v) {
+    case "", "false":
+    case "true", "tailnet":
+        m.AllowList = append([]string(nil), tcfg.TailnetSourceRange...)
+    default:
+        for _, r := range strings.Split(v, ",") {
+            if r = strings.TrimSpace(r); r != "" { m.AllowList = append(m.AllowList, r) }
+        }
+    }
+    m.BasicAuthUsersFile = strings.TrimSpace(labels[LabelBasicAuthFile])
+    for k, v := range labels {
+        if name := strings.TrimPrefix(k, LabelHeaders); name != k && name != "" {
+            if m.Headers == nil { m.Headers = map[string]string{} }
+            m.Headers[name] = v
+        }
+    }
+    switch v := strings.TrimSpace(labels[LabelHSTS]); strings.ToLower(v) {
+    case "", "false":
+    case "true":
+        m.STSSeconds = defaultSTSSeconds
+    default:
+        if n, err := strconv.Atoi(v); err == nil && n > 0 { m.STSSeconds = n }
+    }
+    m.Redirect = strings.EqualFold(strings.TrimSpace(labels[LabelRedirect]), "true")
+    return m
+}
@@ -0,0 +1,20 @@
+package core
+
+import "testing"
+
+func TestParseMiddlewares(t *testing.T){
+    m := ParseMiddlewares(map[string]string{
+        LabelAllowList:                   "tailnet",
+        LabelBasicAuthFile:               "/run/secrets/htpasswd",
+        LabelHeaders + "X-Frame-Options": "DENY",
+        LabelHSTS:                        "true",
+        LabelRedirect:                    "true",
+    })
+    if len(m.AllowList) != 2 || m.AllowList[0] != "100.64.0.0/10" { t.Fatalf("unexpected allowlist: %v", m.AllowList) }
+    if m.BasicAuthUsersFile != "/run/secrets/htpasswd" { t.Fatalf("unexpected users file: %q", m.BasicAuthUsersFile) }
+    if m.Headers["X-Frame-Options"] != "DENY" || m.STSSeconds != 31536000 || !m.Redirect { t.Fatalf("unexpected middlewares: %+v", m) }
+
+    m = ParseMiddlewares(map[string]string{LabelAllowList: "10.0.0.0/8, 192.168.1.0/24", LabelHSTS: "600"})
+    if len(m.AllowList) != 2 || m.AllowList[1] != "192.168.1.0/24" || m.STSSeconds != 600 { t.Fatalf("unexpected middlewares: %+v", m) }
+    if m := ParseMiddlewares(nil); m.AllowList != nil || m.Redirect { t.Fatalf("expected none: %+v", m) }
+}
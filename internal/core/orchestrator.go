@@ -2,6 +2,12 @@ package core
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
     "time"
 
     "github.com/frnwtr/tailwhale/internal/dockerx"
@@ -17,35 +23,288 @@ type Orchestrator struct {
     Manager  ts.Manager
     // Optional write callback to persist TLS config (e.g., to file)
     WriteTLS func(tcfg.TLSConfig) error
+    // CertPaths, when set, rewrites certificate paths into the Traefik container's filesystem.
+    CertPaths *PathMap
+    // Optional callback receiving host-side certificate paths on every sync (e.g. an acme.json export)
+    ExportCerts func(tcfg.TLSConfig) error
+    // Backend, when set, receives services and certificates on every sync (e.g. a TraefikBackend
+    // publishing the dynamic config); its errors fail the sync.
+    Backend Backend
+    // State, when set, is the runtime state file (weights from tailwhale shift) applied to every sync.
+    // Watch polls it and resyncs as soon as it changes.
+    State string
+    // Funnel, when set (e.g. a FunnelBackend), runs after Backend on every sync; its errors fail the sync.
+    Funnel Backend
+    // Routing is the default Mode A routing strategy (subdomain, path or port); labels override it.
+    Routing string
+    // Report, when set, receives problems that do not fail a sync (ServiceWarning) and the
+    // errors of the syncs Watch runs, which do not stop the watch.
+    Report func(error)
+    // CanIssue, when set, tells whether tailscaled can issue a certificate for a hostname
+    // (ts.Status.CanIssue); Mode A hosts it can't are reported (see UncertifiableHosts).
+    CanIssue func(host string) bool
+    // HostRoutedOnly, when set, reports the path- and port-routed services, which the Backend
+    // leaves out because it tells services apart by hostname only (Caddy, Envoy, the built-in proxy).
+    HostRoutedOnly bool
+    // Unenforced, when set, lists the security labels of a service the Backend can't enforce
+    // (e.g. CaddyUnenforced); the Backend leaves such services out and they are reported.
+    Unenforced func(Service) []string
+}
+
+func (o Orchestrator) report(err error) {
+    if err != nil && o.Report != nil { o.Report(err) }
 }
 
 // SyncOnce discovers services and returns a TLS config view.
 func (o Orchestrator) SyncOnce(ctx context.Context) ([]Service, tcfg.TLSConfig, error) {
-    svcs, err := Discover(o.Provider, o.Host, o.Tailnet)
+    list, err := o.Provider.List()
+    if err != nil { return nil, nil, err }
+    svcs, err := o.withState(o.discovery().FromInfos(list), list)
     if err != nil { return nil, nil, err }
+    tls, err := o.apply(svcs)
+    if err != nil { return nil, nil, err }
+    _ = ctx // reserved for future timeouts/cancellations
+    return svcs, tls, nil
+}
+
+func (o Orchestrator) discovery() Discovery {
+    return Discovery{Host: o.Host, Tailnet: o.Tailnet, Routing: o.Routing}
+}
+
+// withState applies the runtime state file, when configured, to discovered services and
+// allocates the listen ports of port-routed services, recording new ones in the file.
+func (o Orchestrator) withState(svcs []Service, list []dockerx.Info) ([]Service, error) {
+    var st State
+    if o.State != "" {
+        var err error
+        if st, err = LoadState(o.State); err != nil { return nil, err }
+    }
+    changed, err := st.AllocatePorts(svcs, PublishedPorts(list), time.Now())
+    if err != nil { return nil, err }
+    if o.State == "" {
+        // Nothing keeps the allocations: ports follow the order services come and go in.
+        for _, s := range svcs {
+            if l, ok := st.Ports[s.Name]; ok && l.Port == s.ListenPort {
+                o.report(ServiceWarning{Service: s.Name, Message: fmt.Sprintf("listen port %d is not recorded without a state file and may change", s.ListenPort)})
+            }
+        }
+    } else if changed {
+        if err := st.Save(o.State); err != nil { return nil, err }
+    }
+    return st.Apply(svcs), nil
+}
+
+// certs ensures a certificate for every service, falling back to placeholder paths.
+func (o Orchestrator) certs(svcs []Service) tcfg.TLSConfig {
     tls := make(tcfg.TLSConfig)
+    hosts := map[string]bool{}
     for _, s := range svcs {
+        // Passed-through TCP services terminate TLS themselves and need no certificate from us.
+        if s.Protocol == tcfg.ProtocolTCP && s.TLS.Passthrough { continue }
+        // Path- and port-routed services share the node's certificate.
+        if hosts[s.Host] { continue }
+        hosts[s.Host] = true
+        var stores []string
+        if s.TLSStore != "" { stores = []string{s.TLSStore} }
         if o.Manager != nil {
             c, err := o.Manager.Ensure(s.Host)
             if err == nil {
-                tls[s.Host] = tcfg.TLSCert{CertFile: c.Path, KeyFile: c.KeyPath}
+                tls[s.Host] = tcfg.TLSCert{CertFile: c.Path, KeyFile: c.KeyPath, Stores: stores}
                 continue
             }
         }
         // Placeholder fallback paths
-        tls[s.Host] = tcfg.TLSCert{CertFile: "/var/lib/tailwhale/certs/"+s.Name+".crt", KeyFile: "/var/lib/tailwhale/certs/"+s.Name+".key"}
+        tls[s.Host] = tcfg.TLSCert{CertFile: "/var/lib/tailwhale/certs/"+s.Name+".crt", KeyFile: "/var/lib/tailwhale/certs/"+s.Name+".key", Stores: stores}
+    }
+    return tls
+}
+
+// SharedHostTLS checks the services Traefik serves under one hostname and entry point, as
+// path-routed services on the node's name are: Traefik applies one set of TLS options per
+// host there and falls back to its defaults when the routers disagree. A service asking
+// for mTLS would then be served without client certificate checks, so when the others'
+// TLS labels differ from its own it is refused, with a warning; the services left are
+// warned about when they still disagree. It returns the refused services' names.
+func SharedHostTLS(svcs []Service) (refused map[string]bool, warnings []ServiceWarning) {
+    refused = map[string]bool{}
+    var keys []string
+    groups := map[string][]Service{} // host and entry point -> services, in order
+    for _, s := range svcs {
+        if s.Mode == ModeB || s.Protocol == tcfg.ProtocolUDP || (s.Protocol == tcfg.ProtocolTCP && s.TLS.Passthrough) { continue }
+        ep := s.EntryPoint
+        if s.Routing == RoutingPort && ep == "" { ep = tcfg.PortEntryPoint(s.ListenPort) }
+        k := s.Host + " " + ep
+        if groups[k] == nil { keys = append(keys, k) }
+        groups[k] = append(groups[k], s)
+    }
+    for _, k := range keys {
+        group := groups[k]
+        var kept []Service
+        for _, s := range group {
+            if s.TLS.ClientCA == "" { kept = append(kept, s); continue }
+            for _, other := range group {
+                if sameTLS(s, other) { continue }
+                refused[s.Name] = true
+                warnings = append(warnings, ServiceWarning{Service: s.Name, Message: fmt.Sprintf("tls labels differ from those of %s, which shares host %s; Traefik would not check client certificates there, so it is not routed", other.Name, s.Host)})
+                break
+            }
+            if !refused[s.Name] { kept = append(kept, s) }
+        }
+        for _, s := range kept {
+            if first := kept[0]; !sameTLS(first, s) {
+                warnings = append(warnings, ServiceWarning{Service: s.Name, Message: fmt.Sprintf("tls labels differ from those of %s, which shares host %s; Traefik uses its default TLS options for the host", first.Name, s.Host)})
+            }
+        }
+    }
+    return refused, warnings
+}
+
+// sameTLS reports whether a and b ask for the same TLS store and options.
+func sameTLS(a, b Service) bool {
+    x, y := a.TLS, b.TLS
+    return a.TLSStore == b.TLSStore && x.Profile == y.Profile && x.MinVersion == y.MinVersion && x.ClientCA == y.ClientCA &&
+        strings.Join(x.CipherSuites, ",") == strings.Join(y.CipherSuites, ",")
+}
+
+// UncertifiableHosts warns, once per hostname, about the Mode A services whose hostname
+// canIssue rejects: tailscaled only issues certificates for the node's own MagicDNS name,
+// so subdomains of it need path or port routing instead. A nil canIssue reports nothing.
+func UncertifiableHosts(svcs []Service, canIssue func(string) bool) []ServiceWarning {
+    if canIssue == nil { return nil }
+    var out []ServiceWarning
+    seen := map[string]bool{}
+    for _, s := range svcs {
+        if s.Mode != ModeA || s.Host == "" || seen[s.Host] || (s.Protocol == tcfg.ProtocolTCP && s.TLS.Passthrough) { continue }
+        seen[s.Host] = true
+        if canIssue(s.Host) { continue }
+        out = append(out, ServiceWarning{Service: s.Name, Message: fmt.Sprintf("tailscaled cannot issue a certificate for %s; use tailwhale.routing=path or port to serve it under the node's name", s.Host)})
+    }
+    return out
+}
+
+// apply computes certificates for svcs and hands the results to the configured writers.
+// Nothing is written when a certificate path cannot be mapped into the Traefik container.
+// A failed certificate export does not hold back the other writers; it is returned with their errors.
+func (o Orchestrator) apply(svcs []Service) (tcfg.TLSConfig, error) {
+    for _, s := range svcs {
+        for _, w := range s.Warnings { o.report(ServiceWarning{Service: s.Name, Message: w}) }
+    }
+    for _, w := range UncertifiableHosts(svcs, o.CanIssue) { o.report(w) }
+    if o.HostRoutedOnly {
+        for _, s := range svcs {
+            if s.Mode == ModeB || s.hostRouted() { continue }
+            o.report(ServiceWarning{Service: s.Name, Message: s.Routing + " routing is only supported by the traefik and template proxies; not routed"})
+        }
+    }
+    if o.Unenforced != nil {
+        for _, s := range svcs {
+            if s.Mode == ModeB || (o.HostRoutedOnly && !s.hostRouted()) { continue }
+            if labels := o.Unenforced(s); len(labels) > 0 {
+                o.report(ServiceWarning{Service: s.Name, Message: strings.Join(labels, ", ") + " can't be enforced by this proxy; not routed"})
+            }
+        }
+    }
+    _, shared := SharedHostTLS(svcs)
+    for _, w := range shared { o.report(w) }
+    if o.Funnel != nil {
+        for _, w := range FunnelWarnings(svcs) { o.report(w) }
+    }
+    tls := o.certs(svcs)
+    var exportErr error
+    if o.ExportCerts != nil {
+        exportErr = o.ExportCerts(tls)
+    }
+    if o.CertPaths != nil {
+        var err error
+        if tls, err = o.CertPaths.TLS(tls); err != nil { return nil, errors.Join(err, exportErr) }
     }
     if o.WriteTLS != nil {
-        _ = o.WriteTLS(tls)
+        if err := o.WriteTLS(tls); err != nil { return nil, errors.Join(err, exportErr) }
     }
-    _ = ctx // reserved for future timeouts/cancellations
-    return svcs, tls, nil
+    if o.Backend != nil {
+        if err := o.Backend.Apply(svcs, tls); err != nil { return nil, errors.Join(err, exportErr) }
+    }
+    if o.Funnel != nil {
+        if err := o.Funnel.Apply(svcs, tls); err != nil { return nil, errors.Join(err, exportErr) }
+    }
+    if exportErr != nil { return nil, exportErr }
+    return tls, nil
+}
+
+// FunnelMinTLS is the minimum TLS version enforced on Mode C (Funnel) routes.
+const FunnelMinTLS = tcfg.VersionTLS12
+
+// Routes translates services routed through Traefik (modes A and C) into traefik routes.
+// Services without a known port are skipped since there is nothing to forward to, as are
+// port-routed services without a listen port since there is nothing to bind them to.
+// Port-routed services use the entry point tcfg.PortEntryPoint names unless labelled otherwise.
+// mTLS services SharedHostTLS refuses are skipped as well.
+func Routes(svcs []Service) []tcfg.Route {
+    var out []tcfg.Route
+    refused, _ := SharedHostTLS(svcs)
+    for _, s := range svcs {
+        servers := s.Upstreams()
+        if s.Mode == ModeB || len(servers) == 0 || (s.Routing == RoutingPort && s.ListenPort == 0) || refused[s.Name] { continue }
+        ep := s.EntryPoint
+        if s.Routing == RoutingPort && ep == "" { ep = tcfg.PortEntryPoint(s.ListenPort) }
+        var variants []tcfg.Variant
+        if s.Protocol == "" || s.Protocol == tcfg.ProtocolHTTP {
+            servers = serverURLs(s.Scheme, servers)
+            for _, v := range s.ActiveVariants() {
+                variants = append(variants, tcfg.Variant{Name: RouteName(v.Name), Weight: v.Weight, Servers: serverURLs(s.Scheme, v.Upstreams())})
+            }
+        }
+        tls := s.TLS
+        if s.Mode == ModeC {
+            // Funnel makes the service reachable from the Internet: never below TLS 1.2.
+            tls.Floor = FunnelMinTLS
+        }
+        out = append(out, tcfg.Route{
+            Name:          RouteName(s.Name),
+            Host:          strings.TrimPrefix(s.Host, "https://"),
+            Protocol:      s.Protocol,
+            EntryPoint:    ep,
+            PathPrefix:    s.PathPrefix,
+            Servers:       servers,
+            Middlewares:   s.Middlewares,
+            TLS:           tls,
+            LoadBalancing: s.LoadBalancing,
+            Variants:      variants,
+        })
+    }
+    return out
+}
+
+// serverURLs prefixes host:port upstreams with the http service's scheme.
+func serverURLs(scheme string, upstreams []string) []string {
+    if scheme != "h2c" { scheme = "http" }
+    out := make([]string, len(upstreams))
+    for i, a := range upstreams { out[i] = scheme + "://" + a }
+    return out
+}
+
+// RouteName turns a container name into a Traefik-safe router/service name.
+func RouteName(name string) string {
+    b := []byte(strings.ToLower(name))
+    for i, c := range b {
+        if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') { b[i] = '-' }
+    }
+    return string(b)
 }
 
 // Watch listens for provider events; falls back to periodic sync if events unavailable.
 func (o Orchestrator) Watch(ctx context.Context, interval time.Duration, fn func([]Service, tcfg.TLSConfig)) error {
+    var mu sync.Mutex // serialises syncs triggered by events, the ticker and state changes
+    resync := func(){
+        mu.Lock()
+        defer mu.Unlock()
+        svcs, tls, err := o.SyncOnce(ctx)
+        if err != nil { o.report(err); return }
+        if fn != nil { fn(svcs, tls) }
+    }
     // Initial sync
-    if svcs, tls, err := o.SyncOnce(ctx); err == nil && fn != nil { fn(svcs, tls) }
+    resync()
+    if o.State != "" { go o.watchState(ctx, resync) }
 
     w, err := o.Provider.Watch()
     if err == nil && w != nil {
@@ -83,19 +342,17 @@ func (o Orchestrator) Watch(ctx context.Context, interval time.Duration, fn func
                 case <-ctx.Done():
                     return ctx.Err()
                 case <-debounce.C:
-                    svcs := DiscoverFromInfos(cache.List(), o.Host, o.Tailnet)
-                    tls := make(tcfg.TLSConfig)
-                    for _, s := range svcs {
-                        if o.Manager != nil {
-                            if c, err := o.Manager.Ensure(s.Host); err == nil {
-                                tls[s.Host] = tcfg.TLSCert{CertFile: c.Path, KeyFile: c.KeyPath}
-                                continue
-                            }
-                        }
-                        tls[s.Host] = tcfg.TLSCert{CertFile: "/var/lib/tailwhale/certs/"+s.Name+".crt", KeyFile: "/var/lib/tailwhale/certs/"+s.Name+".key"}
+                    mu.Lock()
+                    list := cache.List()
+                    svcs, err := o.withState(o.discovery().FromInfos(list), list)
+                    var tls tcfg.TLSConfig
+                    if err == nil { tls, err = o.apply(svcs) }
+                    if err != nil {
+                        o.report(err)
+                    } else if fn != nil {
+                        fn(svcs, tls)
                     }
-                    if o.WriteTLS != nil { _ = o.WriteTLS(tls) }
-                    if fn != nil { fn(svcs, tls) }
+                    mu.Unlock()
                     break debLoop
                 default:
                     // Accumulate more events until debounce fires, using a worker goroutine to avoid blocking
@@ -127,8 +384,30 @@ func (o Orchestrator) Watch(ctx context.Context, interval time.Duration, fn func
         case <-ctx.Done():
             return ctx.Err()
         case <-ticker.C:
-            svcs, tls, err := o.SyncOnce(ctx)
-            if err == nil && fn != nil { fn(svcs, tls) }
+            resync()
+        }
+    }
+}
+
+// statePollInterval is how often Watch checks the state file for changes.
+const statePollInterval = 2 * time.Second
+
+// watchState calls resync whenever the state file's modification time or size changes.
+func (o Orchestrator) watchState(ctx context.Context, resync func()) {
+    stamp := func() string {
+        fi, err := os.Stat(o.State)
+        if err != nil { return "" }
+        return fi.ModTime().String() + "/" + strconv.FormatInt(fi.Size(), 10)
+    }
+    last := stamp()
+    ticker := time.NewTicker(statePollInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if s := stamp(); s != last { last = s; resync() }
         }
     }
 }
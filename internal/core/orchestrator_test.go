@@ -2,10 +2,14 @@ package core
 
 import (
     "context"
+    "errors"
     "path/filepath"
+    "strings"
     "testing"
+    "time"
 
     "github.com/frnwtr/tailwhale/internal/dockerx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
     ts "github.com/frnwtr/tailwhale/internal/tailscale"
 )
 
@@ -21,3 +25,170 @@ func TestOrchestratorSyncUsesManagerPaths(t *testing.T){
     }
 }
 
+func TestRoutesUsePortLabelAndSkipModeB(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"web", IP:"172.18.0.2", Ports: []int{80, 443}, Labels: map[string]string{LabelEnable:"true", LabelPort:"8080"}},
+        {ID:"2", Name:"side", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMode:"B"}},
+        {ID:"3", Name:"My_App", Ports: []int{3000}, Labels: map[string]string{LabelEnable:"true"}},
+    }
+    routes := Routes(DiscoverFromInfos(infos, "host1", "tn"))
+    if len(routes) != 2 { t.Fatalf("expected 2 routes, got %+v", routes) }
+    if routes[0].Name != "my-app" || routes[0].Servers[0] != "http://My_App:3000" { t.Fatalf("unexpected route: %+v", routes[0]) }
+    if routes[1].Host != "web.host1.tn.ts.net" || routes[1].Servers[0] != "http://172.18.0.2:8080" { t.Fatalf("unexpected route: %+v", routes[1]) }
+}
+
+func TestOrchestratorWritesTraefikConfig(t *testing.T){
+    p := &dockerx.FakeProvider{Items: []dockerx.Info{{ID:"1", Name:"app1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}}}}
+    var got tcfg.Config
+    o := Orchestrator{Provider: p, Host: "host1", Tailnet: "tn", Manager: &ts.FileManager{Dir: t.TempDir()},
+        Backend: TraefikBackend{Publisher: tcfg.PublisherFunc(func(c tcfg.Config) error { got = c; return nil })}}
+    if _, _, err := o.SyncOnce(context.Background()); err != nil { t.Fatal(err) }
+    if got.HTTP == nil || got.HTTP.Routers["app1"].Rule != "Host(`app1.host1.tn.ts.net`)" { t.Fatalf("unexpected config: %+v", got.HTTP) }
+    if got.TLS == nil || len(got.TLS.Certificates) != 1 { t.Fatalf("expected one certificate: %+v", got.TLS) }
+}
+
+func TestRoutesProtocolAddresses(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"pg", Ports: []int{5432}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"TCP"}},
+        {ID:"2", Name:"mqtt", Ports: []int{1883}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"udp", LabelEntryPoint:"mqtt"}},
+    }
+    routes := Routes(DiscoverFromInfos(infos, "host1", "tn"))
+    if routes[0].Protocol != "udp" || routes[0].Servers[0] != "mqtt:1883" || routes[0].EntryPoint != "mqtt" { t.Fatalf("unexpected udp route: %+v", routes[0]) }
+    if routes[1].Protocol != "tcp" || routes[1].Servers[0] != "pg:5432" { t.Fatalf("unexpected tcp route: %+v", routes[1]) }
+}
+
+func TestRoutesTLSLabelsAndFunnelFloor(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"api", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelTLSMin:"1.3", LabelTLSCiphers:"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_RC4_128_SHA, TLS_FAKE", LabelTLSStore:"internal"}},
+        {ID:"2", Name:"pub", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMode:"C", LabelTLSMin:"1.0", LabelTLSProfile:"paranoid"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    routes := Routes(svcs)
+    if routes[0].TLS.MinVersion != tcfg.VersionTLS13 || len(routes[0].TLS.CipherSuites) != 2 || routes[0].TLS.Floor != "" { t.Fatalf("unexpected api tls: %+v", routes[0].TLS) }
+    if routes[1].TLS.Floor != tcfg.VersionTLS12 { t.Fatalf("funnel route should have TLS 1.2 floor: %+v", routes[1].TLS) }
+    if w := svcs[0].Warnings; len(w) != 1 || !strings.Contains(w[0], `"TLS_FAKE"`) { t.Fatalf("api warnings = %v", w) }
+    if w := svcs[1].Warnings; len(w) != 1 || !strings.Contains(w[0], `"paranoid"`) || routes[1].TLS.Profile != "" { t.Fatalf("pub warnings = %v", w) }
+    tls := Orchestrator{}.certs(svcs)
+    if s := tls["api.host1.tn.ts.net"].Stores; len(s) != 1 || s[0] != "internal" { t.Fatalf("unexpected stores: %v", s) }
+}
+
+func TestOrchestratorExportsHostCertPaths(t *testing.T){
+    p := &dockerx.FakeProvider{Items: []dockerx.Info{{ID:"1", Name:"app1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}}}}
+    var exported tcfg.TLSConfig
+    o := Orchestrator{Provider: p, Host: "host1", Tailnet: "tn", Manager: &ts.FileManager{Dir: "/var/lib/tailwhale/certs"},
+        ExportCerts: func(c tcfg.TLSConfig) error { exported = c; return nil }}
+    o.CertPaths = &PathMap{Container: "traefik", Mounts: []dockerx.Mount{{Source: "/var/lib/tailwhale/certs", Destination: "/certs"}}}
+    if _, _, err := o.SyncOnce(context.Background()); err != nil { t.Fatal(err) }
+    if c := exported["app1.host1.tn.ts.net"]; c.CertFile != "/var/lib/tailwhale/certs/app1.host1.tn.ts.net.crt" { t.Fatalf("export should see host paths: %+v", c) }
+}
+
+func TestOrchestratorReturnsWriterErrors(t *testing.T){
+    p := &dockerx.FakeProvider{Items: []dockerx.Info{{ID:"1", Name:"app1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}}}}
+    var wrote bool
+    o := Orchestrator{Provider: p, Host: "host1", Tailnet: "tn",
+        ExportCerts: func(tcfg.TLSConfig) error { return errors.New("acme.json: permission denied") },
+        WriteTLS:    func(tcfg.TLSConfig) error { wrote = true; return nil }}
+    if _, _, err := o.SyncOnce(context.Background()); err == nil || !strings.Contains(err.Error(), "acme.json") { t.Fatalf("expected the export error, got %v", err) }
+    if !wrote { t.Fatal("a failed export should not hold back the tls writer") }
+
+    o.ExportCerts = nil
+    o.WriteTLS = func(tcfg.TLSConfig) error { return errors.New("disk full") }
+    ctx, cancel := context.WithCancel(context.Background())
+    var reported []error
+    o.Report = func(err error){ reported = append(reported, err); cancel() }
+    _ = o.Watch(ctx, time.Hour, func([]Service, tcfg.TLSConfig){ t.Fatal("failed syncs should not reach the callback") })
+    if len(reported) != 1 || reported[0].Error() != "disk full" { t.Fatalf("expected the write error to be reported, got %v", reported) }
+}
+
+func TestOrchestratorReportsSharedHostConflictsAndUnroutedServices(t *testing.T){
+    p := &dockerx.FakeProvider{Items: []dockerx.Info{
+        {ID:"1", Name:"docs", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelRouting:"path", LabelTLSProfile:"modern"}},
+        {ID:"2", Name:"wiki", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelRouting:"path"}},
+        {ID:"3", Name:"web", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true"}},
+    }}
+    var reported []string
+    o := Orchestrator{Provider: p, Host: "host1", Tailnet: "tn", HostRoutedOnly: true, Report: func(err error){ reported = append(reported, err.Error()) }}
+    if _, _, err := o.SyncOnce(context.Background()); err != nil { t.Fatal(err) }
+    want := []string{
+        "docs: path routing is only supported by the traefik and template proxies; not routed",
+        "wiki: path routing is only supported by the traefik and template proxies; not routed",
+        "wiki: tls labels differ from those of docs, which shares host host1.tn.ts.net; Traefik uses its default TLS options for the host",
+    }
+    if strings.Join(reported, "\n") != strings.Join(want, "\n") { t.Fatalf("reported:\n%s", strings.Join(reported, "\n")) }
+}
+
+func TestSharedHostRefusesMutualTLSWithOtherLabels(t *testing.T){
+    path := func(id, name, ca string) dockerx.Info {
+        labels := map[string]string{LabelEnable:"true", LabelRouting:"path"}
+        if ca != "" { labels[LabelMTLSCA] = ca }
+        return dockerx.Info{ID: id, Name: name, Ports: []int{80}, Labels: labels}
+    }
+    svcs := DiscoverFromInfos([]dockerx.Info{path("1", "docs", ""), path("2", "admin", "ops"), path("3", "vault", "ops")}, "host1", "tn")
+    refused, warnings := SharedHostTLS(svcs)
+    if !refused["admin"] || !refused["vault"] || refused["docs"] || len(warnings) != 2 || !strings.Contains(warnings[0].Error(), "admin: tls labels differ from those of docs") { t.Fatalf("refused %v, warnings %v", refused, warnings) }
+    if r := Routes(svcs); len(r) != 1 || r[0].Name != "docs" { t.Fatalf("expected only docs routed, got %+v", r) }
+
+    // Services asking for the same CA agree, so they keep their routers.
+    svcs = DiscoverFromInfos([]dockerx.Info{path("2", "admin", "ops"), path("3", "vault", "ops")}, "host1", "tn")
+    if refused, warnings := SharedHostTLS(svcs); len(refused) != 0 || len(warnings) != 0 || len(Routes(svcs)) != 2 { t.Fatalf("refused %v, warnings %v", refused, warnings) }
+}
+
+func TestBackendsSkipSecurityLabelsTheyCannotEnforce(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"web", IP:"172.18.0.2", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelAllowList:"tailnet"}},
+        {ID:"2", Name:"admin", IP:"172.18.0.3", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMTLSCA:"ops"}},
+        {ID:"3", Name:"wiki", IP:"172.18.0.4", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelBasicAuthFile:"/auth/users", LabelTLSMin:"1.3"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    if sites := CaddySites(svcs, nil); len(sites) != 1 || sites[0].Host != "web.host1.tn.ts.net" { t.Fatalf("caddy sites: %+v", sites) }
+    if routes := ProxyRoutes(svcs); len(routes) != 1 || routes[0].Host != "web.host1.tn.ts.net" { t.Fatalf("proxy routes: %+v", routes) }
+    if sites := EnvoySites(svcs, nil); len(sites) != 0 { t.Fatalf("envoy enforces no security label: %+v", sites) }
+
+    var reported []string
+    o := Orchestrator{Provider: &dockerx.FakeProvider{Items: infos}, Host: "host1", Tailnet: "tn", Unenforced: CaddyUnenforced, Report: func(err error){ reported = append(reported, err.Error()) }}
+    if _, _, err := o.SyncOnce(context.Background()); err != nil { t.Fatal(err) }
+    want := []string{
+        "admin: tailwhale.mtls.ca can't be enforced by this proxy; not routed",
+        "wiki: tailwhale.middlewares.basicauth.usersfile, tailwhale.tls.minVersion can't be enforced by this proxy; not routed",
+    }
+    if strings.Join(reported, "\n") != strings.Join(want, "\n") { t.Fatalf("reported:\n%s", strings.Join(reported, "\n")) }
+}
+
+func TestCaddySitesSkipNonHTTPAndSidecars(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"web", IP:"172.18.0.2", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelAllowList:"tailnet"}},
+        {ID:"2", Name:"pg", Ports: []int{5432}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"tcp"}},
+        {ID:"3", Name:"side", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMode:"B"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    certs := tcfg.TLSConfig{"web.host1.tn.ts.net": {CertFile: "/c/web.crt", KeyFile: "/c/web.key"}}
+    sites := CaddySites(svcs, certs)
+    if len(sites) != 1 || sites[0].Upstreams[0] != "172.18.0.2:80" || sites[0].CertFile != "/c/web.crt" || len(sites[0].AllowList) != 2 { t.Fatalf("unexpected sites: %+v", sites) }
+}
+
+func TestProxyRoutesAndH2CScheme(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"grpc", IP:"172.18.0.3", Ports: []int{9000}, Labels: map[string]string{LabelEnable:"true", LabelScheme:"H2C"}},
+        {ID:"2", Name:"pg", Ports: []int{5432}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"tcp"}},
+        {ID:"3", Name:"pub", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMode:"C", LabelAllowList:"tailnet"}},
+        {ID:"4", Name:"redis", Ports: []int{6380}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"tcp", LabelTLS:"passthrough"}},
+        {ID:"5", Name:"dns", Ports: []int{53}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"udp"}},
+    }
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    if r := Routes(svcs); r[1].Servers[0] != "h2c://172.18.0.3:9000" { t.Fatalf("unexpected traefik server: %v", r[1].Servers) }
+    routes := ProxyRoutes(svcs)
+    if len(routes) != 4 { t.Fatalf("expected http and tcp routes only: %+v", routes) }
+    if routes[0].Host != "grpc.host1.tn.ts.net" || routes[0].Upstreams[0] != "172.18.0.3:9000" || routes[0].Scheme != "h2c" { t.Fatalf("unexpected grpc route: %+v", routes[0]) }
+    if routes[1].Protocol != "tcp" || routes[1].Passthrough || routes[1].Upstreams[0] != "pg:5432" { t.Fatalf("unexpected pg route: %+v", routes[1]) }
+    if routes[2].Host != "host1.ts.net" || len(routes[2].AllowList) != 2 { t.Fatalf("unexpected funnel route: %+v", routes[2]) }
+    if routes[3].Protocol != "tcp" || !routes[3].Passthrough { t.Fatalf("unexpected redis route: %+v", routes[3]) }
+}
+
+func TestPassthroughTCPServices(t *testing.T){
+    infos := []dockerx.Info{{ID:"1", Name:"redis", Ports: []int{6380}, Labels: map[string]string{LabelEnable:"true", LabelProtocol:"tcp", LabelTLS:"passthrough"}}}
+    svcs := DiscoverFromInfos(infos, "host1", "tn")
+    certs := Orchestrator{Manager: &ts.FileManager{Dir: t.TempDir()}}.certs(svcs)
+    if len(certs) != 0 { t.Fatalf("passthrough services need no certificate: %v", certs) }
+    cfg := tcfg.Build(Routes(svcs), certs, tcfg.Options{})
+    if tls := cfg.TCP.Routers["redis"].TLS; tls == nil || !tls.Passthrough || tls.Options != "" { t.Fatalf("unexpected router tls: %+v", tls) }
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/frnwtr/tailwhale/internal/dockerx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
+
+// Port routing allocates listen ports from this range unless tailwhale.routing.port sets one.
+const (
+    PortRangeStart = 8443
+    PortRangeEnd   = 9442
+)
+
+// PublishedPorts returns the ports containers publish on the host, which Traefik cannot listen on.
+func PublishedPorts(list []dockerx.Info) []int {
+    var out []int
+    for _, c := range list { out = append(out, c.Published...) }
+    return out
+}
+
+// PortLease is the listen port allocated to a port-routed service, and when the service
+// was last seen running.
+type PortLease struct {
+    Port     int       `json:"port"`
+    LastSeen time.Time `json:"lastSeen"`
+}
+
+// PortLeaseTTL is how long a port stays recorded for a service that is gone.
+const PortLeaseTTL = 30 * 24 * time.Hour
+
+// portLeaseRefresh bounds how often LastSeen is bumped, so the state file (which watch
+// polls) is not rewritten on every sync.
+const portLeaseRefresh = 24 * time.Hour
+
+// AllocatePorts gives every port-routed service without a tailwhale.routing.port label its
+// listen port. Ports recorded in st are kept, also for services gone for less than
+// PortLeaseTTL, so a service keeps its URL across restarts; new ones get the lowest port of
+// the range that is neither published nor labelled on or recorded for another service. A
+// recorded port that has since been published elsewhere is given up. Labelled ports are
+// checked too: one labelled on an earlier service is dropped (the service is not routed),
+// one a container publishes is kept with a warning, since that container may be Traefik.
+// It reports whether st changed, and fails when the range has no port left for a service.
+func (st *State) AllocatePorts(svcs []Service, published []int, now time.Time) (bool, error) {
+    now = now.UTC().Truncate(time.Second)
+    taken := map[int]string{} // port -> service; "" for published ports
+    for _, p := range published { taken[p] = "" }
+    seen := map[string]bool{}
+    dropped := map[int]bool{} // index in svcs of services whose labelled port is taken
+    for i := range svcs {
+        s := &svcs[i]
+        if s.Routing != RoutingPort { continue }
+        seen[s.Name] = true
+        if s.ListenPort == 0 { continue }
+        owner, ok := taken[s.ListenPort]
+        switch {
+        case ok && owner != "":
+            s.Warnings = append(s.Warnings, fmt.Sprintf("listen port %d is already labelled on %s; not routed", s.ListenPort, owner))
+            s.ListenPort, dropped[i] = 0, true
+            continue
+        case ok:
+            s.Warnings = append(s.Warnings, fmt.Sprintf("listen port %d is published by a container; Traefik can't bind it unless that container is Traefik", s.ListenPort))
+        }
+        taken[s.ListenPort] = s.Name
+    }
+    changed := false
+    for name, l := range st.Ports {
+        owner, ok := taken[l.Port]
+        if (ok && owner != name) || (!seen[name] && now.Sub(l.LastSeen) > PortLeaseTTL) {
+            delete(st.Ports, name)
+            changed = true
+            continue
+        }
+        taken[l.Port] = name
+    }
+    var full []string
+    for i := range svcs {
+        s := &svcs[i]
+        if s.Routing != RoutingPort || s.ListenPort > 0 || dropped[i] { continue }
+        if l, ok := st.Ports[s.Name]; ok {
+            s.ListenPort = l.Port
+            if now.Sub(l.LastSeen) >= portLeaseRefresh { st.Ports[s.Name], changed = PortLease{Port: l.Port, LastSeen: now}, true }
+            continue
+        }
+        for p := PortRangeStart; p <= PortRangeEnd; p++ {
+            if _, ok := taken[p]; ok { continue }
+            if st.Ports == nil { st.Ports = map[string]PortLease{} }
+            st.Ports[s.Name], s.ListenPort, taken[p] = PortLease{Port: p, LastSeen: now}, p, s.Name
+            changed = true
+            break
+        }
+        if s.ListenPort == 0 { full = append(full, s.Name) }
+    }
+    if len(full) > 0 { return changed, fmt.Errorf("no free listen port left in %d-%d for %s", PortRangeStart, PortRangeEnd, strings.Join(full, ", ")) }
+    return changed, nil
+}
+
+// EntryPoints returns the Traefik static entry points port-routed services are bound to.
+// Services with a tailwhale.entrypoint label use an entry point of their own and are left out.
+func EntryPoints(svcs []Service) tcfg.StaticConfig {
+    var sc tcfg.StaticConfig
+    for _, s := range svcs {
+        if s.Mode != ModeA || s.Routing != RoutingPort || s.ListenPort == 0 || s.EntryPoint != "" { continue }
+        addr := ":" + strconv.Itoa(s.ListenPort)
+        if s.Protocol == tcfg.ProtocolUDP { addr += "/udp" }
+        if sc.EntryPoints == nil { sc.EntryPoints = map[string]tcfg.StaticEntryPoint{} }
+        sc.EntryPoints[tcfg.PortEntryPoint(s.ListenPort)] = tcfg.StaticEntryPoint{Address: addr}
+    }
+    return sc
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+    "context"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/frnwtr/tailwhale/internal/dockerx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
+
+func portRouted(id, name string, extra map[string]string) dockerx.Info {
+    labels := map[string]string{LabelEnable:"true", LabelRouting:"port"}
+    for k, v := range extra { labels[k] = v }
+    return dockerx.Info{ID: id, Name: name, IP: "10.0.0." + id, Ports: []int{80}, Labels: labels}
+}
+
+func TestAllocatePortsIsStableAndAvoidsPublishedPorts(t *testing.T){
+    infos := []dockerx.Info{
+        portRouted("1", "app", nil),
+        portRouted("2", "grafana", map[string]string{LabelRoutingPort:"8444"}),
+        portRouted("3", "web", nil),
+        {ID:"4", Name:"nginx", Ports: []int{443}, Published: []int{8443}, Labels: map[string]string{}},
+    }
+    path := filepath.Join(t.TempDir(), "state.json")
+    o := Orchestrator{Provider: &dockerx.FakeProvider{Items: infos}, Host: "host1", Tailnet: "tn", State: path}
+    svcs, _, err := o.SyncOnce(context.Background())
+    if err != nil { t.Fatal(err) }
+    got := map[string]string{}
+    for _, s := range svcs { got[s.Name] = s.URL() }
+    // 8443 is published by nginx and 8444 labelled on grafana.
+    if got["app"] != "https://host1.tn.ts.net:8445" || got["grafana"] != "https://host1.tn.ts.net:8444" || got["web"] != "https://host1.tn.ts.net:8446" { t.Fatalf("urls = %v", got) }
+
+    // app restarts after web: both keep their ports from the state file.
+    o.Provider = &dockerx.FakeProvider{Items: infos[1:]}
+    if _, _, err := o.SyncOnce(context.Background()); err != nil { t.Fatal(err) }
+    o.Provider = &dockerx.FakeProvider{Items: infos}
+    svcs, _, err = o.SyncOnce(context.Background())
+    if err != nil { t.Fatal(err) }
+    if svcs[0].ListenPort != 8445 || svcs[2].ListenPort != 8446 { t.Fatalf("ports moved: %+v", svcs) }
+    st, _ := LoadState(path)
+    if st.Ports["app"].Port != 8445 || st.Ports["web"].Port != 8446 || len(st.Ports) != 2 { t.Fatalf("state = %+v", st) }
+
+    // A port published meanwhile by another container is given up.
+    infos[3].Published = []int{8443, 8446}
+    svcs, _, _ = o.SyncOnce(context.Background())
+    if svcs[2].ListenPort != 8447 { t.Fatalf("web kept a published port: %+v", svcs[2]) }
+
+    ep := EntryPoints(svcs).EntryPoints
+    if len(ep) != 3 || ep["tailwhale-8444"].Address != ":8444" { t.Fatalf("entry points = %+v", ep) }
+    routes := Routes(svcs)
+    if routes[2].EntryPoint != "tailwhale-8447" { t.Fatalf("web route = %+v", routes[2]) }
+    want := "entryPoints:\n  tailwhale-8444:\n    address: \":8444\"\n"
+    if out := string(tcfg.MarshalStaticYAML(tcfg.StaticConfig{EntryPoints: map[string]tcfg.StaticEntryPoint{"tailwhale-8444": ep["tailwhale-8444"]}})); out != want { t.Fatalf("unexpected YAML:\n%s", out) }
+}
+
+func TestAllocatePortsChecksLabelsPrunesLeasesAndReportsExhaustion(t *testing.T){
+    now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+    st := State{Ports: map[string]PortLease{
+        "old":    {Port: 8443, LastSeen: now.Add(-PortLeaseTTL - time.Hour)},
+        "recent": {Port: 8444, LastSeen: now.Add(-PortLeaseTTL + time.Hour)},
+    }}
+    svcs := []Service{
+        {Name: "a", Routing: RoutingPort, ListenPort: 9000},
+        {Name: "b", Routing: RoutingPort, ListenPort: 9000},
+        {Name: "c", Routing: RoutingPort, ListenPort: 9001},
+        {Name: "d", Routing: RoutingPort},
+    }
+    changed, err := st.AllocatePorts(svcs, []int{9001}, now)
+    if err != nil || !changed { t.Fatalf("changed = %v, err = %v", changed, err) }
+    if svcs[1].ListenPort != 0 || len(svcs[1].Warnings) != 1 || !strings.Contains(svcs[1].Warnings[0], "already labelled on a") { t.Fatalf("b = %+v", svcs[1]) }
+    if svcs[2].ListenPort != 9001 || len(svcs[2].Warnings) != 1 { t.Fatalf("c = %+v", svcs[2]) }
+    // old's lease expired, so d gets its port; recent keeps 8444.
+    if svcs[3].ListenPort != 8443 || len(st.Ports) != 2 || st.Ports["recent"].Port != 8444 || st.Ports["d"] != (PortLease{8443, now}) { t.Fatalf("d = %+v, state = %+v", svcs[3], st) }
+
+    full := State{Ports: map[string]PortLease{}}
+    for p := PortRangeStart; p <= PortRangeEnd; p++ { full.Ports["svc"+strconv.Itoa(p)] = PortLease{Port: p, LastSeen: now} }
+    if _, err := full.AllocatePorts([]Service{{Name: "e", Routing: RoutingPort}}, nil, now); err == nil || !strings.Contains(err.Error(), "for e") { t.Fatalf("expected exhaustion, got %v", err) }
+}
+
+func TestOrchestratorWarnsAboutPortsWithoutStateFile(t *testing.T){
+    var reported []string
+    o := Orchestrator{Provider: &dockerx.FakeProvider{Items: []dockerx.Info{portRouted("1", "app", nil)}}, Host: "host1", Tailnet: "tn", Report: func(err error){ reported = append(reported, err.Error()) }}
+    if _, _, err := o.SyncOnce(context.Background()); err != nil { t.Fatal(err) }
+    if len(reported) != 1 || reported[0] != "app: listen port 8443 is not recorded without a state file and may change" { t.Fatalf("reported = %v", reported) }
+}
@@ -1,5 +1,12 @@
 package core
 
+import (
+    "strconv"
+    "strings"
+
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
+
 // ExposureMode defines how services are exposed.
 type ExposureMode int
 
@@ -11,13 +18,104 @@ const (
 
 // Service represents a container/service that may be exposed.
 type Service struct {
-    ID        string
-    Name      string
-    Host      string
-    Ports     []int
-    Exposed   bool
-    Mode      ExposureMode
-    HostAlias string // optional override
+    ID            string
+    Name          string
+    Host          string
+    Ports         []int
+    Port          int       // backend port routed to (tailwhale.port label or first of Ports)
+    Address       string    // backend address (container IP when known, else name)
+    Replicas      []Replica // every replica, Address and Port first; Compose replicas share one service
+    Protocol      string    // http|tcp|udp
+    Scheme        string    // upstream scheme of http services: http|h2c
+    EntryPoint    string    // optional Traefik entry point override
+    Middlewares   tcfg.Middlewares
+    TLS           tcfg.RouteTLS
+    TLSStore      string    // certificate store; empty means "default"
+    LoadBalancing tcfg.LoadBalancing
+    Exposed       bool
+    Mode          ExposureMode
+    HostAlias     string    // optional override
+    Routing       string    // Mode A routing: subdomain, path or port (see RoutingSubdomain)
+    PathPrefix    string    // path routing: prefix stripped before forwarding, e.g. /web
+    ListenPort    int       // port routing: HTTPS port on the node; 0 until one is set
+    FunnelPort    int       // Mode C: public port (tailwhale.funnel.port); 0 means 443
+    FunnelPath    string    // Mode C: public mount point (tailwhale.funnel.path); empty means /
+    Group         string    // tailwhale.group; members are merged into one service named after it
+    Variants      []Variant // members of a group, by name; empty outside groups
+    Warnings      []string  // label problems; the offending values are ignored
+}
+
+// ServiceWarning is a problem with a service that does not stop a sync, e.g. an invalid label.
+type ServiceWarning struct {
+    Service string
+    Message string
+}
+
+func (w ServiceWarning) Error() string { return w.Service + ": " + w.Message }
+
+// Variant is one member of a group: a container or set of Compose replicas with its share of traffic.
+type Variant struct {
+    Name     string
+    Weight   int
+    Replicas []Replica
+}
+
+// Replica is one container behind a service: its address and the backend port routed to.
+type Replica struct {
+    Address string
+    Port    int
+}
+
+// URL returns the address clients use to reach the service, e.g.
+// https://host.tailnet.ts.net/web/ with path routing or https://host.tailnet.ts.net:8443 with port routing.
+func (s Service) URL() string {
+    host := strings.TrimPrefix(s.Host, "https://")
+    if host == "" { return "" }
+    switch s.Routing {
+    case RoutingPath:
+        return "https://" + host + s.PathPrefix + "/"
+    case RoutingPort:
+        if s.ListenPort > 0 { return "https://" + host + ":" + strconv.Itoa(s.ListenPort) }
+    }
+    return "https://" + host
+}
+
+// Upstreams returns address:port for every replica, or nil without a port or address.
+// In a group these are the replicas of every variant receiving traffic.
+func (s Service) Upstreams() []string {
+    if len(s.Variants) > 0 {
+        var out []string
+        for _, v := range s.ActiveVariants() { out = append(out, v.Upstreams()...) }
+        return out
+    }
+    if s.Address == "" { return nil }
+    replicas := s.Replicas
+    if len(replicas) == 0 { replicas = []Replica{{Address: s.Address, Port: s.Port}} }
+    return Variant{Replicas: replicas}.Upstreams()
+}
+
+// ActiveVariants returns the variants with a positive weight. When every weight is 0
+// the group is balanced evenly instead of being left without backends.
+func (s Service) ActiveVariants() []Variant {
+    var out []Variant
+    for _, v := range s.Variants {
+        if v.Weight > 0 { out = append(out, v) }
+    }
+    if len(out) > 0 { return out }
+    for _, v := range s.Variants {
+        v.Weight = 1
+        out = append(out, v)
+    }
+    return out
+}
+
+// Upstreams returns address:port for every replica of the variant with a known port.
+func (v Variant) Upstreams() []string {
+    var out []string
+    for _, r := range v.Replicas {
+        if r.Port > 0 { out = append(out, r.Address+":"+strconv.Itoa(r.Port)) }
+    }
+    return out
 }
 
 // NameInput contains data to compute a hostname.
@@ -27,6 +125,13 @@ type NameInput struct {
     Tailnet   string
 }
 
+// NodeHostname returns the node's own MagicDNS name, <host>.<tailnet>.ts.net, the one name
+// Tailscale issues a certificate for.
+func NodeHostname(host, tailnet string) string {
+    if host == "" || tailnet == "" { return "" }
+    return host + "." + tailnet + ".ts.net"
+}
+
 // HostnameFor returns the hostname for a service depending on the exposure mode.
 func HostnameFor(mode ExposureMode, in NameInput) string {
     switch mode {
@@ -0,0 +1,89 @@
+package core
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+)
+
+// DefaultStatePath is where tailwhale shift records weights, and sync and watch allocated ports.
+const DefaultStatePath = "/var/lib/tailwhale/state.json"
+
+// State is runtime configuration changed without recreating containers.
+type State struct {
+    // Weights overrides tailwhale.weight labels: group -> variant -> weight.
+    Weights map[string]map[string]int `json:"weights,omitempty"`
+    // Ports records the listen port allocated to each port-routed service, by service name.
+    Ports map[string]PortLease `json:"ports,omitempty"`
+}
+
+// LoadState reads the state file; a missing file is an empty state.
+func LoadState(path string) (State, error) {
+    var st State
+    b, err := os.ReadFile(path)
+    if errors.Is(err, fs.ErrNotExist) { return st, nil }
+    if err != nil { return st, err }
+    if err := json.Unmarshal(b, &st); err != nil { return st, fmt.Errorf("%s: %w", path, err) }
+    return st, nil
+}
+
+// Save writes the state file atomically.
+func (st State) Save(path string) error {
+    b, err := json.MarshalIndent(st, "", "  ")
+    if err != nil { return err }
+    return fsx.WriteFileAtomic(path, append(b, '\n'), 0o644)
+}
+
+// Apply overrides the weights of group variants named in the state. Variants the state
+// does not know (e.g. deployed after the last shift) keep their label weight.
+func (st State) Apply(svcs []Service) []Service {
+    for i := range svcs {
+        w := st.Weights[svcs[i].Group]
+        if len(w) == 0 || len(svcs[i].Variants) == 0 { continue }
+        vs := append([]Variant(nil), svcs[i].Variants...)
+        for j := range vs {
+            if n, ok := w[vs[j].Name]; ok { vs[j].Weight = n }
+        }
+        svcs[i].Variants = vs
+    }
+    return svcs
+}
+
+// Shift gives percent of the group's traffic to the variant named to. The rest is split
+// between the other variants in proportion to their current weights (evenly when they
+// have none), so weights always add up to 100.
+func Shift(s Service, to string, percent int) (map[string]int, error) {
+    if s.Group == "" || len(s.Variants) == 0 { return nil, fmt.Errorf("%s is not a group", s.Name) }
+    if percent < 0 || percent > 100 { return nil, fmt.Errorf("percent must be between 0 and 100, got %d", percent) }
+    if variantIndex(s.Variants, to) < 0 { return nil, fmt.Errorf("group %s has no variant %q (have %s)", s.Group, to, variantNames(s.Variants)) }
+    weights := map[string]int{to: percent}
+    var others []Variant
+    total := 0
+    for _, v := range s.Variants {
+        if v.Name == to { continue }
+        others = append(others, v)
+        total += v.Weight
+    }
+    rest, left, largest := 100-percent, 100-percent, ""
+    for _, v := range others {
+        share := rest / len(others)
+        if total > 0 { share = rest * v.Weight / total }
+        weights[v.Name] = share
+        left -= share
+        if largest == "" || share > weights[largest] { largest = v.Name }
+    }
+    if largest != "" { weights[largest] += left } // rounding leftovers
+    return weights, nil
+}
+
+func variantNames(vs []Variant) string {
+    names := make([]string, len(vs))
+    for i, v := range vs { names[i] = v.Name }
+    sort.Strings(names)
+    return fmt.Sprint(names)
+}
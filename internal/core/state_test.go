@@ -0,0 +1,30 @@
+package core
+
+import (
+    "path/filepath"
+    "testing"
+)
+
+func TestShiftAndApplyState(t *testing.T){
+    api := Service{Name: "api", Group: "api", Variants: []Variant{
+        {Name: "blue", Weight: 3, Replicas: []Replica{{Address: "10.0.0.1", Port: 80}}},
+        {Name: "canary", Weight: 1, Replicas: []Replica{{Address: "10.0.0.3", Port: 80}}},
+        {Name: "green", Weight: 0, Replicas: []Replica{{Address: "10.0.0.2", Port: 80}}},
+    }}
+    w, err := Shift(api, "green", 50)
+    if err != nil { t.Fatal(err) }
+    if w["green"] != 50 || w["blue"] != 38 || w["canary"] != 12 { t.Fatalf("weights = %v", w) }
+    if _, err := Shift(api, "purple", 50); err == nil { t.Fatal("expected unknown variant error") }
+    if _, err := Shift(api, "green", 150); err == nil { t.Fatal("expected percent range error") }
+
+    path := filepath.Join(t.TempDir(), "state.json")
+    if err := (State{Weights: map[string]map[string]int{"api": {"blue": 0, "green": 100}}}).Save(path); err != nil { t.Fatal(err) }
+    st, err := LoadState(path)
+    if err != nil { t.Fatal(err) }
+    got := st.Apply([]Service{api})[0]
+    if got.Variants[0].Weight != 0 || got.Variants[1].Weight != 1 || got.Variants[2].Weight != 100 { t.Fatalf("applied = %+v", got.Variants) }
+    if api.Variants[0].Weight != 3 { t.Fatal("Apply modified the discovered variants") }
+    if up := got.Upstreams(); len(up) != 2 || up[0] != "10.0.0.3:80" || up[1] != "10.0.0.2:80" { t.Fatalf("upstreams = %v", up) }
+
+    if st, err := LoadState(filepath.Join(t.TempDir(), "missing.json")); err != nil || len(st.Weights) != 0 { t.Fatalf("missing state: %+v, %v", st, err) }
+}
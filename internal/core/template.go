@@ -0,0 +1,123 @@
+package core
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "text/template"
+    "time"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+// TemplateBackend renders a user-supplied text/template (nginx, HAProxy, ...) with the
+// routed services and their certificates, and runs Reload when the output changed.
+type TemplateBackend struct {
+    Template string   // path to the text/template file
+    Output   string   // rendered file, written atomically
+    Reload   []string // optional command and arguments, e.g. nginx -s reload
+    Exec     ts.Executor
+}
+
+// TemplateData is the value templates are executed with.
+type TemplateData struct {
+    Services []TemplateService
+    // Servers groups Services by the address clients reach them at: path-routed services
+    // share the node's server, each port-routed one gets its own.
+    Servers []TemplateServer
+}
+
+// TemplateServer is a hostname and port with the services routed there, e.g. an nginx
+// server block holding one location per service (PathPrefix, or / for the whole host).
+type TemplateServer struct {
+    Hostname string
+    Port     int    // 443, or the ListenPort of a port-routed service
+    Protocol string // of its services
+    CertFile string
+    KeyFile  string
+    Services []TemplateService
+}
+
+// TemplateService is a routed service (modes A and C with a known port) and its certificate.
+type TemplateService struct {
+    Service
+    Hostname  string   // Host without scheme
+    Upstream  string   // address:port of the backend (the first replica)
+    Upstreams []string // address:port of every replica
+    CertFile  string
+    KeyFile   string
+}
+
+// NewTemplateData selects the routed services of svcs and attaches their certificates.
+// Port-routed services without a listen port are left out, as with Traefik.
+func NewTemplateData(svcs []Service, certs tcfg.TLSConfig) TemplateData {
+    var data TemplateData
+    servers := map[string]int{} // protocol://hostname:port -> index in data.Servers
+    for _, s := range svcs {
+        upstreams := s.Upstreams()
+        if s.Mode == ModeB || len(upstreams) == 0 || (s.Routing == RoutingPort && s.ListenPort == 0) { continue }
+        t := TemplateService{
+            Service:   s,
+            Hostname:  strings.TrimPrefix(s.Host, "https://"),
+            Upstream:  upstreams[0],
+            Upstreams: upstreams,
+            CertFile:  certs[s.Host].CertFile,
+            KeyFile:   certs[s.Host].KeyFile,
+        }
+        data.Services = append(data.Services, t)
+        port := 443
+        if s.Routing == RoutingPort { port = s.ListenPort }
+        key := s.Protocol + "://" + t.Hostname + ":" + strconv.Itoa(port)
+        i, ok := servers[key]
+        if !ok {
+            i = len(data.Servers)
+            servers[key] = i
+            data.Servers = append(data.Servers, TemplateServer{Hostname: t.Hostname, Port: port, Protocol: s.Protocol, CertFile: t.CertFile, KeyFile: t.KeyFile})
+        }
+        data.Servers[i].Services = append(data.Servers[i].Services, t)
+    }
+    return data
+}
+
+func (b TemplateBackend) Apply(svcs []Service, certs tcfg.TLSConfig) error {
+    tmpl, err := template.New(filepath.Base(b.Template)).Option("missingkey=error").ParseFiles(b.Template)
+    if err != nil { return err }
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, NewTemplateData(svcs, certs)); err != nil { return err }
+    cur, readErr := os.ReadFile(b.Output)
+    if readErr == nil && bytes.Equal(cur, buf.Bytes()) { return nil }
+    if err := fsx.WriteFileAtomic(b.Output, buf.Bytes(), 0o644); err != nil { return err }
+    if len(b.Reload) == 0 { return nil }
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    exe := b.Exec
+    if exe == nil { exe = commandExec{} }
+    if err := exe.Run(ctx, b.Reload[0], b.Reload[1:]...); err != nil {
+        // Put the previous output back, so it matches what the proxy runs and the next sync retries.
+        var restoreErr error
+        if readErr == nil {
+            restoreErr = fsx.WriteFileAtomic(b.Output, cur, 0o644)
+        } else if rerr := os.Remove(b.Output); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) {
+            restoreErr = rerr
+        }
+        return errors.Join(fmt.Errorf("reload %s: %w", strings.Join(b.Reload, " "), err), restoreErr)
+    }
+    return nil
+}
+
+// commandExec runs reload commands on the host.
+type commandExec struct{}
+
+func (commandExec) Run(ctx context.Context, name string, args ...string) error {
+    return exec.CommandContext(ctx, name, args...).Run()
+}
+
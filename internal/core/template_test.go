@@ -0,0 +1,95 @@
+package core
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/frnwtr/tailwhale/internal/dockerx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
+
+type recordExec struct{
+    calls []string
+    err   error
+}
+
+func (r *recordExec) Run(ctx context.Context, name string, args ...string) error {
+    r.calls = append(r.calls, strings.Join(append([]string{name}, args...), " "))
+    return r.err
+}
+
+func templateTestServices() ([]Service, tcfg.TLSConfig) {
+    infos := []dockerx.Info{
+        {ID:"1", Name:"web", IP:"172.18.0.2", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelAllowList:"tailnet"}},
+        {ID:"2", Name:"side", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelMode:"B"}},
+    }
+    certs := tcfg.TLSConfig{"web.host1.tn.ts.net": {CertFile: "/certs/web.crt", KeyFile: "/certs/web.key"}}
+    return DiscoverFromInfos(infos, "host1", "tn"), certs
+}
+
+func TestTemplateBackendRendersAndReloadsOnChange(t *testing.T){
+    dir := t.TempDir()
+    tmpl := filepath.Join(dir, "list.tmpl")
+    if err := os.WriteFile(tmpl, []byte("{{range .Services}}{{.Hostname}} {{.Upstream}} {{.CertFile}}\n{{end}}"), 0o644); err != nil { t.Fatal(err) }
+    exe := &recordExec{}
+    b := TemplateBackend{Template: tmpl, Output: filepath.Join(dir, "out", "list.conf"), Reload: []string{"nginx", "-s", "reload"}, Exec: exe}
+    svcs, certs := templateTestServices()
+    if err := b.Apply(svcs, certs); err != nil { t.Fatal(err) }
+    got, _ := os.ReadFile(b.Output)
+    if string(got) != "web.host1.tn.ts.net 172.18.0.2:80 /certs/web.crt\n" { t.Fatalf("unexpected output: %q", got) }
+    if err := b.Apply(svcs, certs); err != nil { t.Fatal(err) }
+    if len(exe.calls) != 1 || exe.calls[0] != "nginx -s reload" { t.Fatalf("expected one reload, got %v", exe.calls) }
+}
+
+func TestTemplateBackendRetriesFailedReload(t *testing.T){
+    dir := t.TempDir()
+    tmpl := filepath.Join(dir, "list.tmpl")
+    if err := os.WriteFile(tmpl, []byte("{{range .Services}}{{.Hostname}}\n{{end}}"), 0o644); err != nil { t.Fatal(err) }
+    exe := &recordExec{err: errors.New("exit status 1")}
+    b := TemplateBackend{Template: tmpl, Output: filepath.Join(dir, "list.conf"), Reload: []string{"nginx", "-s", "reload"}, Exec: exe}
+    svcs, certs := templateTestServices()
+    if err := b.Apply(svcs, certs); err == nil { t.Fatal("expected the reload error") }
+    if _, err := os.Stat(b.Output); !os.IsNotExist(err) { t.Fatalf("output of a failed reload should be rolled back: %v", err) }
+
+    if err := os.WriteFile(b.Output, []byte("old\n"), 0o644); err != nil { t.Fatal(err) }
+    if err := b.Apply(svcs, certs); err == nil { t.Fatal("expected the reload error") }
+    if got, _ := os.ReadFile(b.Output); string(got) != "old\n" { t.Fatalf("previous output not restored: %q", got) }
+    exe.err = nil
+    if err := b.Apply(svcs, certs); err != nil || len(exe.calls) != 3 { t.Fatalf("reload not retried: %v, %v", exe.calls, err) }
+    if got, _ := os.ReadFile(b.Output); string(got) != "web.host1.tn.ts.net\n" { t.Fatalf("unexpected output: %q", got) }
+}
+
+func TestExampleTemplatesRender(t *testing.T){
+    svcs, certs := templateTestServices()
+    for _, name := range []string{"nginx.conf.tmpl", "haproxy-crt-list.tmpl"} {
+        out := filepath.Join(t.TempDir(), name)
+        b := TemplateBackend{Template: filepath.Join("..", "..", "examples", "templates", name), Output: out}
+        if err := b.Apply(svcs, certs); err != nil { t.Fatalf("%s: %v", name, err) }
+        got, _ := os.ReadFile(out)
+        if !strings.Contains(string(got), "/certs/web.crt") { t.Fatalf("%s: certificate missing:\n%s", name, got) }
+    }
+}
+
+func TestNginxTemplateSharesTheNodeServerBetweenRoutedServices(t *testing.T){
+    infos := []dockerx.Info{
+        {ID:"1", Name:"docs", IP:"10.0.0.1", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelRouting:"path"}},
+        {ID:"2", Name:"wiki", IP:"10.0.0.2", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelRouting:"path"}},
+        {ID:"3", Name:"admin", IP:"10.0.0.3", Ports: []int{80}, Labels: map[string]string{LabelEnable:"true", LabelRouting:"port", LabelRoutingPort:"8444"}},
+    }
+    certs := tcfg.TLSConfig{"host1.tn.ts.net": {CertFile: "/certs/host1.crt", KeyFile: "/certs/host1.key"}}
+    data := NewTemplateData(DiscoverFromInfos(infos, "host1", "tn"), certs)
+    if len(data.Servers) != 2 || len(data.Servers[1].Services) != 2 || data.Servers[0].Port != 8444 || data.Servers[1].Port != 443 { t.Fatalf("servers = %+v", data.Servers) }
+
+    out := filepath.Join(t.TempDir(), "nginx.conf")
+    b := TemplateBackend{Template: filepath.Join("..", "..", "examples", "templates", "nginx.conf.tmpl"), Output: out}
+    if err := b.Apply(DiscoverFromInfos(infos, "host1", "tn"), certs); err != nil { t.Fatal(err) }
+    got, _ := os.ReadFile(out)
+    conf := string(got)
+    if strings.Count(conf, "server {") != 2 || !strings.Contains(conf, "listen 8444 ssl;") || !strings.Contains(conf, "location /docs/ {") || !strings.Contains(conf, "proxy_pass http://10.0.0.2:80/;") {
+        t.Fatalf("unexpected config:\n%s", conf)
+    }
+}
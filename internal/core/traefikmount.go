@@ -0,0 +1,137 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "path"
+    "path/filepath"
+    "strings"
+
+    "github.com/frnwtr/tailwhale/internal/dockerx"
+    "github.com/frnwtr/tailwhale/internal/mapx"
+    tcfg "github.com/frnwtr/tailwhale/internal/traefik"
+)
+
+// LabelTraefik marks the Traefik container TailWhale writes config for.
+const LabelTraefik = "tailwhale.traefik"
+
+// TraefikAuto asks FindTraefik to pick the container by label, then by image.
+const TraefikAuto = "auto"
+
+// FindTraefik locates the Traefik container. name matches a container name or ID prefix;
+// TraefikAuto (or "") picks the one labelled tailwhale.traefik=true, else the one running a traefik image.
+func FindTraefik(p dockerx.Provider, name string) (dockerx.Info, error) {
+    infos, err := p.List()
+    if err != nil { return dockerx.Info{}, err }
+    var labelled, byImage []dockerx.Info
+    for _, c := range infos {
+        if name != "" && name != TraefikAuto {
+            if c.Name == name || (len(name) >= 12 && strings.HasPrefix(c.ID, name)) { return c, nil }
+            continue
+        }
+        if !c.Running { continue }
+        if strings.EqualFold(c.Labels[LabelTraefik], "true") {
+            labelled = append(labelled, c)
+        } else if isTraefikImage(c.Image) {
+            byImage = append(byImage, c)
+        }
+    }
+    if name != "" && name != TraefikAuto { return dockerx.Info{}, fmt.Errorf("traefik container %q not found", name) }
+    for _, found := range [][]dockerx.Info{labelled, byImage} {
+        switch len(found) {
+        case 0:
+            continue
+        case 1:
+            return found[0], nil
+        default:
+            names := make([]string, len(found))
+            for i, c := range found { names[i] = c.Name }
+            return dockerx.Info{}, fmt.Errorf("several traefik containers (%s): label one with %s=true", strings.Join(names, ", "), LabelTraefik)
+        }
+    }
+    return dockerx.Info{}, fmt.Errorf("no running traefik container found: label it with %s=true", LabelTraefik)
+}
+
+// isTraefikImage matches traefik, traefik:v3.0, docker.io/library/traefik@sha256:… and similar.
+func isTraefikImage(image string) bool {
+    repo := image
+    if i := strings.IndexByte(repo, '@'); i >= 0 { repo = repo[:i] }
+    if i := strings.LastIndexByte(repo, ':'); i > strings.LastIndexByte(repo, '/') { repo = repo[:i] }
+    return path.Base(repo) == "traefik"
+}
+
+// PathMap translates host paths into a container's filesystem through its mounts.
+type PathMap struct {
+    Container string
+    Mounts    []dockerx.Mount
+}
+
+// NewPathMap builds the path map of container c.
+func NewPathMap(c dockerx.Info) PathMap {
+    return PathMap{Container: c.Name, Mounts: c.Mounts}
+}
+
+// Translate returns the container-side path of hostPath, using the most specific mount.
+// Relative paths are resolved against the working directory first.
+func (m PathMap) Translate(hostPath string) (string, bool) {
+    if abs, err := filepath.Abs(hostPath); err == nil { hostPath = abs }
+    var best dockerx.Mount
+    found := false
+    for _, mt := range m.Mounts {
+        src := filepath.Clean(mt.Source)
+        if hostPath != src && !strings.HasPrefix(hostPath, strings.TrimSuffix(src, "/")+"/") { continue }
+        if !found || len(src) > len(filepath.Clean(best.Source)) { best, found = mt, true }
+    }
+    if !found { return "", false }
+    rel := strings.TrimPrefix(hostPath, filepath.Clean(best.Source))
+    return path.Join(best.Destination, filepath.ToSlash(rel)), true
+}
+
+// MountError explains which TailWhale paths the Traefik container cannot see.
+type MountError struct {
+    Container string
+    Missing   map[string]string // what (cert dir, tls path) -> host path
+    Mounts    []dockerx.Mount
+}
+
+func (e *MountError) Error() string {
+    var parts []string
+    for _, what := range mapx.SortedKeys(e.Missing) {
+        parts = append(parts, fmt.Sprintf("%s %s is not mounted into traefik container %q", what, e.Missing[what], e.Container))
+    }
+    mounts := make([]string, len(e.Mounts))
+    for i, mt := range e.Mounts { mounts[i] = mt.Source + " -> " + mt.Destination }
+    if len(mounts) == 0 { mounts = []string{"none"} }
+    return strings.Join(parts, "; ") + " (mounts: " + strings.Join(mounts, ", ") + ")"
+}
+
+// Check verifies that every named host path (e.g. "cert dir") is visible inside the container.
+func (m PathMap) Check(paths map[string]string) error {
+    missing := map[string]string{}
+    for what, p := range paths {
+        if p == "" { continue }
+        if _, ok := m.Translate(p); !ok { missing[what] = p }
+    }
+    if len(missing) == 0 { return nil }
+    return &MountError{Container: m.Container, Missing: missing, Mounts: m.Mounts}
+}
+
+// TLS rewrites certificate and key paths into container-side paths.
+// Paths outside every mount are returned as an error, since Traefik could not read them.
+func (m PathMap) TLS(tls tcfg.TLSConfig) (tcfg.TLSConfig, error) {
+    out := make(tcfg.TLSConfig, len(tls))
+    var errs []error
+    for _, host := range mapx.SortedKeys(tls) {
+        c := tls[host]
+        cert, okCert := m.Translate(c.CertFile)
+        key, okKey := m.Translate(c.KeyFile)
+        if !okCert || !okKey {
+            errs = append(errs, fmt.Errorf("%s: certificate not visible to traefik container %q", host, m.Container))
+            out[host] = c
+            continue
+        }
+        c.CertFile, c.KeyFile = cert, key
+        out[host] = c
+    }
+    return out, errors.Join(errs...)
+}
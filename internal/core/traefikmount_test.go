@@ -0,0 +1,50 @@
+package core
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+
+    "github.com/frnwtr/tailwhale/internal/dockerx"
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+func TestFindTraefikByLabelThenImage(t *testing.T){
+    p := &dockerx.FakeProvider{Items: []dockerx.Info{
+        {ID: "1", Name: "old-proxy", Image: "traefik:v2.11", Running: false},
+        {ID: "2", Name: "proxy", Image: "docker.io/library/traefik:v3.1", Running: true},
+        {ID: "3", Name: "app", Image: "nginx", Running: true},
+    }}
+    c, err := FindTraefik(p, TraefikAuto)
+    if err != nil || c.Name != "proxy" { t.Fatalf("expected proxy by image, got %+v, %v", c, err) }
+    p.Items = append(p.Items, dockerx.Info{ID: "4", Name: "edge", Image: "ghcr.io/acme/edge", Running: true, Labels: map[string]string{LabelTraefik: "true"}})
+    if c, _ := FindTraefik(p, ""); c.Name != "edge" { t.Fatalf("label should win over image, got %+v", c) }
+    if c, _ := FindTraefik(p, "old-proxy"); c.ID != "1" { t.Fatalf("explicit name should match, got %+v", c) }
+    if _, err := FindTraefik(p, "missing"); err == nil { t.Fatal("expected error for unknown container") }
+}
+
+func TestPathMapTranslatesThroughMostSpecificMount(t *testing.T){
+    m := PathMap{Container: "traefik", Mounts: []dockerx.Mount{
+        {Source: "/srv", Destination: "/data"},
+        {Source: "/var/lib/tailwhale/certs", Destination: "/certs", ReadOnly: true},
+    }}
+    if got, ok := m.Translate("/var/lib/tailwhale/certs/a.crt"); !ok || got != "/certs/a.crt" { t.Fatalf("got %q, %v", got, ok) }
+    if got, ok := m.Translate("/srv/traefik/tls.yml"); !ok || got != "/data/traefik/tls.yml" { t.Fatalf("got %q, %v", got, ok) }
+    if _, ok := m.Translate("/srvx/tls.yml"); ok { t.Fatal("sibling prefix must not match") }
+    err := m.Check(map[string]string{"cert dir": "/var/lib/tailwhale/certs", "dynamic config": "/etc/traefik/tls.yml"})
+    var me *MountError
+    if !errors.As(err, &me) || len(me.Missing) != 1 { t.Fatalf("expected one missing path, got %v", err) }
+    if !strings.Contains(err.Error(), `dynamic config /etc/traefik/tls.yml is not mounted into traefik container "traefik"`) { t.Fatalf("unexpected diagnosis: %v", err) }
+}
+
+func TestOrchestratorRewritesCertPaths(t *testing.T){
+    p := &dockerx.FakeProvider{Items: []dockerx.Info{{ID: "1", Name: "app1", Ports: []int{80}, Labels: map[string]string{LabelEnable: "true"}}}}
+    o := Orchestrator{Provider: p, Host: "host1", Tailnet: "tn", Manager: &ts.FileManager{Dir: "/var/lib/tailwhale/certs"}}
+    o.CertPaths = &PathMap{Container: "traefik", Mounts: []dockerx.Mount{{Source: "/var/lib/tailwhale/certs", Destination: "/certs"}}}
+    _, tls, err := o.SyncOnce(context.Background())
+    if err != nil { t.Fatal(err) }
+    if c := tls["app1.host1.tn.ts.net"]; c.CertFile != "/certs/app1.host1.tn.ts.net.crt" || c.KeyFile != "/certs/app1.host1.tn.ts.net.key" { t.Fatalf("unexpected paths: %+v", c) }
+    o.CertPaths = &PathMap{Container: "traefik"}
+    if _, _, err := o.SyncOnce(context.Background()); err == nil { t.Fatal("expected error for unmounted cert dir") }
+}
@@ -2,13 +2,28 @@ package dockerx
 
 // Info represents a subset of container metadata we care about.
 type Info struct {
-    ID      string
-    Name    string
-    Labels  map[string]string
-    Ports   []int
-    Running bool
+    ID        string
+    Name      string
+    Image     string
+    Labels    map[string]string
+    // Ports are the container's exposed ports, which routes target on IP.
+    Ports     []int
+    // Published are the host ports the container's ports are bound to.
+    Published []int
+    // IP is the container address on its first network, when known.
+    IP        string
+    // Mounts lists the container's bind mounts and volumes.
+    Mounts    []Mount
+    Running   bool
     // Event carries a recent event action (e.g., start, stop, destroy) when originating from a watcher.
-    Event   string
+    Event     string
+}
+
+// Mount maps a host path (Source) to a path inside the container (Destination).
+type Mount struct {
+    Source      string
+    Destination string
+    ReadOnly    bool
 }
 
 // Watcher emits container events (start/stop/label changes).
@@ -4,10 +4,13 @@ package dockerx
 
 import (
     "context"
+    "sort"
+    "strconv"
 
     "github.com/docker/docker/api/types"
     "github.com/docker/docker/api/types/events"
     "github.com/docker/docker/api/types/filters"
+    "github.com/docker/docker/api/types/network"
     "github.com/docker/docker/client"
 )
 
@@ -32,11 +35,19 @@ func (p *DockerProvider) List() ([]Info, error){
     for _, c := range cs {
         labels := map[string]string{}
         for k, v := range c.Labels { labels[k] = v }
-        ports := make([]int, 0, len(c.Ports))
-        for _, p := range c.Ports { ports = append(ports, int(p.PublicPort)) }
+        // Routes dial the container address, so Ports are container-side; host bindings go to Published.
+        var ports, published []int
+        for _, p := range c.Ports {
+            ports = append(ports, int(p.PrivatePort))
+            if p.PublicPort > 0 { published = append(published, int(p.PublicPort)) }
+        }
         name := ""
         if len(c.Names) > 0 { name = c.Names[0] }
-        out = append(out, Info{ID: c.ID, Name: trimSlash(name), Labels: labels, Ports: ports, Running: c.State == "running"})
+        ip := ""
+        if c.NetworkSettings != nil { ip = firstIP(c.NetworkSettings.Networks) }
+        mounts := make([]Mount, 0, len(c.Mounts))
+        for _, m := range c.Mounts { mounts = append(mounts, Mount{Source: m.Source, Destination: m.Destination, ReadOnly: !m.RW}) }
+        out = append(out, Info{ID: c.ID, Name: trimSlash(name), Image: c.Image, Labels: labels, Ports: uniqueSorted(ports), Published: uniqueSorted(published), IP: ip, Mounts: mounts, Running: c.State == "running"})
     }
     return out, nil
 }
@@ -51,6 +62,27 @@ func (p *DockerProvider) Watch() (Watcher, error){
     return w, nil
 }
 
+// firstIP returns the address on the alphabetically first network that has one.
+func firstIP(nets map[string]*network.EndpointSettings) string {
+    names := make([]string, 0, len(nets))
+    for n := range nets { names = append(names, n) }
+    sort.Strings(names)
+    for _, n := range names {
+        if e := nets[n]; e != nil && e.IPAddress != "" { return e.IPAddress }
+    }
+    return ""
+}
+
+// uniqueSorted drops the duplicates Docker reports once per address family and protocol.
+func uniqueSorted(ports []int) []int {
+    sort.Ints(ports)
+    out := ports[:0]
+    for i, p := range ports {
+        if i == 0 || p != ports[i-1] { out = append(out, p) }
+    }
+    return out
+}
+
 func trimSlash(s string) string {
     if len(s) > 0 && s[0] == '/' { return s[1:] }
     return s
@@ -90,11 +122,19 @@ func (w *dockerWatcher) loop(){
                         name := trimSlash(json.Name)
                         labels := map[string]string{}
                         for k, v := range json.Config.Labels { labels[k] = v }
-                        ports := make([]int, 0, len(json.NetworkSettings.Ports))
-                        for p := range json.NetworkSettings.Ports { if p.Int() > 0 { ports = append(ports, p.Int()) } }
+                        var ports, published []int
+                        for p, bindings := range json.NetworkSettings.Ports {
+                            if p.Int() > 0 { ports = append(ports, p.Int()) }
+                            for _, b := range bindings {
+                                if hp, err := strconv.Atoi(b.HostPort); err == nil && hp > 0 { published = append(published, hp) }
+                            }
+                        }
                         info.Name = name
+                        info.Image = json.Config.Image
                         info.Labels = labels
-                        info.Ports = ports
+                        for _, m := range json.Mounts { info.Mounts = append(info.Mounts, Mount{Source: m.Source, Destination: m.Destination, ReadOnly: !m.RW}) }
+                        info.Ports, info.Published = uniqueSorted(ports), uniqueSorted(published)
+                        if json.NetworkSettings != nil { info.IP = firstIP(json.NetworkSettings.Networks) }
                         info.Running = json.State != nil && json.State.Running
                     }
                     select { case w.out <- info: case <-w.ctx.Done(): }
@@ -0,0 +1,15 @@
+package envoy
+
+import (
+    "context"
+    "net"
+)
+
+// Server streams snapshots to Envoy over xDS (ADS with LDS, RDS, CDS and SDS).
+type Server interface {
+    // Push makes snap the current configuration for every connected Envoy.
+    Push(snap Snapshot) error
+    // Serve accepts xDS streams on lis until ctx is done.
+    Serve(ctx context.Context, lis net.Listener) error
+}
+
@@ -0,0 +1,15 @@
+//go:build !xds
+
+package envoy
+
+import "errors"
+
+// ErrNoXDS is returned by NewServer in builds without the xds tag.
+var ErrNoXDS = errors.New("envoy: built without xDS support (rebuild with -tags xds)")
+
+// NewServer returns the xDS server for this build.
+// Without the xds build tag, gRPC is not linked in and this returns ErrNoXDS.
+func NewServer() (Server, error) {
+    return nil, ErrNoXDS
+}
+
@@ -0,0 +1,188 @@
+//go:build xds
+
+package envoy
+
+import (
+    "context"
+    "net"
+    "time"
+
+    clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+    corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+    endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+    listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+    routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+    routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+    tlsinspectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
+    hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+    tcpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+    tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+    clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+    discoveryservice "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+    listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+    routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+    secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+    "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+    cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+    resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+    serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+    "google.golang.org/grpc"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/anypb"
+    "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// nodeGroup is the single snapshot key: every Envoy gets the same configuration, whatever its node ID.
+const nodeGroup = "tailwhale"
+
+type allNodes struct{}
+
+func (allNodes) ID(*corev3.Node) string { return nodeGroup }
+
+// NewServer returns a go-control-plane backed xDS server (requires -tags xds).
+func NewServer() (Server, error) {
+    return &xdsServer{cache: cachev3.NewSnapshotCache(true, allNodes{}, nil)}, nil
+}
+
+type xdsServer struct {
+    cache cachev3.SnapshotCache
+}
+
+func (s *xdsServer) Push(snap Snapshot) error {
+    res, err := resources(snap)
+    if err != nil { return err }
+    cs, err := cachev3.NewSnapshot(snap.Version, res)
+    if err != nil { return err }
+    if err := cs.Consistent(); err != nil { return err }
+    return s.cache.SetSnapshot(context.Background(), nodeGroup, cs)
+}
+
+func (s *xdsServer) Serve(ctx context.Context, lis net.Listener) error {
+    srv := serverv3.NewServer(ctx, s.cache, nil)
+    g := grpc.NewServer()
+    discoveryservice.RegisterAggregatedDiscoveryServiceServer(g, srv)
+    listenerservice.RegisterListenerDiscoveryServiceServer(g, srv)
+    routeservice.RegisterRouteDiscoveryServiceServer(g, srv)
+    clusterservice.RegisterClusterDiscoveryServiceServer(g, srv)
+    secretservice.RegisterSecretDiscoveryServiceServer(g, srv)
+    go func(){ <-ctx.Done(); g.GracefulStop() }()
+    return g.Serve(lis)
+}
+
+// resources converts a snapshot into Envoy v3 resources by type URL.
+func resources(snap Snapshot) (map[resourcev3.Type][]types.Resource, error) {
+    out := map[resourcev3.Type][]types.Resource{
+        resourcev3.ListenerType: nil,
+        resourcev3.RouteType:    nil,
+        resourcev3.ClusterType:  nil,
+        resourcev3.SecretType:   nil,
+    }
+    for _, c := range snap.Clusters {
+        out[resourcev3.ClusterType] = append(out[resourcev3.ClusterType], &clusterv3.Cluster{
+            Name:                 c.Name,
+            ConnectTimeout:       durationpb.New(5 * time.Second),
+            ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STRICT_DNS},
+            LoadAssignment: &endpointv3.ClusterLoadAssignment{
+                ClusterName: c.Name,
+                Endpoints: []*endpointv3.LocalityLbEndpoints{{LbEndpoints: []*endpointv3.LbEndpoint{{
+                    HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{Address: socketAddress(c.Address, c.Port)}},
+                }}}},
+            },
+        })
+    }
+    for _, r := range snap.Routes {
+        out[resourcev3.RouteType] = append(out[resourcev3.RouteType], &routev3.RouteConfiguration{
+            Name: r.Name,
+            VirtualHosts: []*routev3.VirtualHost{{
+                Name:    r.Name,
+                Domains: r.Domains,
+                Routes: []*routev3.Route{{
+                    Match:  &routev3.RouteMatch{PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: "/"}},
+                    Action: &routev3.Route_Route{Route: &routev3.RouteAction{ClusterSpecifier: &routev3.RouteAction_Cluster{Cluster: r.Cluster}}},
+                }},
+            }},
+        })
+    }
+    for _, sec := range snap.Secrets {
+        out[resourcev3.SecretType] = append(out[resourcev3.SecretType], &tlsv3.Secret{
+            Name: sec.Name,
+            Type: &tlsv3.Secret_TlsCertificate{TlsCertificate: &tlsv3.TlsCertificate{
+                CertificateChain: &corev3.DataSource{Specifier: &corev3.DataSource_InlineBytes{InlineBytes: sec.CertPEM}},
+                PrivateKey:       &corev3.DataSource{Specifier: &corev3.DataSource_InlineBytes{InlineBytes: sec.KeyPEM}},
+            }},
+        })
+    }
+    if len(snap.Listener.Chains) == 0 { return out, nil }
+    l, err := listener(snap.Listener)
+    if err != nil { return nil, err }
+    out[resourcev3.ListenerType] = []types.Resource{l}
+    return out, nil
+}
+
+func listener(l Listener) (*listenerv3.Listener, error) {
+    inspector, err := anypb.New(&tlsinspectorv3.TlsInspector{})
+    if err != nil { return nil, err }
+    out := &listenerv3.Listener{
+        Name:    l.Name,
+        Address: socketAddress(l.Address, l.Port),
+        ListenerFilters: []*listenerv3.ListenerFilter{{
+            Name:       "envoy.filters.listener.tls_inspector",
+            ConfigType: &listenerv3.ListenerFilter_TypedConfig{TypedConfig: inspector},
+        }},
+    }
+    for _, c := range l.Chains {
+        fc, err := filterChain(c)
+        if err != nil { return nil, err }
+        out.FilterChains = append(out.FilterChains, fc)
+    }
+    return out, nil
+}
+
+func filterChain(c FilterChain) (*listenerv3.FilterChain, error) {
+    tlsCtx, err := anypb.New(&tlsv3.DownstreamTlsContext{CommonTlsContext: &tlsv3.CommonTlsContext{
+        TlsCertificateSdsSecretConfigs: []*tlsv3.SdsSecretConfig{{Name: c.Secret, SdsConfig: adsSource()}},
+    }})
+    if err != nil { return nil, err }
+    var name string
+    var cfg proto.Message
+    if c.Cluster != "" {
+        name, cfg = "envoy.filters.network.tcp_proxy", &tcpproxyv3.TcpProxy{StatPrefix: c.Name, ClusterSpecifier: &tcpproxyv3.TcpProxy_Cluster{Cluster: c.Cluster}}
+    } else {
+        router, err := anypb.New(&routerv3.Router{})
+        if err != nil { return nil, err }
+        name, cfg = "envoy.filters.network.http_connection_manager", &hcmv3.HttpConnectionManager{
+            StatPrefix:     c.Name,
+            CodecType:      hcmv3.HttpConnectionManager_AUTO,
+            RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{Rds: &hcmv3.Rds{ConfigSource: adsSource(), RouteConfigName: c.RouteConfig}},
+            HttpFilters: []*hcmv3.HttpFilter{{
+                Name:       "envoy.filters.http.router",
+                ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: router},
+            }},
+        }
+    }
+    typed, err := anypb.New(cfg)
+    if err != nil { return nil, err }
+    return &listenerv3.FilterChain{
+        Name:             c.Name,
+        FilterChainMatch: &listenerv3.FilterChainMatch{ServerNames: []string{c.ServerName}},
+        Filters:          []*listenerv3.Filter{{Name: name, ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: typed}}},
+        TransportSocket:  &corev3.TransportSocket{Name: "envoy.transport_sockets.tls", ConfigType: &corev3.TransportSocket_TypedConfig{TypedConfig: tlsCtx}},
+    }, nil
+}
+
+// adsSource points RDS and SDS at the ADS stream Envoy already has open with TailWhale.
+func adsSource() *corev3.ConfigSource {
+    return &corev3.ConfigSource{
+        ResourceApiVersion:    corev3.ApiVersion_V3,
+        ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+    }
+}
+
+func socketAddress(addr string, port int) *corev3.Address {
+    return &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+        Protocol:      corev3.SocketAddress_TCP,
+        Address:       addr,
+        PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(port)},
+    }}}
+}
+
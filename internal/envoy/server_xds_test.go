@@ -0,0 +1,54 @@
+//go:build xds
+
+package envoy
+
+import (
+    "context"
+    "net"
+    "testing"
+    "time"
+
+    corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+    tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+    discoveryservice "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+    resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials/insecure"
+)
+
+func TestXDSServerStreamsSnapshotToEnvoy(t *testing.T){
+    snap, err := Build(testSites(t), Options{})
+    if err != nil { t.Fatal(err) }
+    srv, err := NewServer()
+    if err != nil { t.Fatal(err) }
+    if err := srv.Push(snap); err != nil { t.Fatal(err) }
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    go func(){ _ = srv.Serve(ctx, lis) }()
+
+    conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+    stream, err := discoveryservice.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+    if err != nil { t.Fatal(err) }
+    node := &corev3.Node{Id: "envoy-test"}
+    for _, typ := range []string{resourcev3.ClusterType, resourcev3.ListenerType} {
+        if err := stream.Send(&discoveryservice.DiscoveryRequest{Node: node, TypeUrl: typ}); err != nil { t.Fatal(err) }
+        resp, err := stream.Recv()
+        if err != nil { t.Fatal(err) }
+        if resp.VersionInfo != snap.Version || len(resp.Resources) == 0 { t.Fatalf("%s: unexpected response %v", typ, resp) }
+    }
+    // In ADS mode Envoy asks for every secret its filter chains reference at once.
+    names := []string{"pg.tn.ts.net", "web.tn.ts.net"}
+    if err := stream.Send(&discoveryservice.DiscoveryRequest{Node: node, TypeUrl: resourcev3.SecretType, ResourceNames: names}); err != nil { t.Fatal(err) }
+    resp, err := stream.Recv()
+    if err != nil { t.Fatal(err) }
+    if len(resp.Resources) != 2 { t.Fatalf("unexpected secrets: %v", resp) }
+    for _, r := range resp.Resources {
+        var secret tlsv3.Secret
+        if err := r.UnmarshalTo(&secret); err != nil { t.Fatal(err) }
+        if string(secret.GetTlsCertificate().GetCertificateChain().GetInlineBytes()) != testCertPEM { t.Fatalf("%s: certificate not served over SDS", secret.Name) }
+    }
+}
@@ -0,0 +1,116 @@
+package envoy
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+)
+
+// DefaultListenPort is the port of the generated TLS listener.
+const DefaultListenPort = 443
+
+// Site is one service Envoy terminates TLS for: HTTP sites are routed through RDS,
+// TCP sites are proxied as-is after TLS termination.
+type Site struct {
+    Name     string
+    Host     string
+    Protocol string // http or tcp
+    Address  string
+    Port     int
+    CertFile string
+    KeyFile  string
+}
+
+// Options tune the generated listener.
+type Options struct {
+    ListenAddress string // defaults to 0.0.0.0
+    ListenPort    int    // defaults to DefaultListenPort
+}
+
+// Snapshot is a protocol-neutral view of the xDS resources served to Envoy.
+// Version changes only when the content does, so unchanged resyncs push nothing.
+type Snapshot struct {
+    Version  string
+    Listener Listener
+    Routes   []RouteConfig
+    Clusters []Cluster
+    Secrets  []Secret
+}
+
+// Listener is the single TLS listener with one SNI-matched filter chain per site.
+type Listener struct {
+    Name    string
+    Address string
+    Port    int
+    Chains  []FilterChain
+}
+
+// FilterChain terminates TLS for ServerName with Secret (fetched over SDS), then either
+// hands HTTP to RouteConfig (RDS) or proxies TCP to Cluster.
+type FilterChain struct {
+    Name        string
+    ServerName  string
+    Secret      string
+    RouteConfig string
+    Cluster     string
+}
+
+// RouteConfig sends every request for Domains to Cluster.
+type RouteConfig struct {
+    Name    string
+    Domains []string
+    Cluster string
+}
+
+type Cluster struct {
+    Name    string
+    Address string
+    Port    int
+}
+
+// Secret holds a certificate and key in PEM; it is never logged.
+type Secret struct {
+    Name    string
+    CertPEM []byte `json:"-"`
+    KeyPEM  []byte `json:"-"`
+}
+
+// Build turns sites into a snapshot, reading certificates from disk.
+// Sites without a certificate, address or port are skipped since Envoy could not serve them.
+func Build(sites []Site, opt Options) (Snapshot, error) {
+    sorted := append([]Site(nil), sites...)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+    snap := Snapshot{Listener: Listener{Name: "tailwhale_https", Address: opt.ListenAddress, Port: opt.ListenPort}}
+    if snap.Listener.Address == "" { snap.Listener.Address = "0.0.0.0" }
+    if snap.Listener.Port == 0 { snap.Listener.Port = DefaultListenPort }
+    h := sha256.New()
+    for _, s := range sorted {
+        if s.Name == "" || s.Host == "" || s.Address == "" || s.Port == 0 || s.CertFile == "" || s.KeyFile == "" { continue }
+        if s.Protocol != "" && s.Protocol != "http" && s.Protocol != "tcp" { continue }
+        cert, err := os.ReadFile(s.CertFile)
+        if err != nil { return Snapshot{}, fmt.Errorf("envoy secret %s: %w", s.Name, err) }
+        key, err := os.ReadFile(s.KeyFile)
+        if err != nil { return Snapshot{}, fmt.Errorf("envoy secret %s: %w", s.Name, err) }
+        snap.Secrets = append(snap.Secrets, Secret{Name: s.Host, CertPEM: cert, KeyPEM: key})
+        snap.Clusters = append(snap.Clusters, Cluster{Name: s.Name, Address: s.Address, Port: s.Port})
+        chain := FilterChain{Name: s.Name, ServerName: s.Host, Secret: s.Host}
+        if s.Protocol == "tcp" {
+            chain.Cluster = s.Name
+        } else {
+            chain.RouteConfig = s.Name
+            snap.Routes = append(snap.Routes, RouteConfig{Name: s.Name, Domains: []string{s.Host}, Cluster: s.Name})
+        }
+        snap.Listener.Chains = append(snap.Listener.Chains, chain)
+        h.Write(cert)
+        h.Write(key)
+    }
+    meta, err := json.Marshal(snap)
+    if err != nil { return Snapshot{}, err }
+    h.Write(meta)
+    snap.Version = hex.EncodeToString(h.Sum(nil))[:16]
+    return snap, nil
+}
+
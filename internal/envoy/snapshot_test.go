@@ -0,0 +1,39 @@
+package envoy
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+const testCertPEM = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+func testSites(t *testing.T) []Site {
+    t.Helper()
+    dir := t.TempDir()
+    for _, f := range []string{"web.crt", "web.key", "pg.crt", "pg.key"} {
+        if err := os.WriteFile(filepath.Join(dir, f), []byte(testCertPEM), 0o600); err != nil { t.Fatal(err) }
+    }
+    return []Site{
+        {Name: "web", Host: "web.tn.ts.net", Protocol: "http", Address: "172.18.0.2", Port: 80, CertFile: filepath.Join(dir, "web.crt"), KeyFile: filepath.Join(dir, "web.key")},
+        {Name: "pg", Host: "pg.tn.ts.net", Protocol: "tcp", Address: "pg", Port: 5432, CertFile: filepath.Join(dir, "pg.crt"), KeyFile: filepath.Join(dir, "pg.key")},
+        {Name: "dns", Host: "dns.tn.ts.net", Protocol: "udp", Address: "dns", Port: 53, CertFile: filepath.Join(dir, "web.crt"), KeyFile: filepath.Join(dir, "web.key")},
+    }
+}
+
+func TestBuildSnapshot(t *testing.T){
+    sites := testSites(t)
+    snap, err := Build(sites, Options{})
+    if err != nil { t.Fatal(err) }
+    if len(snap.Clusters) != 2 || len(snap.Secrets) != 2 || len(snap.Routes) != 1 { t.Fatalf("unexpected resources: %+v", snap) }
+    l := snap.Listener
+    if l.Address != "0.0.0.0" || l.Port != DefaultListenPort || len(l.Chains) != 2 { t.Fatalf("unexpected listener: %+v", l) }
+    if c := l.Chains[0]; c.Name != "pg" || c.Cluster != "pg" || c.RouteConfig != "" || c.Secret != "pg.tn.ts.net" { t.Fatalf("tcp chain: %+v", c) }
+    if c := l.Chains[1]; c.ServerName != "web.tn.ts.net" || c.RouteConfig != "web" { t.Fatalf("http chain: %+v", c) }
+
+    again, _ := Build(sites, Options{})
+    if again.Version != snap.Version { t.Fatal("unchanged input should keep the version") }
+    if err := os.WriteFile(sites[0].CertFile, []byte(testCertPEM+"\n"), 0o600); err != nil { t.Fatal(err) }
+    renewed, _ := Build(sites, Options{})
+    if renewed.Version == snap.Version { t.Fatal("renewed certificate should bump the version") }
+}
@@ -0,0 +1,12 @@
+// Package mapx holds small helpers for maps keyed by name.
+package mapx
+
+import "sort"
+
+// SortedKeys returns the keys of m in ascending order, for deterministic output.
+func SortedKeys[V any](m map[string]V) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m { keys = append(keys, k) }
+    sort.Strings(keys)
+    return keys
+}
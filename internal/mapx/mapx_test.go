@@ -0,0 +1,11 @@
+package mapx
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestSortedKeys(t *testing.T){
+    if got := SortedKeys(map[string]int{"b": 1, "a": 2, "c": 3}); !reflect.DeepEqual(got, []string{"a", "b", "c"}) { t.Fatalf("keys = %v", got) }
+    if got := SortedKeys[bool](nil); len(got) != 0 { t.Fatalf("keys of nil = %v", got) }
+}
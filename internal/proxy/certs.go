@@ -0,0 +1,111 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "os"
+    "sync"
+    "time"
+
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+// DefaultRenewBefore is how close to expiry a served certificate triggers a renewal.
+const DefaultRenewBefore = 14 * 24 * time.Hour
+
+// Certificates hands out certificates by SNI from a tailscale.Manager. Loaded pairs are
+// cached and reloaded as soon as their files change, so renewed certificates are served
+// on the next handshake without a restart.
+type Certificates struct {
+    Manager     ts.Manager
+    RenewBefore time.Duration // defaults to DefaultRenewBefore
+
+    mu    sync.Mutex
+    cache map[string]*loadedCert
+}
+
+type loadedCert struct {
+    cert             *tls.Certificate
+    certFile         string
+    keyFile          string
+    certStamp        stamp
+    keyStamp         stamp
+    renewing         bool
+    lastRenewAttempt time.Time
+}
+
+// stamp identifies a version of a file on disk.
+type stamp struct {
+    mod  time.Time
+    size int64
+}
+
+func stampOf(path string) (stamp, error) {
+    fi, err := os.Stat(path)
+    if err != nil { return stamp{}, err }
+    return stamp{fi.ModTime(), fi.Size()}, nil
+}
+
+// current reports whether neither file changed since the pair was loaded.
+func (l *loadedCert) current() bool {
+    cs, err := stampOf(l.certFile)
+    if err != nil || cs != l.certStamp { return false }
+    ks, err := stampOf(l.keyFile)
+    return err == nil && ks == l.keyStamp
+}
+
+// Get returns the certificate for host. While a pair cannot be (re)loaded, for instance
+// because a renewal has written only one of the files yet, the previous one keeps being served.
+func (c *Certificates) Get(host string) (*tls.Certificate, error) {
+    c.mu.Lock()
+    l := c.cache[host]
+    c.mu.Unlock()
+    if l != nil && l.current() {
+        c.maybeRenew(host, l)
+        return l.cert, nil
+    }
+    tc, err := c.Manager.Ensure(host)
+    if err == nil { l, err = c.load(host, tc.Path, tc.KeyPath) }
+    if err != nil {
+        if l != nil { return l.cert, nil }
+        return nil, err
+    }
+    c.maybeRenew(host, l)
+    return l.cert, nil
+}
+
+func (c *Certificates) load(host, certFile, keyFile string) (*loadedCert, error) {
+    cs, err := stampOf(certFile)
+    if err != nil { return nil, err }
+    ks, err := stampOf(keyFile)
+    if err != nil { return nil, err }
+    pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil { return nil, err }
+    if pair.Leaf == nil {
+        if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil { return nil, err }
+    }
+    l := &loadedCert{cert: &pair, certFile: certFile, keyFile: keyFile, certStamp: cs, keyStamp: ks}
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.cache == nil { c.cache = map[string]*loadedCert{} }
+    c.cache[host] = l
+    return l, nil
+}
+
+// maybeRenew asks the manager for a new certificate in the background once l nears expiry.
+// Failed attempts are retried at most hourly; a successful renewal is picked up by current().
+func (c *Certificates) maybeRenew(host string, l *loadedCert) {
+    before := c.RenewBefore
+    if before == 0 { before = DefaultRenewBefore }
+    if time.Until(l.cert.Leaf.NotAfter) > before { return }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if l.renewing || time.Since(l.lastRenewAttempt) < time.Hour { return }
+    l.renewing, l.lastRenewAttempt = true, time.Now()
+    go func(){
+        _, _ = c.Manager.Renew(host)
+        c.mu.Lock()
+        l.renewing = false
+        c.mu.Unlock()
+    }()
+}
@@ -0,0 +1,14 @@
+//go:build go1.24
+
+package proxy
+
+import "net/http"
+
+// h2cTransport speaks HTTP/2 with prior knowledge to cleartext upstreams (gRPC and other h2c servers).
+func h2cTransport() (http.RoundTripper, error) {
+    t := http.DefaultTransport.(*http.Transport).Clone()
+    var p http.Protocols
+    p.SetUnencryptedHTTP2(true)
+    t.Protocols = &p
+    return t, nil
+}
@@ -0,0 +1,13 @@
+//go:build !go1.24
+
+package proxy
+
+import (
+    "errors"
+    "net/http"
+)
+
+// h2cTransport needs net/http's unencrypted HTTP/2 support, added in Go 1.24.
+func h2cTransport() (http.RoundTripper, error) {
+    return nil, errors.New("h2c upstreams need TailWhale built with Go 1.24 or later")
+}
@@ -0,0 +1,34 @@
+//go:build go1.24
+
+package proxy
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+func TestServerProxiesToH2CUpstreams(t *testing.T){
+    backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+        io.WriteString(w, r.Proto)
+    }))
+    var p http.Protocols
+    p.SetUnencryptedHTTP2(true)
+    backend.Config.Protocols = &p
+    backend.Start()
+    defer backend.Close()
+    dir := t.TempDir()
+    cert := writeCert(t, dir, "grpc.example.ts.net", 1, time.Now().Add(90*24*time.Hour))
+    s := &Server{Certs: &Certificates{Manager: &ts.FileManager{Dir: dir}}}
+    if err := s.Update([]Route{{Host: "grpc.example.ts.net", Upstreams: []string{strings.TrimPrefix(backend.URL, "http://")}, Scheme: SchemeH2C}}); err != nil { t.Fatal(err) }
+    addr := startProxy(t, s)
+    res, err := client(addr, cert).Get("https://grpc.example.ts.net/")
+    if err != nil { t.Fatal(err) }
+    defer res.Body.Close()
+    if b, _ := io.ReadAll(res.Body); string(b) != "HTTP/2.0" { t.Fatalf("upstream saw %q, want HTTP/2.0", b) }
+}
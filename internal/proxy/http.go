@@ -0,0 +1,251 @@
+// Package proxy is TailWhale's built-in TLS proxy for hosts without Traefik: an HTTP
+// reverse proxy plus SNI-routed TCP termination and passthrough on the same listener.
+package proxy
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/http/httputil"
+    "net/netip"
+    "net/url"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Scheme values for Route.Scheme.
+const (
+    SchemeHTTP = "http"
+    SchemeH2C  = "h2c"
+)
+
+// Protocol values for Route.Protocol.
+const (
+    ProtocolHTTP = "http"
+    ProtocolTCP  = "tcp"
+)
+
+// Route sends connections or requests for Host to a container.
+type Route struct {
+    Host        string
+    Upstreams   []string // host:port of every replica, used in turn
+    Protocol    string   // http (default) or tcp
+    Scheme      string   // http routes: http (default) or h2c
+    Passthrough bool     // tcp routes: forward the TLS stream untouched instead of terminating it
+    AllowList   []string // client CIDRs or addresses; empty allows everyone
+}
+
+// Server terminates TLS with certificates chosen by SNI. HTTP routes are reverse-proxied
+// (HTTP/1.1, HTTP/2, WebSocket and h2c); TCP routes get the decrypted stream, or the
+// encrypted one when passed through. Update swaps the routing table atomically: requests
+// and connections in flight keep the route they started with.
+type Server struct {
+    Certs    *Certificates
+    ErrorLog *log.Logger // defaults to the log package's standard logger
+
+    table     atomic.Pointer[routeTable]
+    once      sync.Once
+    transport http.RoundTripper
+    h2c       http.RoundTripper
+    h2cErr    error
+}
+
+type routeTable map[string]*entry
+
+type entry struct {
+    route   Route
+    allow   []netip.Prefix
+    handler http.Handler
+    next    atomic.Uint32
+}
+
+// upstream picks the replica for the next request or connection, round-robin.
+func (e *entry) upstream() string {
+    n := e.next.Add(1) - 1
+    return e.route.Upstreams[int(n%uint32(len(e.route.Upstreams)))]
+}
+
+func (s *Server) init() {
+    s.once.Do(func(){
+        s.transport = http.DefaultTransport.(*http.Transport).Clone()
+        s.h2c, s.h2cErr = h2cTransport()
+    })
+}
+
+// Update replaces the routing table. Invalid routes are left out and reported; the others take effect.
+func (s *Server) Update(routes []Route) error {
+    s.init()
+    t := routeTable{}
+    var errs []error
+    for _, r := range routes {
+        e, err := s.newEntry(r)
+        if err != nil { errs = append(errs, fmt.Errorf("%s: %w", r.Host, err)); continue }
+        t[strings.ToLower(r.Host)] = e
+    }
+    s.table.Store(&t)
+    return errors.Join(errs...)
+}
+
+func (s *Server) newEntry(r Route) (*entry, error) {
+    e := &entry{route: r}
+    if len(r.Upstreams) == 0 { return nil, errors.New("no upstream") }
+    for _, a := range r.AllowList {
+        p, err := parsePrefix(a)
+        if err != nil { return nil, err }
+        e.allow = append(e.allow, p)
+    }
+    switch r.Protocol {
+    case "", ProtocolHTTP:
+    case ProtocolTCP:
+        return e, nil
+    default:
+        return nil, fmt.Errorf("unsupported protocol %q", r.Protocol)
+    }
+    transport := s.transport
+    switch r.Scheme {
+    case "", SchemeHTTP:
+    case SchemeH2C:
+        if s.h2cErr != nil { return nil, s.h2cErr }
+        transport = s.h2c
+    default:
+        return nil, fmt.Errorf("unsupported upstream scheme %q", r.Scheme)
+    }
+    e.handler = &httputil.ReverseProxy{
+        Rewrite: func(pr *httputil.ProxyRequest){
+            pr.SetURL(&url.URL{Scheme: "http", Host: e.upstream()})
+            pr.SetXForwarded()
+            pr.Out.Host = pr.In.Host
+        },
+        Transport:     transport,
+        FlushInterval: -1, // stream responses (SSE, gRPC) as they arrive
+        ErrorLog:      s.ErrorLog,
+    }
+    return e, nil
+}
+
+// parsePrefix accepts a CIDR or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+    s = strings.TrimSpace(s)
+    if p, err := netip.ParsePrefix(s); err == nil { return p.Masked(), nil }
+    a, err := netip.ParseAddr(s)
+    if err != nil { return netip.Prefix{}, fmt.Errorf("invalid allowlist entry %q", s) }
+    return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+func (s *Server) lookup(host string) *entry {
+    t := s.table.Load()
+    if t == nil { return nil }
+    return (*t)[strings.ToLower(host)]
+}
+
+// GetCertificate serves the certificate of a routed host; other names fail the handshake.
+func (s *Server) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    if s.lookup(hello.ServerName) == nil { return nil, fmt.Errorf("no route for %q", hello.ServerName) }
+    return s.Certs.Get(strings.ToLower(hello.ServerName))
+}
+
+// TLSConfig returns the server-side TLS settings; HTTP/2 is negotiated through ALPN.
+func (s *Server) TLSConfig() *tls.Config {
+    return &tls.Config{
+        MinVersion:     tls.VersionTLS12,
+        GetCertificate: s.GetCertificate,
+        NextProtos:     []string{"h2", "http/1.1"},
+    }
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil { host = h }
+    e := s.lookup(host)
+    if e == nil {
+        http.Error(w, "unknown host", http.StatusNotFound)
+        return
+    }
+    if r.TLS != nil && !strings.EqualFold(r.TLS.ServerName, host) {
+        // The connection was authorised for another name (e.g. an allowlisted one).
+        http.Error(w, "misdirected request", http.StatusMisdirectedRequest)
+        return
+    }
+    if len(e.allow) > 0 && !allowed(e.allow, r.RemoteAddr) {
+        http.Error(w, "forbidden", http.StatusForbidden)
+        return
+    }
+    e.handler.ServeHTTP(w, r)
+}
+
+func allowed(allow []netip.Prefix, remote string) bool {
+    ap, err := netip.ParseAddrPort(remote)
+    if err != nil { return false }
+    addr := ap.Addr().Unmap()
+    for _, p := range allow {
+        if p.Contains(addr) { return true }
+    }
+    return false
+}
+
+// Serve accepts connections on lis until ctx is cancelled. Each ClientHello is peeked to
+// pick the route by SNI: TCP routes are handled here, everything else goes to the HTTP
+// server. On cancellation HTTP requests in flight get up to ten seconds to finish.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+    s.init()
+    hl := newConnListener(lis.Addr())
+    srv := &http.Server{Handler: s, TLSConfig: s.TLSConfig(), ReadHeaderTimeout: 10 * time.Second, ErrorLog: s.ErrorLog}
+    served := make(chan error, 1)
+    go func(){ served <- srv.ServeTLS(hl, "", "") }()
+    stopped := make(chan struct{})
+    go func(){
+        defer close(stopped)
+        select {
+        case <-ctx.Done():
+        case <-served:
+        }
+        _ = lis.Close()
+        sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        _ = srv.Shutdown(sctx)
+    }()
+    for {
+        c, err := lis.Accept()
+        if err != nil {
+            _ = hl.Close()
+            <-stopped
+            if ctx.Err() != nil { return nil }
+            return err
+        }
+        go s.dispatch(c, hl)
+    }
+}
+
+// dispatch routes one accepted connection by the SNI of its ClientHello.
+func (s *Server) dispatch(c net.Conn, hl *connListener) {
+    _ = c.SetReadDeadline(time.Now().Add(peekTimeout))
+    name, rc, err := peekServerName(c)
+    if err != nil { c.Close(); return }
+    _ = c.SetReadDeadline(time.Time{})
+    e := s.lookup(name)
+    if e == nil || e.route.Protocol != ProtocolTCP {
+        hl.push(rc) // unknown names fail the handshake in GetCertificate
+        return
+    }
+    if len(e.allow) > 0 && !allowed(e.allow, c.RemoteAddr().String()) { c.Close(); return }
+    if e.route.Passthrough {
+        s.pipe(rc, e.upstream())
+        return
+    }
+    tc := tls.Server(rc, &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: s.GetCertificate})
+    _ = c.SetDeadline(time.Now().Add(peekTimeout))
+    if err := tc.Handshake(); err != nil { c.Close(); return }
+    _ = c.SetDeadline(time.Time{})
+    s.pipe(tc, e.upstream())
+}
+
+func (s *Server) logf(format string, args ...any) {
+    if s.ErrorLog != nil { s.ErrorLog.Printf(format, args...); return }
+    log.Printf(format, args...)
+}
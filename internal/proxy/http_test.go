@@ -0,0 +1,221 @@
+package proxy
+
+import (
+    "bufio"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io"
+    "math/big"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+// writeCert writes a self-signed certificate for host into dir and returns it.
+func writeCert(t *testing.T, dir, host string, serial int64, notAfter time.Time) *x509.Certificate {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil { t.Fatal(err) }
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(serial),
+        Subject:      pkix.Name{CommonName: host},
+        DNSNames:     []string{host},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     notAfter,
+        KeyUsage:     x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil { t.Fatal(err) }
+    kder, err := x509.MarshalECPrivateKey(key)
+    if err != nil { t.Fatal(err) }
+    certPath, keyPath := filepath.Join(dir, host+".crt"), filepath.Join(dir, host+".key")
+    if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0o600); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil { t.Fatal(err) }
+    // Make the change visible even on filesystems with coarse timestamps.
+    future := time.Now().Add(time.Duration(serial) * time.Second)
+    _ = os.Chtimes(certPath, future, future)
+    _ = os.Chtimes(keyPath, future, future)
+    cert, err := x509.ParseCertificate(der)
+    if err != nil { t.Fatal(err) }
+    return cert
+}
+
+// renewManager serves certificates from a directory and records renewals.
+type renewManager struct {
+    ts.FileManager
+    mu      sync.Mutex
+    renewed []string
+}
+
+func (m *renewManager) Renew(host string) (ts.Cert, error) {
+    m.mu.Lock()
+    m.renewed = append(m.renewed, host)
+    m.mu.Unlock()
+    return m.Ensure(host)
+}
+
+func (m *renewManager) renewals() []string {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return append([]string(nil), m.renewed...)
+}
+
+// startProxy serves s on a loopback listener until the test ends.
+func startProxy(t *testing.T, s *Server) string {
+    t.Helper()
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func(){ _ = s.Serve(ctx, lis); close(done) }()
+    t.Cleanup(func(){ cancel(); <-done })
+    return lis.Addr().String()
+}
+
+// client trusts roots and dials addr whatever the URL's host is.
+func client(addr string, roots ...*x509.Certificate) *http.Client {
+    pool := x509.NewCertPool()
+    for _, r := range roots { pool.AddCert(r) }
+    return &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{
+        TLSClientConfig:   &tls.Config{RootCAs: pool},
+        DialContext:       func(ctx context.Context, network, _ string) (net.Conn, error) { return (&net.Dialer{}).DialContext(ctx, network, addr) },
+        DisableKeepAlives: true,
+        ForceAttemptHTTP2: true,
+    }}
+}
+
+func TestServerRoutesBySNIAndReloadsRenewedCertificates(t *testing.T){
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+        io.WriteString(w, r.Host+" "+r.Header.Get("X-Forwarded-Proto"))
+    }))
+    defer backend.Close()
+    dir := t.TempDir()
+    first := writeCert(t, dir, "web.example.ts.net", 1, time.Now().Add(90*24*time.Hour))
+    s := &Server{Certs: &Certificates{Manager: &ts.FileManager{Dir: dir}}}
+    if err := s.Update([]Route{{Host: "web.example.ts.net", Upstreams: []string{strings.TrimPrefix(backend.URL, "http://")}}}); err != nil { t.Fatal(err) }
+    addr := startProxy(t, s)
+
+    res, err := client(addr, first).Get("https://web.example.ts.net/")
+    if err != nil { t.Fatal(err) }
+    body, _ := io.ReadAll(res.Body)
+    res.Body.Close()
+    if res.StatusCode != 200 || string(body) != "web.example.ts.net https" { t.Fatalf("unexpected response %d %q", res.StatusCode, body) }
+    if res.ProtoMajor != 2 { t.Fatalf("expected HTTP/2 to the client, got %s", res.Proto) }
+
+    second := writeCert(t, dir, "web.example.ts.net", 2, time.Now().Add(90*24*time.Hour))
+    res, err = client(addr, second).Get("https://web.example.ts.net/")
+    if err != nil { t.Fatalf("renewed certificate not served: %v", err) }
+    res.Body.Close()
+    if got := res.TLS.PeerCertificates[0].SerialNumber.Int64(); got != 2 { t.Fatalf("served serial %d, want 2", got) }
+
+    if _, err := client(addr, second).Get("https://other.example.ts.net/"); err == nil { t.Fatal("expected handshake failure for an unrouted name") }
+}
+
+func TestServerUpdateKeepsInFlightRequests(t *testing.T){
+    release := make(chan struct{})
+    started := make(chan struct{})
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+        close(started)
+        <-release
+        io.WriteString(w, "done")
+    }))
+    defer backend.Close()
+    dir := t.TempDir()
+    cert := writeCert(t, dir, "slow.example.ts.net", 1, time.Now().Add(90*24*time.Hour))
+    s := &Server{Certs: &Certificates{Manager: &ts.FileManager{Dir: dir}}}
+    _ = s.Update([]Route{{Host: "slow.example.ts.net", Upstreams: []string{strings.TrimPrefix(backend.URL, "http://")}}})
+    addr := startProxy(t, s)
+
+    type result struct{ body string; err error }
+    ch := make(chan result, 1)
+    go func(){
+        res, err := client(addr, cert).Get("https://slow.example.ts.net/")
+        if err != nil { ch <- result{err: err}; return }
+        defer res.Body.Close()
+        b, err := io.ReadAll(res.Body)
+        ch <- result{string(b), err}
+    }()
+    <-started
+    if err := s.Update(nil); err != nil { t.Fatal(err) }
+    close(release)
+    if r := <-ch; r.err != nil || r.body != "done" { t.Fatalf("in-flight request broken: %q %v", r.body, r.err) }
+    if _, err := client(addr, cert).Get("https://slow.example.ts.net/"); err == nil { t.Fatal("removed route still served") }
+}
+
+func TestServerProxiesWebSocketUpgrades(t *testing.T){
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+        if r.Header.Get("Upgrade") != "websocket" { http.Error(w, "upgrade required", http.StatusUpgradeRequired); return }
+        conn, rw, err := w.(http.Hijacker).Hijack()
+        if err != nil { return }
+        defer conn.Close()
+        rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+        rw.Flush()
+        line, _ := rw.ReadString('\n')
+        rw.WriteString("echo: " + line)
+        rw.Flush()
+    }))
+    defer backend.Close()
+    dir := t.TempDir()
+    cert := writeCert(t, dir, "ws.example.ts.net", 1, time.Now().Add(90*24*time.Hour))
+    s := &Server{Certs: &Certificates{Manager: &ts.FileManager{Dir: dir}}}
+    _ = s.Update([]Route{{Host: "ws.example.ts.net", Upstreams: []string{strings.TrimPrefix(backend.URL, "http://")}}})
+    addr := startProxy(t, s)
+
+    pool := x509.NewCertPool()
+    pool.AddCert(cert)
+    conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "ws.example.ts.net", RootCAs: pool, NextProtos: []string{"http/1.1"}})
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(5 * time.Second))
+    io.WriteString(conn, "GET /socket HTTP/1.1\r\nHost: ws.example.ts.net\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+    r := bufio.NewReader(conn)
+    res, err := http.ReadResponse(r, nil)
+    if err != nil { t.Fatal(err) }
+    if res.StatusCode != http.StatusSwitchingProtocols { t.Fatalf("status %d", res.StatusCode) }
+    io.WriteString(conn, "hello\n")
+    if line, _ := r.ReadString('\n'); line != "echo: hello\n" { t.Fatalf("unexpected echo %q", line) }
+}
+
+func TestServerEnforcesAllowList(t *testing.T){
+    dir := t.TempDir()
+    cert := writeCert(t, dir, "private.example.ts.net", 1, time.Now().Add(90*24*time.Hour))
+    s := &Server{Certs: &Certificates{Manager: &ts.FileManager{Dir: dir}}}
+    err := s.Update([]Route{
+        {Host: "private.example.ts.net", Upstreams: []string{"127.0.0.1:1"}, AllowList: []string{"100.64.0.0/10"}},
+        {Host: "broken.example.ts.net", Upstreams: []string{"127.0.0.1:1"}, AllowList: []string{"not-a-cidr"}},
+    })
+    if err == nil || !strings.Contains(err.Error(), "broken.example.ts.net") { t.Fatalf("expected invalid allowlist error, got %v", err) }
+    addr := startProxy(t, s)
+    res, err := client(addr, cert).Get("https://private.example.ts.net/")
+    if err != nil { t.Fatal(err) }
+    res.Body.Close()
+    if res.StatusCode != http.StatusForbidden { t.Fatalf("status %d, want 403", res.StatusCode) }
+}
+
+func TestCertificatesRenewNearExpiry(t *testing.T){
+    dir := t.TempDir()
+    writeCert(t, dir, "old.example.ts.net", 1, time.Now().Add(24*time.Hour))
+    m := &renewManager{FileManager: ts.FileManager{Dir: dir}}
+    c := &Certificates{Manager: m}
+    if _, err := c.Get("old.example.ts.net"); err != nil { t.Fatal(err) }
+    deadline := time.Now().Add(2 * time.Second)
+    for len(m.renewals()) == 0 && time.Now().Before(deadline) { time.Sleep(10 * time.Millisecond) }
+    if got := m.renewals(); len(got) != 1 || got[0] != "old.example.ts.net" { t.Fatalf("renewals = %v", got) }
+    if _, err := c.Get("old.example.ts.net"); err != nil { t.Fatal(err) }
+    if got := m.renewals(); len(got) != 1 { t.Fatalf("renewal retried too soon: %v", got) }
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+    "bytes"
+    "crypto/tls"
+    "errors"
+    "io"
+    "net"
+    "sync"
+    "time"
+)
+
+// peekTimeout bounds how long a client may take to send its ClientHello.
+const peekTimeout = 10 * time.Second
+
+var errSniffed = errors.New("client hello read")
+
+// peekServerName reads the ClientHello from c and returns its SNI (empty when absent)
+// along with a conn that replays the bytes consumed, so the stream can still be
+// terminated or passed through untouched.
+func peekServerName(c net.Conn) (string, net.Conn, error) {
+    var buf bytes.Buffer
+    var name string
+    seen := false
+    err := tls.Server(sniffConn{Conn: c, r: io.TeeReader(c, &buf)}, &tls.Config{
+        GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+            name, seen = hello.ServerName, true
+            return nil, errSniffed
+        },
+    }).Handshake()
+    if !seen { return "", nil, err }
+    return name, &replayConn{Conn: c, r: io.MultiReader(&buf, c)}, nil
+}
+
+// sniffConn lets a throwaway TLS server read the ClientHello without writing anything back.
+type sniffConn struct {
+    net.Conn
+    r io.Reader
+}
+
+func (c sniffConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c sniffConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// replayConn serves the peeked bytes before reading from the connection again.
+type replayConn struct {
+    net.Conn
+    r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *replayConn) CloseWrite() error { return closeWrite(c.Conn) }
+
+func closeWrite(c net.Conn) error {
+    if cw, ok := c.(interface{ CloseWrite() error }); ok { return cw.CloseWrite() }
+    return c.Close()
+}
+
+// pipe copies both directions between client and the upstream address until both sides are done.
+func (s *Server) pipe(client net.Conn, upstream string) {
+    defer client.Close()
+    up, err := net.DialTimeout("tcp", upstream, 10*time.Second)
+    if err != nil { s.logf("proxy: %s: %v", upstream, err); return }
+    defer up.Close()
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func(){ defer wg.Done(); _, _ = io.Copy(up, client); _ = closeWrite(up) }()
+    go func(){ defer wg.Done(); _, _ = io.Copy(client, up); _ = closeWrite(client) }()
+    wg.Wait()
+}
+
+// connListener hands connections accepted (and peeked) by Serve to the HTTP server.
+type connListener struct {
+    addr  net.Addr
+    conns chan net.Conn
+    done  chan struct{}
+    once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+    return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+    select {
+    case c := <-l.conns:
+        return c, nil
+    case <-l.done:
+        return nil, net.ErrClosed
+    }
+}
+
+func (l *connListener) Close() error {
+    l.once.Do(func(){ close(l.done) })
+    return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
+
+func (l *connListener) push(c net.Conn) {
+    select {
+    case l.conns <- c:
+    case <-l.done:
+        c.Close()
+    }
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+    "bufio"
+    "crypto/tls"
+    "crypto/x509"
+    "net"
+    "path/filepath"
+    "testing"
+    "time"
+
+    ts "github.com/frnwtr/tailwhale/internal/tailscale"
+)
+
+// echoServer answers every line with "echo: <line>" on l until the test ends.
+func echoServer(t *testing.T, l net.Listener) string {
+    t.Helper()
+    t.Cleanup(func(){ l.Close() })
+    go func(){
+        for {
+            c, err := l.Accept()
+            if err != nil { return }
+            go func(){
+                defer c.Close()
+                r := bufio.NewReader(c)
+                for {
+                    line, err := r.ReadString('\n')
+                    if err != nil { return }
+                    if _, err := c.Write([]byte("echo: " + line)); err != nil { return }
+                }
+            }()
+        }
+    }()
+    return l.Addr().String()
+}
+
+func roundTrip(t *testing.T, c net.Conn) string {
+    t.Helper()
+    c.SetDeadline(time.Now().Add(5 * time.Second))
+    if _, err := c.Write([]byte("ping\n")); err != nil { t.Fatal(err) }
+    line, err := bufio.NewReader(c).ReadString('\n')
+    if err != nil { t.Fatal(err) }
+    return line
+}
+
+func TestServerTerminatesTCPBySNI(t *testing.T){
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    upstream := echoServer(t, l)
+    dir := t.TempDir()
+    cert := writeCert(t, dir, "pg.example.ts.net", 1, time.Now().Add(90*24*time.Hour))
+    s := &Server{Certs: &Certificates{Manager: &ts.FileManager{Dir: dir}}}
+    if err := s.Update([]Route{{Host: "pg.example.ts.net", Upstreams: []string{upstream}, Protocol: ProtocolTCP}}); err != nil { t.Fatal(err) }
+    addr := startProxy(t, s)
+
+    pool := x509.NewCertPool()
+    pool.AddCert(cert)
+    c, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "pg.example.ts.net", RootCAs: pool})
+    if err != nil { t.Fatal(err) }
+    defer c.Close()
+    if got := roundTrip(t, c); got != "echo: ping\n" { t.Fatalf("unexpected reply %q", got) }
+}
+
+func TestServerPassesTLSThrough(t *testing.T){
+    // The container terminates TLS itself with a certificate the proxy never sees.
+    own := t.TempDir()
+    upstreamCert := writeCert(t, own, "redis.example.ts.net", 42, time.Now().Add(90*24*time.Hour))
+    pair, err := tls.LoadX509KeyPair(filepath.Join(own, "redis.example.ts.net.crt"), filepath.Join(own, "redis.example.ts.net.key"))
+    if err != nil { t.Fatal(err) }
+    l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pair}})
+    if err != nil { t.Fatal(err) }
+    upstream := echoServer(t, l)
+
+    s := &Server{Certs: &Certificates{Manager: &ts.FileManager{Dir: t.TempDir()}}}
+    err = s.Update([]Route{
+        {Host: "redis.example.ts.net", Upstreams: []string{upstream}, Protocol: ProtocolTCP, Passthrough: true},
+        {Host: "private.example.ts.net", Upstreams: []string{upstream}, Protocol: ProtocolTCP, Passthrough: true, AllowList: []string{"10.0.0.0/8"}},
+    })
+    if err != nil { t.Fatal(err) }
+    addr := startProxy(t, s)
+
+    pool := x509.NewCertPool()
+    pool.AddCert(upstreamCert)
+    c, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "redis.example.ts.net", RootCAs: pool})
+    if err != nil { t.Fatal(err) }
+    defer c.Close()
+    if serial := c.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 42 { t.Fatalf("served serial %d, want the container's 42", serial) }
+    if got := roundTrip(t, c); got != "echo: ping\n" { t.Fatalf("unexpected reply %q", got) }
+
+    if c, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "private.example.ts.net", InsecureSkipVerify: true}); err == nil {
+        c.Close()
+        t.Fatal("allowlisted tcp route accepted a loopback client")
+    }
+}
@@ -0,0 +1,218 @@
+package redisx
+
+import (
+    "bufio"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// FakeServer is an in-process Redis stand-in for tests. It speaks RESP2 and implements
+// strings, sets and MULTI/EXEC with WATCH, which is all TailWhale uses.
+type FakeServer struct {
+    password string // when set, commands other than AUTH need authentication first
+    mu       sync.Mutex
+    strs     map[string]string
+    sets     map[string]map[string]bool
+    versions map[string]int // bumped on every write, for WATCH
+    execs    int
+    lis      net.Listener
+}
+
+// NewFakeServer listens on a random loopback port. A non-empty password makes clients AUTH first.
+func NewFakeServer(password string) (*FakeServer, error) {
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { return nil, err }
+    s := &FakeServer{password: password, strs: map[string]string{}, sets: map[string]map[string]bool{}, versions: map[string]int{}, lis: lis}
+    go s.serve()
+    return s, nil
+}
+
+func (s *FakeServer) Addr() string { return s.lis.Addr().String() }
+
+func (s *FakeServer) Close() error { return s.lis.Close() }
+
+// Strings returns a copy of the string keys.
+func (s *FakeServer) Strings() map[string]string {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    out := make(map[string]string, len(s.strs))
+    for k, v := range s.strs { out[k] = v }
+    return out
+}
+
+// Set writes a string key, as another client would.
+func (s *FakeServer) Set(key, value string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.strs[key] = value
+    s.versions[key]++
+}
+
+// Execs counts committed transactions.
+func (s *FakeServer) Execs() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.execs
+}
+
+func (s *FakeServer) serve() {
+    for {
+        c, err := s.lis.Accept()
+        if err != nil { return }
+        go s.handle(c)
+    }
+}
+
+// session is the per-connection state.
+type session struct {
+    authed  bool
+    queued  [][]string
+    multi   bool
+    watched map[string]int
+}
+
+func (s *FakeServer) handle(c net.Conn) {
+    defer c.Close()
+    r, w := bufio.NewReader(c), bufio.NewWriter(c)
+    ss := &session{authed: s.password == ""}
+    for {
+        v, err := ReadReply(r)
+        if err != nil { return }
+        args, err := Strings(v)
+        if err != nil || len(args) == 0 { writeReply(w, Error("ERR protocol error")); w.Flush(); return }
+        writeReply(w, s.command(ss, args))
+        if err := w.Flush(); err != nil { return }
+    }
+}
+
+func (s *FakeServer) command(ss *session, args []string) any {
+    name := strings.ToUpper(args[0])
+    if name == "AUTH" {
+        if len(args) != 2 || args[1] != s.password { return Error("WRONGPASS invalid password") }
+        ss.authed = true
+        return "OK"
+    }
+    if !ss.authed { return Error("NOAUTH Authentication required.") }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    switch name {
+    case "MULTI":
+        if ss.multi { return Error("ERR MULTI calls can not be nested") }
+        ss.multi, ss.queued = true, nil
+        return "OK"
+    case "DISCARD":
+        ss.multi, ss.queued, ss.watched = false, nil, nil
+        return "OK"
+    case "EXEC":
+        if !ss.multi { return Error("ERR EXEC without MULTI") }
+        queued, watched := ss.queued, ss.watched
+        ss.multi, ss.queued, ss.watched = false, nil, nil
+        for k, ver := range watched {
+            if s.versions[k] != ver { return nil }
+        }
+        out := make([]any, len(queued))
+        for i, q := range queued { out[i] = s.exec(q) }
+        s.execs++
+        return out
+    case "WATCH":
+        if ss.multi { return Error("ERR WATCH inside MULTI is not allowed") }
+        if ss.watched == nil { ss.watched = map[string]int{} }
+        for _, k := range args[1:] { ss.watched[k] = s.versions[k] }
+        return "OK"
+    case "UNWATCH":
+        ss.watched = nil
+        return "OK"
+    }
+    if ss.multi {
+        ss.queued = append(ss.queued, args)
+        return "QUEUED"
+    }
+    return s.exec(args)
+}
+
+// exec runs a data command; s.mu is held.
+func (s *FakeServer) exec(args []string) any {
+    wrongArgs := Error("ERR wrong number of arguments for '" + strings.ToLower(args[0]) + "' command")
+    switch strings.ToUpper(args[0]) {
+    case "PING":
+        return "PONG"
+    case "SELECT":
+        if len(args) != 2 { return wrongArgs }
+        if _, err := strconv.Atoi(args[1]); err != nil { return Error("ERR value is not an integer or out of range") }
+        return "OK"
+    case "GET":
+        if len(args) != 2 { return wrongArgs }
+        if v, ok := s.strs[args[1]]; ok { return v }
+        return nil
+    case "MGET":
+        if len(args) < 2 { return wrongArgs }
+        out := make([]any, len(args)-1)
+        for i, k := range args[1:] {
+            if v, ok := s.strs[k]; ok { out[i] = v }
+        }
+        return out
+    case "SET":
+        if len(args) != 3 { return wrongArgs }
+        s.write(args[1])
+        s.strs[args[1]] = args[2]
+        return "OK"
+    case "MSET":
+        if len(args) < 3 || len(args)%2 == 0 { return wrongArgs }
+        for i := 1; i < len(args); i += 2 {
+            s.write(args[i])
+            s.strs[args[i]] = args[i+1]
+        }
+        return "OK"
+    case "DEL":
+        if len(args) < 2 { return wrongArgs }
+        var n int64
+        for _, k := range args[1:] {
+            _, str := s.strs[k]
+            _, set := s.sets[k]
+            if str || set { n++; s.write(k) }
+        }
+        return n
+    case "SADD":
+        if len(args) < 3 { return wrongArgs }
+        if _, str := s.strs[args[1]]; str { return Error("WRONGTYPE Operation against a key holding the wrong kind of value") }
+        set := s.sets[args[1]]
+        if set == nil { set = map[string]bool{}; s.sets[args[1]] = set }
+        var n int64
+        for _, m := range args[2:] {
+            if !set[m] { set[m] = true; n++ }
+        }
+        s.versions[args[1]]++
+        return n
+    case "SMEMBERS":
+        if len(args) != 2 { return wrongArgs }
+        out := []any{}
+        for m := range s.sets[args[1]] { out = append(out, m) }
+        return out
+    }
+    return Error("ERR unknown command '" + args[0] + "'")
+}
+
+// write drops any previous value of k and bumps its version.
+func (s *FakeServer) write(k string) {
+    delete(s.strs, k)
+    delete(s.sets, k)
+    s.versions[k]++
+}
+
+func writeReply(w *bufio.Writer, v any) {
+    switch v := v.(type) {
+    case nil:
+        w.WriteString("$-1\r\n")
+    case string:
+        w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+    case Error:
+        w.WriteString("-" + string(v) + "\r\n")
+    case int64:
+        w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+    case []any:
+        w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+        for _, it := range v { writeReply(w, it) }
+    }
+}
@@ -0,0 +1,134 @@
+// Package redisx is a minimal RESP2 client covering the few commands TailWhale sends to Redis.
+package redisx
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "time"
+)
+
+// Error is an error reply from the server, e.g. "WRONGTYPE ...".
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Options tunes Dial.
+type Options struct {
+    Password string
+    DB       int
+    Timeout  time.Duration // dial and per-command deadline; defaults to 5s
+}
+
+// Conn is a single connection. It is not safe for concurrent use.
+type Conn struct {
+    c       net.Conn
+    r       *bufio.Reader
+    w       *bufio.Writer
+    timeout time.Duration
+}
+
+// Dial connects to addr, authenticating and selecting the database when configured.
+func Dial(addr string, opt Options) (*Conn, error) {
+    timeout := opt.Timeout
+    if timeout == 0 { timeout = 5 * time.Second }
+    nc, err := net.DialTimeout("tcp", addr, timeout)
+    if err != nil { return nil, err }
+    c := &Conn{c: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: timeout}
+    if opt.Password != "" {
+        if _, err := c.Do("AUTH", opt.Password); err != nil { c.Close(); return nil, err }
+    }
+    if opt.DB != 0 {
+        if _, err := c.Do("SELECT", strconv.Itoa(opt.DB)); err != nil { c.Close(); return nil, err }
+    }
+    return c, nil
+}
+
+func (c *Conn) Close() error { return c.c.Close() }
+
+// Do sends one command and returns its reply: string, int64, nil, []any or, inside arrays, Error.
+// A top-level error reply is returned as an Error.
+func (c *Conn) Do(args ...string) (any, error) {
+    replies, err := c.Pipeline([][]string{args})
+    if err != nil { return nil, err }
+    if e, ok := replies[0].(Error); ok { return nil, e }
+    return replies[0], nil
+}
+
+// Pipeline sends all commands before reading their replies. Error replies are returned in place.
+func (c *Conn) Pipeline(cmds [][]string) ([]any, error) {
+    _ = c.c.SetDeadline(time.Now().Add(c.timeout))
+    for _, args := range cmds { writeCommand(c.w, args) }
+    if err := c.w.Flush(); err != nil { return nil, err }
+    out := make([]any, len(cmds))
+    for i := range cmds {
+        v, err := ReadReply(c.r)
+        if err != nil { return nil, err }
+        out[i] = v
+    }
+    return out, nil
+}
+
+func writeCommand(w *bufio.Writer, args []string) {
+    fmt.Fprintf(w, "*%d\r\n", len(args))
+    for _, a := range args { fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a) }
+}
+
+// ReadReply decodes one RESP2 value.
+func ReadReply(r *bufio.Reader) (any, error) {
+    line, err := readLine(r)
+    if err != nil { return nil, err }
+    if line == "" { return nil, errors.New("redis: empty reply") }
+    switch line[0] {
+    case '+':
+        return line[1:], nil
+    case '-':
+        return Error(line[1:]), nil
+    case ':':
+        return strconv.ParseInt(line[1:], 10, 64)
+    case '$':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil { return nil, fmt.Errorf("redis: bad bulk length %q", line) }
+        if n < 0 { return nil, nil }
+        buf := make([]byte, n+2)
+        if _, err := io.ReadFull(r, buf); err != nil { return nil, err }
+        return string(buf[:n]), nil
+    case '*':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil { return nil, fmt.Errorf("redis: bad array length %q", line) }
+        if n < 0 { return nil, nil }
+        out := make([]any, n)
+        for i := range out {
+            if out[i], err = ReadReply(r); err != nil { return nil, err }
+        }
+        return out, nil
+    }
+    return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+    line, err := r.ReadString('\n')
+    if err != nil { return "", err }
+    if len(line) < 2 || line[len(line)-2] != '\r' { return "", fmt.Errorf("redis: malformed line %q", line) }
+    return line[:len(line)-2], nil
+}
+
+// Strings converts an array reply of bulk strings, such as SMEMBERS or MGET; nil entries become "".
+func Strings(v any) ([]string, error) {
+    arr, ok := v.([]any)
+    if !ok && v != nil { return nil, fmt.Errorf("redis: expected array, got %T", v) }
+    out := make([]string, len(arr))
+    for i, it := range arr {
+        switch s := it.(type) {
+        case string:
+            out[i] = s
+        case nil:
+        default:
+            return nil, fmt.Errorf("redis: expected string, got %T", it)
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,28 @@
+package redisx
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestConnAgainstFakeServer(t *testing.T){
+    srv, err := NewFakeServer("")
+    if err != nil { t.Fatal(err) }
+    defer srv.Close()
+    c, err := Dial(srv.Addr(), Options{})
+    if err != nil { t.Fatal(err) }
+    defer c.Close()
+    if _, err := c.Do("SET", "a", "line\r\nbreak"); err != nil { t.Fatal(err) }
+    r, err := c.Do("MGET", "a", "missing")
+    if err != nil { t.Fatal(err) }
+    if got, _ := Strings(r); !reflect.DeepEqual(got, []string{"line\r\nbreak", ""}) { t.Fatalf("MGET = %q", got) }
+    if _, err := c.Do("SADD", "a", "x"); err == nil { t.Fatal("expected WRONGTYPE error") }
+
+    // A write by another client between WATCH and EXEC aborts the transaction.
+    if _, err := c.Do("WATCH", "a"); err != nil { t.Fatal(err) }
+    srv.Set("a", "other")
+    replies, err := c.Pipeline([][]string{{"MULTI"}, {"SET", "a", "mine"}, {"EXEC"}})
+    if err != nil { t.Fatal(err) }
+    if replies[2] != nil { t.Fatalf("expected aborted EXEC, got %v", replies[2]) }
+    if srv.Strings()["a"] != "other" { t.Fatal("aborted transaction was applied") }
+}
@@ -1,8 +1,11 @@
 package tailscale
 
 import (
+    "bytes"
     "context"
+    "fmt"
     "os/exec"
+    "strings"
 )
 
 // Executor abstracts command execution for testability.
@@ -14,6 +17,13 @@ type defaultExec struct{}
 
 func (defaultExec) Run(ctx context.Context, name string, args ...string) error {
     cmd := exec.CommandContext(ctx, name, args...)
-    return cmd.Run()
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    if err := cmd.Run(); err != nil {
+        // Keep the tool's own explanation instead of a bare "exit status 1".
+        if msg := strings.TrimSpace(stderr.String()); msg != "" { return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, msg) }
+        return err
+    }
+    return nil
 }
 
@@ -2,9 +2,17 @@ package tailscale
 
 import (
     "context"
+    "errors"
+    "fmt"
+    "net"
+    "strconv"
+    "strings"
+
+    "github.com/frnwtr/tailwhale/internal/mapx"
 )
 
-// Funnel provides simple on/off controls via the tailscale CLI.
+// Funnel provides simple on/off controls via the tailscale CLI. It cannot tell what is
+// exposed; LocalClient.ReconcileFunnel manages Funnel from the desired endpoints instead.
 type Funnel struct{ Exec Executor }
 
 // On enables Tailscale Funnel for the current node or specified service.
@@ -23,3 +31,249 @@ func (f Funnel) Off(ctx context.Context) error {
     return ex.Run(ctx, "tailscale", "funnel", "off")
 }
 
+
+// FunnelPorts are the only ports Funnel accepts Internet traffic on.
+var FunnelPorts = []int{443, 8443, 10000}
+
+// FunnelMount is one public endpoint, https://<node>:<Port><Path>, proxied to Backend.
+// A tcp:// Backend takes the whole port instead: its TCP stream is forwarded there.
+type FunnelMount struct {
+    Port        int    // one of FunnelPorts
+    Path        string // mount point; defaults to /; unused for TCP
+    Backend     string // e.g. http://172.18.0.5:8080 or tcp://172.18.0.6:8883
+    Passthrough bool   // TCP only: forward TLS as is instead of terminating it with the node's certificate
+}
+
+// Serve change actions.
+const (
+    ServeAdd       = "add"        // new handler
+    ServeUpdate    = "update"     // handler pointed at another backend
+    ServeRemove    = "remove"     // handler that would otherwise be public
+    ServeListen    = "listen"     // serve HTTPS on the port
+    ServeForward   = "forward"    // forward the port's TCP stream
+    ServeFunnelOn  = "funnel-on"  // open host:port to the Internet
+    ServeFunnelOff = "funnel-off" // close host:port to the Internet
+)
+
+// ServeChange is one step from the current serve config to the desired one.
+type ServeChange struct {
+    Action      string
+    HostPort    string
+    Path        string // handler actions only
+    Backend     string // new target of add, update and forward, old one of remove
+    Old         string // update and forward: the target being replaced
+    Passthrough bool   // forward only: TLS is not terminated
+}
+
+func (c ServeChange) String() string {
+    url := "https://" + c.HostPort + c.Path
+    switch c.Action {
+    case ServeAdd:
+        return "+ " + url + " -> " + c.Backend
+    case ServeUpdate:
+        return "~ " + url + " -> " + c.Backend + " (was " + c.Old + ")"
+    case ServeRemove:
+        return "- " + url + " -> " + c.Backend
+    case ServeListen:
+        return "+ serve HTTPS on " + c.HostPort
+    case ServeForward:
+        s := "+ forward " + c.HostPort + " -> " + c.Backend
+        if c.Passthrough { s += " (TLS passthrough)" }
+        if c.Old != "" { s += " (was " + c.Old + ")" }
+        return s
+    case ServeFunnelOn:
+        return "+ funnel " + c.HostPort
+    case ServeFunnelOff:
+        return "- funnel " + c.HostPort
+    }
+    return c.Action + " " + url
+}
+
+// PlanFunnel returns the changes making exactly mounts public on host (the node's MagicDNS
+// name). On every port with mounts, handlers that are not wanted are removed since Funnel
+// would publish them too; every other host:port is closed to the Internet, its handlers
+// staying reachable from the tailnet. Serve config outside these ports is left alone.
+// A TCP forward already on a port HTTP mounts need is a conflict, not overwritten: it may
+// serve something else, and only its owner knows whether it can go.
+func PlanFunnel(cur ServeConfig, host string, mounts []FunnelMount) ([]ServeChange, error) {
+    want := map[string]map[string]string{} // host:port -> path -> backend
+    forwards := map[string]FunnelMount{}   // host:port -> TCP mount
+    for _, m := range mounts {
+        if !isFunnelPort(m.Port) { return nil, fmt.Errorf("funnel: port %d is not one of %v", m.Port, FunnelPorts) }
+        hp := net.JoinHostPort(host, strconv.Itoa(m.Port))
+        if f, ok := forwards[hp]; ok { return nil, fmt.Errorf("funnel: %s wanted by both %s and %s", hp, f.Backend, m.Backend) }
+        if strings.HasPrefix(m.Backend, "tcp://") {
+            if len(want[hp]) > 0 { return nil, fmt.Errorf("funnel: %s wanted by both HTTP mounts and %s", hp, m.Backend) }
+            forwards[hp] = m
+            continue
+        }
+        path := m.Path
+        if path == "" { path = "/" }
+        if want[hp] == nil { want[hp] = map[string]string{} }
+        if b, ok := want[hp][path]; ok && b != m.Backend { return nil, fmt.Errorf("funnel: https://%s%s wanted by both %s and %s", hp, path, b, m.Backend) }
+        want[hp][path] = m.Backend
+    }
+    public := map[string]bool{}
+    for hp := range want { public[hp] = true }
+    for hp := range forwards { public[hp] = true }
+    var changes []ServeChange
+    for _, hp := range mapx.SortedKeys(public) {
+        _, port, _ := net.SplitHostPort(hp)
+        var handlers map[string]*HTTPHandler
+        if w := cur.Web[hp]; w != nil { handlers = w.Handlers }
+        h := cur.TCP[port]
+        if f, ok := forwards[hp]; ok {
+            addr, terminate := strings.TrimPrefix(f.Backend, "tcp://"), host
+            if f.Passthrough { terminate = "" }
+            if h == nil || h.HTTPS || h.HTTP || h.TCPForward != addr || h.TerminateTLS != terminate {
+                c := ServeChange{Action: ServeForward, HostPort: hp, Backend: f.Backend, Passthrough: f.Passthrough}
+                if h != nil && h.TCPForward != "" { c.Old = "tcp://" + h.TCPForward }
+                changes = append(changes, c)
+            }
+            // The forward takes the whole port: its HTTPS handlers would never be reached.
+            for _, path := range mapx.SortedKeys(handlers) {
+                changes = append(changes, ServeChange{Action: ServeRemove, HostPort: hp, Path: path, Backend: handlers[path].target()})
+            }
+            if !cur.AllowFunnel[hp] { changes = append(changes, ServeChange{Action: ServeFunnelOn, HostPort: hp}) }
+            continue
+        }
+        if h != nil && h.TCPForward != "" {
+            return nil, fmt.Errorf("funnel: port %s forwards TCP to %s, which the mounts on %s would replace; remove that forward first (tailscale serve --tcp=%s off)", port, h.TCPForward, hp, port)
+        }
+        if h == nil || !h.HTTPS || h.HTTP {
+            changes = append(changes, ServeChange{Action: ServeListen, HostPort: hp})
+        }
+        for _, path := range mapx.SortedKeys(want[hp]) {
+            backend := want[hp][path]
+            switch h := handlers[path]; {
+            case h == nil:
+                changes = append(changes, ServeChange{Action: ServeAdd, HostPort: hp, Path: path, Backend: backend})
+            case h.Proxy != backend || h.Path != "" || h.Text != "":
+                changes = append(changes, ServeChange{Action: ServeUpdate, HostPort: hp, Path: path, Backend: backend, Old: h.target()})
+            }
+        }
+        for _, path := range mapx.SortedKeys(handlers) {
+            if _, ok := want[hp][path]; !ok {
+                changes = append(changes, ServeChange{Action: ServeRemove, HostPort: hp, Path: path, Backend: handlers[path].target()})
+            }
+        }
+        if !cur.AllowFunnel[hp] { changes = append(changes, ServeChange{Action: ServeFunnelOn, HostPort: hp}) }
+    }
+    for _, hp := range mapx.SortedKeys(cur.AllowFunnel) {
+        if cur.AllowFunnel[hp] && !public[hp] { changes = append(changes, ServeChange{Action: ServeFunnelOff, HostPort: hp}) }
+    }
+    return changes, nil
+}
+
+// Apply returns a copy of sc with changes applied; sc itself is not modified.
+func (sc ServeConfig) Apply(changes []ServeChange) ServeConfig {
+    out := sc
+    out.TCP = make(map[string]*TCPPortHandler, len(sc.TCP))
+    for k, v := range sc.TCP { out.TCP[k] = v }
+    out.Web = make(map[string]*WebServerConfig, len(sc.Web))
+    for k, v := range sc.Web { out.Web[k] = v }
+    out.AllowFunnel = make(map[string]bool, len(sc.AllowFunnel))
+    for k, v := range sc.AllowFunnel { out.AllowFunnel[k] = v }
+    copied := map[string]bool{}
+    web := func(hp string) *WebServerConfig {
+        if !copied[hp] {
+            w := &WebServerConfig{Handlers: map[string]*HTTPHandler{}}
+            if cur := out.Web[hp]; cur != nil {
+                for k, v := range cur.Handlers { w.Handlers[k] = v }
+                w.other = cur.other
+            }
+            out.Web[hp], copied[hp] = w, true
+        }
+        return out.Web[hp]
+    }
+    for _, c := range changes {
+        switch c.Action {
+        case ServeListen:
+            _, port, _ := net.SplitHostPort(c.HostPort)
+            out.TCP[port] = &TCPPortHandler{HTTPS: true}
+        case ServeForward:
+            host, port, _ := net.SplitHostPort(c.HostPort)
+            h := &TCPPortHandler{TCPForward: strings.TrimPrefix(c.Backend, "tcp://"), TerminateTLS: host}
+            if c.Passthrough { h.TerminateTLS = "" }
+            out.TCP[port] = h
+        case ServeAdd, ServeUpdate:
+            w := web(c.HostPort)
+            h := &HTTPHandler{Proxy: c.Backend}
+            if cur := w.Handlers[c.Path]; cur != nil { h.other = cur.other }
+            w.Handlers[c.Path] = h
+        case ServeRemove:
+            w := web(c.HostPort)
+            delete(w.Handlers, c.Path)
+            if len(w.Handlers) == 0 { delete(out.Web, c.HostPort) }
+        case ServeFunnelOn:
+            out.AllowFunnel[c.HostPort] = true
+        case ServeFunnelOff:
+            delete(out.AllowFunnel, c.HostPort)
+        }
+    }
+    return out
+}
+
+// ReconcileFunnel makes exactly mounts public on host (see PlanFunnel) and returns the
+// changes it applied, none when the serve config was already right. The config is written
+// once, guarded by its ETag, and re-planned if someone else changed it meanwhile.
+func (c *LocalClient) ReconcileFunnel(ctx context.Context, host string, mounts []FunnelMount) ([]ServeChange, error) {
+    for attempt := 0; ; attempt++ {
+        sc, err := c.ServeConfig(ctx)
+        if err != nil { return nil, err }
+        changes, err := PlanFunnel(sc, host, mounts)
+        if err != nil || len(changes) == 0 { return nil, err }
+        err = c.SetServeConfig(ctx, sc.Apply(changes))
+        if err == nil { return changes, nil }
+        if !errors.Is(err, ErrServeConfigChanged) || attempt == 2 { return nil, err }
+    }
+}
+
+// Endpoint is one handler of the serve config.
+type Endpoint struct {
+    HostPort string // host:port; just :port for TCP forwards, which match any name
+    Path     string // empty for TCP forwards
+    Target   string // proxied URL, served file or directory, literal text, or forwarded address
+    Public   bool   // reachable from the Internet through Funnel
+}
+
+// Endpoints lists what the serve config exposes, sorted by host:port and path.
+func (sc ServeConfig) Endpoints() []Endpoint {
+    var out []Endpoint
+    for _, port := range mapx.SortedKeys(sc.TCP) {
+        if h := sc.TCP[port]; h != nil && h.TCPForward != "" {
+            public := false
+            for hp, on := range sc.AllowFunnel {
+                if _, p, _ := net.SplitHostPort(hp); on && p == port { public = true }
+            }
+            out = append(out, Endpoint{HostPort: ":" + port, Target: "tcp://" + h.TCPForward, Public: public})
+        }
+    }
+    for _, hp := range mapx.SortedKeys(sc.Web) {
+        if sc.Web[hp] == nil { continue }
+        for _, path := range mapx.SortedKeys(sc.Web[hp].Handlers) {
+            out = append(out, Endpoint{HostPort: hp, Path: path, Target: sc.Web[hp].Handlers[path].target(), Public: sc.AllowFunnel[hp]})
+        }
+    }
+    return out
+}
+
+// target describes what a handler serves.
+func (h *HTTPHandler) target() string {
+    switch {
+    case h == nil:
+        return ""
+    case h.Proxy != "":
+        return h.Proxy
+    case h.Path != "":
+        return h.Path
+    }
+    return "text " + strconv.Quote(h.Text)
+}
+
+func isFunnelPort(port int) bool {
+    for _, p := range FunnelPorts {
+        if p == port { return true }
+    }
+    return false
+}
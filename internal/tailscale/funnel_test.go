@@ -24,3 +24,84 @@ func TestFunnelOnOff(t *testing.T){
     if !strings.Contains(got, "tailscale funnel on 80") { t.Fatalf("unexpected call: %s", got) }
 }
 
+
+func TestPlanFunnelPublishesOnlyTheMounts(t *testing.T){
+    cur := ServeConfig{
+        TCP: map[string]*TCPPortHandler{"443": {HTTPS: true}, "22": {TCPForward: "127.0.0.1:22"}},
+        Web: map[string]*WebServerConfig{
+            "host1.tn.ts.net:443":  {Handlers: map[string]*HTTPHandler{"/": {Proxy: "http://10.0.0.1:80"}, "/admin": {Proxy: "http://127.0.0.1:9000"}}},
+            "host1.tn.ts.net:8443": {Handlers: map[string]*HTTPHandler{"/": {Text: "hi"}}},
+        },
+        AllowFunnel: map[string]bool{"host1.tn.ts.net:443": true, "host1.tn.ts.net:8443": true},
+    }
+    mounts := []FunnelMount{
+        {Port: 443, Path: "/", Backend: "http://10.0.0.2:80"},
+        {Port: 10000, Path: "/hooks", Backend: "http://10.0.0.3:8080"},
+    }
+    changes, err := PlanFunnel(cur, "host1.tn.ts.net", mounts)
+    if err != nil { t.Fatal(err) }
+    var got []string
+    for _, c := range changes { got = append(got, c.String()) }
+    want := []string{
+        "+ serve HTTPS on host1.tn.ts.net:10000",
+        "+ https://host1.tn.ts.net:10000/hooks -> http://10.0.0.3:8080",
+        "+ funnel host1.tn.ts.net:10000",
+        "~ https://host1.tn.ts.net:443/ -> http://10.0.0.2:80 (was http://10.0.0.1:80)",
+        "- https://host1.tn.ts.net:443/admin -> http://127.0.0.1:9000",
+        "- funnel host1.tn.ts.net:8443",
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") { t.Fatalf("plan:\n%s", strings.Join(got, "\n")) }
+
+    next := cur.Apply(changes)
+    if len(cur.Web["host1.tn.ts.net:443"].Handlers) != 2 || !cur.AllowFunnel["host1.tn.ts.net:8443"] { t.Fatal("Apply modified the current config") }
+    if again, _ := PlanFunnel(next, "host1.tn.ts.net", mounts); len(again) != 0 { t.Fatalf("not converged: %v", again) }
+    if next.Web["host1.tn.ts.net:8443"].Handlers["/"].Text != "hi" || next.TCP["22"].TCPForward == "" { t.Fatal("config outside the public ports was touched") }
+
+    if _, err := PlanFunnel(cur, "host1.tn.ts.net", []FunnelMount{{Port: 80, Backend: "http://x"}}); err == nil { t.Fatal("expected an error for a port Funnel does not serve") }
+    dup := []FunnelMount{{Port: 443, Backend: "http://a"}, {Port: 443, Path: "/", Backend: "http://b"}}
+    if _, err := PlanFunnel(cur, "host1.tn.ts.net", dup); err == nil || !strings.Contains(err.Error(), "wanted by both") { t.Fatalf("expected a conflict, got %v", err) }
+}
+
+func TestPlanFunnelForwardsTCPAndKeepsForeignForwards(t *testing.T){
+    cur := ServeConfig{
+        TCP: map[string]*TCPPortHandler{"443": {HTTPS: true}, "8443": {TCPForward: "127.0.0.1:2222"}},
+        Web: map[string]*WebServerConfig{"host1.tn.ts.net:443": {Handlers: map[string]*HTTPHandler{"/": {Proxy: "http://10.0.0.1:80"}}}},
+    }
+    mounts := []FunnelMount{{Port: 443, Backend: "tcp://10.0.0.3:8883"}, {Port: 10000, Backend: "tcp://10.0.0.4:6697", Passthrough: true}}
+    changes, err := PlanFunnel(cur, "host1.tn.ts.net", mounts)
+    if err != nil { t.Fatal(err) }
+    var got []string
+    for _, c := range changes { got = append(got, c.String()) }
+    want := []string{
+        "+ forward host1.tn.ts.net:10000 -> tcp://10.0.0.4:6697 (TLS passthrough)",
+        "+ funnel host1.tn.ts.net:10000",
+        "+ forward host1.tn.ts.net:443 -> tcp://10.0.0.3:8883",
+        "- https://host1.tn.ts.net:443/ -> http://10.0.0.1:80",
+        "+ funnel host1.tn.ts.net:443",
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") { t.Fatalf("plan:\n%s", strings.Join(got, "\n")) }
+    next := cur.Apply(changes)
+    if h := next.TCP["443"]; h.TCPForward != "10.0.0.3:8883" || h.TerminateTLS != "host1.tn.ts.net" || h.HTTPS { t.Fatalf("443 = %+v", h) }
+    if h := next.TCP["10000"]; h.TCPForward != "10.0.0.4:6697" || h.TerminateTLS != "" { t.Fatalf("10000 = %+v", h) }
+    if again, _ := PlanFunnel(next, "host1.tn.ts.net", mounts); len(again) != 0 { t.Fatalf("not converged: %v", again) }
+
+    _, err = PlanFunnel(cur, "host1.tn.ts.net", []FunnelMount{{Port: 8443, Backend: "http://10.0.0.2:80"}})
+    if err == nil || !strings.Contains(err.Error(), "forwards TCP to 127.0.0.1:2222") { t.Fatalf("expected a conflict with the TCP forward, got %v", err) }
+    mixed := []FunnelMount{{Port: 443, Backend: "http://10.0.0.2:80"}, {Port: 443, Backend: "tcp://10.0.0.3:8883"}}
+    if _, err := PlanFunnel(cur, "host1.tn.ts.net", mixed); err == nil || !strings.Contains(err.Error(), "wanted by both") { t.Fatalf("expected a conflict, got %v", err) }
+}
+
+func TestReconcileFunnelThroughLocalAPI(t *testing.T){
+    f := &fakeTailscaled{serve: []byte(`{"AllowFunnel":{"host1.tn.ts.net:8443":true},"Services":{"svc:x":{}}}`)}
+    c := startTailscaled(t, f)
+    ctx := context.Background()
+    mounts := []FunnelMount{{Port: 443, Backend: "http://10.0.0.2:80"}}
+    changes, err := c.ReconcileFunnel(ctx, "host1.tn.ts.net", mounts)
+    if err != nil || len(changes) != 4 { t.Fatalf("changes = %v, %v", changes, err) }
+    sc, err := c.ServeConfig(ctx)
+    if err != nil { t.Fatal(err) }
+    eps := sc.Endpoints()
+    if len(eps) != 1 || eps[0] != (Endpoint{HostPort: "host1.tn.ts.net:443", Path: "/", Target: "http://10.0.0.2:80", Public: true}) || sc.AllowFunnel["host1.tn.ts.net:8443"] { t.Fatalf("serve config = %s", f.serve) }
+    if !strings.Contains(string(f.serve), `"Services":{"svc:x":{}}`) { t.Fatalf("unknown fields dropped: %s", f.serve) }
+    if changes, err := c.ReconcileFunnel(ctx, "host1.tn.ts.net", mounts); err != nil || len(changes) != 0 || f.etag != 1 { t.Fatalf("second reconcile wrote again: %v %v (%d writes)", changes, err, f.etag) }
+}
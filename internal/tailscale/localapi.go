@@ -0,0 +1,261 @@
+package tailscale
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "reflect"
+    "strings"
+    "sync"
+)
+
+// DefaultSocket is where tailscaled serves its LocalAPI on Linux.
+const DefaultSocket = "/var/run/tailscale/tailscaled.sock"
+
+// localAPIHost is the Host tailscaled expects on LocalAPI requests.
+const localAPIHost = "local-tailscaled.sock"
+
+// LocalClient talks to tailscaled's LocalAPI over its unix socket, so the tailscale CLI
+// is not needed and failures carry tailscaled's own error message. Requests are bounded
+// by the caller's context only: issuing a certificate can take minutes.
+type LocalClient struct {
+    Socket string // defaults to DefaultSocket
+
+    once sync.Once
+    hc   *http.Client
+}
+
+// APIError is a non-2xx LocalAPI response.
+type APIError struct {
+    Method, Path string
+    Status       int
+    Message      string
+}
+
+func (e *APIError) Error() string {
+    return fmt.Sprintf("tailscaled: %s %s: %s (%d)", e.Method, e.Path, e.Message, e.Status)
+}
+
+func (c *LocalClient) client() *http.Client {
+    c.once.Do(func(){
+        socket := c.Socket
+        if socket == "" { socket = DefaultSocket }
+        c.hc = &http.Client{Transport: &http.Transport{
+            DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+                return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+            },
+        }}
+    })
+    return c.hc
+}
+
+// do sends a LocalAPI request and returns the response when its status is 2xx.
+func (c *LocalClient) do(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, []byte, error) {
+    var r io.Reader
+    if body != nil { r = bytes.NewReader(body) }
+    req, err := http.NewRequestWithContext(ctx, method, "http://"+localAPIHost+path, r)
+    if err != nil { return nil, nil, err }
+    for k, v := range header { req.Header[k] = v }
+    res, err := c.client().Do(req)
+    if err != nil { return nil, nil, fmt.Errorf("tailscaled: %w", err) }
+    defer res.Body.Close()
+    b, err := io.ReadAll(res.Body)
+    if err != nil { return nil, nil, fmt.Errorf("tailscaled: %s %s: %w", method, path, err) }
+    if res.StatusCode/100 != 2 {
+        return nil, nil, &APIError{Method: method, Path: path, Status: res.StatusCode, Message: errorMessage(b, res.Status)}
+    }
+    return res, b, nil
+}
+
+// errorMessage extracts the message of a LocalAPI error body ({"error": ...} or plain text).
+func errorMessage(b []byte, status string) string {
+    var e struct{ Error string `json:"error"` }
+    if json.Unmarshal(b, &e) == nil && e.Error != "" { return e.Error }
+    if msg := strings.TrimSpace(string(b)); msg != "" { return msg }
+    return status
+}
+
+// CertPair issues (or returns the cached) certificate for domain as PEM.
+func (c *LocalClient) CertPair(ctx context.Context, domain string) (certPEM, keyPEM []byte, err error) {
+    _, b, err := c.do(ctx, http.MethodGet, "/localapi/v0/cert/"+url.PathEscape(domain)+"?type=pair", nil, nil)
+    if err != nil { return nil, nil, err }
+    // With type=pair the body holds the private key block followed by the certificate chain.
+    for rest := b; ; {
+        var block *pem.Block
+        block, rest = pem.Decode(rest)
+        if block == nil { break }
+        switch {
+        case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+            keyPEM = append(keyPEM, pem.EncodeToMemory(block)...)
+        case block.Type == "CERTIFICATE":
+            certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+        }
+    }
+    if len(certPEM) == 0 || len(keyPEM) == 0 { return nil, nil, fmt.Errorf("tailscaled: unexpected cert response for %s", domain) }
+    return certPEM, keyPEM, nil
+}
+
+// Status returns the node's status.
+func (c *LocalClient) Status(ctx context.Context) (Status, error) {
+    _, b, err := c.do(ctx, http.MethodGet, "/localapi/v0/status", nil, nil)
+    if err != nil { return Status{}, err }
+    return ParseStatus(bytes.NewReader(b))
+}
+
+// ServeConfig returns the current serve/funnel configuration; its ETag guards SetServeConfig.
+func (c *LocalClient) ServeConfig(ctx context.Context) (ServeConfig, error) {
+    res, b, err := c.do(ctx, http.MethodGet, "/localapi/v0/serve-config", nil, nil)
+    if err != nil { return ServeConfig{}, err }
+    var sc ServeConfig
+    if len(bytes.TrimSpace(b)) > 0 && string(bytes.TrimSpace(b)) != "null" {
+        if err := json.Unmarshal(b, &sc); err != nil { return sc, fmt.Errorf("tailscaled: serve config: %w", err) }
+    }
+    sc.ETag = res.Header.Get("Etag")
+    return sc, nil
+}
+
+// ErrServeConfigChanged is returned by SetServeConfig when the config changed since it was read.
+var ErrServeConfigChanged = errors.New("tailscaled: serve config changed concurrently")
+
+// SetServeConfig replaces the serve/funnel configuration. When sc carries the ETag it was
+// read with, the write fails with ErrServeConfigChanged if someone else changed it meanwhile.
+func (c *LocalClient) SetServeConfig(ctx context.Context, sc ServeConfig) error {
+    b, err := json.Marshal(sc)
+    if err != nil { return err }
+    h := http.Header{"Content-Type": {"application/json"}}
+    if sc.ETag != "" { h.Set("If-Match", sc.ETag) }
+    _, _, err = c.do(ctx, http.MethodPost, "/localapi/v0/serve-config", b, h)
+    var ae *APIError
+    if errors.As(err, &ae) && ae.Status == http.StatusPreconditionFailed { return ErrServeConfigChanged }
+    return err
+}
+
+// SetFunnel allows or stops Funnel traffic to hostPort (e.g. host.tailnet.ts.net:443).
+// The port must already be served; the rest of the serve config is kept.
+func (c *LocalClient) SetFunnel(ctx context.Context, hostPort string, on bool) error {
+    for attempt := 0; ; attempt++ {
+        sc, err := c.ServeConfig(ctx)
+        if err != nil { return err }
+        if sc.AllowFunnel[hostPort] == on { return nil }
+        if on {
+            if sc.AllowFunnel == nil { sc.AllowFunnel = map[string]bool{} }
+            sc.AllowFunnel[hostPort] = true
+        } else {
+            delete(sc.AllowFunnel, hostPort)
+        }
+        err = c.SetServeConfig(ctx, sc)
+        if !errors.Is(err, ErrServeConfigChanged) || attempt == 2 { return err }
+    }
+}
+
+// ServeConfig is tailscaled's serve/funnel configuration (ipn.ServeConfig). Fields
+// TailWhale does not model, here and in the handlers, are kept as they are when the
+// config is written back.
+type ServeConfig struct {
+    TCP         map[string]*TCPPortHandler  `json:"TCP,omitempty"`         // by port
+    Web         map[string]*WebServerConfig `json:"Web,omitempty"`         // by host:port
+    AllowFunnel map[string]bool             `json:"AllowFunnel,omitempty"` // by host:port
+    ETag        string                      `json:"-"`
+
+    other map[string]json.RawMessage
+}
+
+// TCPPortHandler says how tailscaled handles connections to a port.
+type TCPPortHandler struct {
+    HTTPS        bool   `json:"HTTPS,omitempty"`
+    HTTP         bool   `json:"HTTP,omitempty"`
+    TCPForward   string `json:"TCPForward,omitempty"`
+    TerminateTLS string `json:"TerminateTLS,omitempty"`
+
+    other map[string]json.RawMessage
+}
+
+// WebServerConfig maps mount points of an HTTPS host:port to handlers.
+type WebServerConfig struct {
+    Handlers map[string]*HTTPHandler `json:"Handlers,omitempty"`
+
+    other map[string]json.RawMessage
+}
+
+// HTTPHandler serves one mount point: Proxy is a backend URL, Path a file or directory, Text a literal body.
+type HTTPHandler struct {
+    Proxy string `json:"Proxy,omitempty"`
+    Path  string `json:"Path,omitempty"`
+    Text  string `json:"Text,omitempty"`
+
+    other map[string]json.RawMessage
+}
+
+// The plain types decode and encode the modelled fields only.
+type (
+    serveConfigFields ServeConfig
+    tcpPortHandler    TCPPortHandler
+    webServerConfig   WebServerConfig
+    httpHandler       HTTPHandler
+)
+
+func (sc *ServeConfig) UnmarshalJSON(b []byte) error {
+    other, err := decodeKeeping(b, (*serveConfigFields)(sc))
+    sc.other = other
+    return err
+}
+
+func (sc ServeConfig) MarshalJSON() ([]byte, error) { return encodeKeeping(serveConfigFields(sc), sc.other) }
+
+func (h *TCPPortHandler) UnmarshalJSON(b []byte) error {
+    other, err := decodeKeeping(b, (*tcpPortHandler)(h))
+    h.other = other
+    return err
+}
+
+func (h TCPPortHandler) MarshalJSON() ([]byte, error) { return encodeKeeping(tcpPortHandler(h), h.other) }
+
+func (w *WebServerConfig) UnmarshalJSON(b []byte) error {
+    other, err := decodeKeeping(b, (*webServerConfig)(w))
+    w.other = other
+    return err
+}
+
+func (w WebServerConfig) MarshalJSON() ([]byte, error) { return encodeKeeping(webServerConfig(w), w.other) }
+
+func (h *HTTPHandler) UnmarshalJSON(b []byte) error {
+    other, err := decodeKeeping(b, (*httpHandler)(h))
+    h.other = other
+    return err
+}
+
+func (h HTTPHandler) MarshalJSON() ([]byte, error) { return encodeKeeping(httpHandler(h), h.other) }
+
+// decodeKeeping decodes the JSON object b into the struct v points to and returns the
+// members v has no field for.
+func decodeKeeping(b []byte, v any) (map[string]json.RawMessage, error) {
+    if err := json.Unmarshal(b, v); err != nil { return nil, err }
+    var all map[string]json.RawMessage
+    if err := json.Unmarshal(b, &all); err != nil { return nil, err }
+    t := reflect.TypeOf(v).Elem()
+    for i := 0; i < t.NumField(); i++ {
+        name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+        if name != "" && name != "-" { delete(all, name) }
+    }
+    if len(all) == 0 { return nil, nil }
+    return all, nil
+}
+
+// encodeKeeping encodes v with the members decodeKeeping set aside added back.
+func encodeKeeping(v any, other map[string]json.RawMessage) ([]byte, error) {
+    b, err := json.Marshal(v)
+    if err != nil || len(other) == 0 { return b, err }
+    var all map[string]json.RawMessage
+    if err := json.Unmarshal(b, &all); err != nil { return nil, err }
+    for k, raw := range other {
+        if _, ok := all[k]; !ok { all[k] = raw }
+    }
+    return json.Marshal(all)
+}
@@ -0,0 +1,145 @@
+package tailscale
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "io"
+    "math/big"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// fakeTailscaled serves a small LocalAPI on a unix socket.
+type fakeTailscaled struct {
+    mu     sync.Mutex
+    certs  int
+    serve  []byte
+    etag   int
+    status string
+}
+
+func (f *fakeTailscaled) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Host != localAPIHost { http.Error(w, "invalid localapi request", http.StatusForbidden); return }
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    switch {
+    case strings.HasPrefix(r.URL.Path, "/localapi/v0/cert/"):
+        domain := strings.TrimPrefix(r.URL.Path, "/localapi/v0/cert/")
+        if r.URL.Query().Get("type") != "pair" { http.Error(w, "want type=pair", http.StatusBadRequest); return }
+        if !strings.HasSuffix(domain, ".ts.net") {
+            w.WriteHeader(http.StatusInternalServerError)
+            json.NewEncoder(w).Encode(map[string]string{"error": "invalid domain " + domain + "; must be one of [host1.tn.ts.net]"})
+            return
+        }
+        f.certs++
+        w.Write(testPair(domain))
+    case r.URL.Path == "/localapi/v0/status":
+        io.WriteString(w, f.status)
+    case r.URL.Path == "/localapi/v0/serve-config" && r.Method == http.MethodGet:
+        w.Header().Set("Etag", etag(f.etag))
+        w.Write(f.serve)
+    case r.URL.Path == "/localapi/v0/serve-config" && r.Method == http.MethodPost:
+        if m := r.Header.Get("If-Match"); m != "" && m != etag(f.etag) { http.Error(w, "etag mismatch", http.StatusPreconditionFailed); return }
+        f.serve, _ = io.ReadAll(r.Body)
+        f.etag++
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func etag(n int) string { return `"` + string(rune('a'+n)) + `"` }
+
+// testPair returns a key and certificate for domain in the type=pair layout.
+func testPair(domain string) []byte {
+    key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    tpl := &x509.Certificate{
+        SerialNumber: big.NewInt(1),
+        Subject:      pkix.Name{CommonName: domain},
+        DNSNames:     []string{domain},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+    }
+    der, _ := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+    kder, _ := x509.MarshalECPrivateKey(key)
+    out := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+    return append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+func startTailscaled(t *testing.T, f *fakeTailscaled) *LocalClient {
+    t.Helper()
+    dir, err := os.MkdirTemp("", "ts") // short path: unix socket names are limited to ~100 bytes
+    if err != nil { t.Fatal(err) }
+    t.Cleanup(func(){ os.RemoveAll(dir) })
+    sock := filepath.Join(dir, "tailscaled.sock")
+    l, err := net.Listen("unix", sock)
+    if err != nil { t.Fatal(err) }
+    srv := &http.Server{Handler: f}
+    go srv.Serve(l)
+    t.Cleanup(func(){ srv.Close() })
+    return &LocalClient{Socket: sock}
+}
+
+func TestLocalManagerIssuesCertificatesThroughLocalAPI(t *testing.T){
+    f := &fakeTailscaled{}
+    dir := t.TempDir()
+    m := &LocalManager{Client: startTailscaled(t, f), CertDir: dir}
+    c, err := m.Ensure("web.host1.tn.ts.net")
+    if err != nil { t.Fatal(err) }
+    if time.Until(c.Expiry) < 80*24*time.Hour { t.Fatalf("unexpected expiry %v", c.Expiry) }
+    if fi, err := os.Stat(c.KeyPath); err != nil || fi.Mode().Perm() != 0o600 { t.Fatalf("key not written privately: %v %v", fi, err) }
+    key, _ := os.ReadFile(c.KeyPath)
+    if !strings.Contains(string(key), "PRIVATE KEY") || strings.Contains(string(key), "CERTIFICATE") { t.Fatalf("unexpected key file:\n%s", key) }
+    if _, err := m.Ensure("web.host1.tn.ts.net"); err != nil || f.certs != 1 { t.Fatalf("valid certificate reissued: %d calls, %v", f.certs, err) }
+    m.MinRemain = 100 * 24 * time.Hour // the fake issues 90-day certificates
+    if _, err := m.Ensure("web.host1.tn.ts.net"); err != nil || f.certs != 2 { t.Fatalf("expiring certificate not reissued: %d calls, %v", f.certs, err) }
+
+    _, err = m.Ensure("web.example.com")
+    var ae *APIError
+    if !errors.As(err, &ae) || !strings.Contains(err.Error(), "invalid domain web.example.com") { t.Fatalf("expected tailscaled's message, got %v", err) }
+}
+
+func TestLocalClientStatusAndServeConfig(t *testing.T){
+    f := &fakeTailscaled{
+        status: `{"MagicDNSEnabled": true}`,
+        serve:  []byte(`{"TCP":{"443":{"HTTPS":true}},"Web":{"host1.tn.ts.net:443":{"Handlers":{"/":{"Proxy":"http://127.0.0.1:8080"}}}},"Services":{"svc:x":{}}}`),
+    }
+    c := startTailscaled(t, f)
+    ctx := context.Background()
+    st, err := c.Status(ctx)
+    if err != nil || !st.MagicDNSEnabled { t.Fatalf("status = %+v, %v", st, err) }
+
+    if err := c.SetFunnel(ctx, "host1.tn.ts.net:443", true); err != nil { t.Fatal(err) }
+    sc, err := c.ServeConfig(ctx)
+    if err != nil { t.Fatal(err) }
+    if !sc.AllowFunnel["host1.tn.ts.net:443"] || !sc.TCP["443"].HTTPS || sc.Web["host1.tn.ts.net:443"].Handlers["/"].Proxy != "http://127.0.0.1:8080" { t.Fatalf("serve config = %s", f.serve) }
+    if !strings.Contains(string(f.serve), `"Services":{"svc:x":{}}`) { t.Fatalf("unknown fields dropped: %s", f.serve) }
+
+    stale := sc
+    if err := c.SetFunnel(ctx, "host1.tn.ts.net:443", false); err != nil { t.Fatal(err) }
+    if err := c.SetServeConfig(ctx, stale); !errors.Is(err, ErrServeConfigChanged) { t.Fatalf("expected ErrServeConfigChanged, got %v", err) }
+}
+
+func TestServeConfigKeepsUnknownHandlerFields(t *testing.T){
+    in := `{"TCP":{"443":{"HTTPS":true,"ProxyProtocol":1}},"Web":{"h:443":{"Handlers":{"/":{"Proxy":"http://a","AcceptAppCaps":["x"]}},"Extra":true}}}`
+    var sc ServeConfig
+    if err := json.Unmarshal([]byte(in), &sc); err != nil { t.Fatal(err) }
+    sc = sc.Apply([]ServeChange{{Action: ServeUpdate, HostPort: "h:443", Path: "/", Backend: "http://b"}})
+    b, err := json.Marshal(sc)
+    if err != nil { t.Fatal(err) }
+    for _, want := range []string{`"ProxyProtocol":1`, `"AcceptAppCaps":["x"]`, `"Extra":true`, `"Proxy":"http://b"`} {
+        if !strings.Contains(string(b), want) { t.Fatalf("%s missing from %s", want, b) }
+    }
+}
@@ -0,0 +1,50 @@
+package tailscale
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+)
+
+// LocalManager issues certificates through tailscaled's LocalAPI and stores them in CertDir,
+// the same layout as ShellManager, without needing the tailscale CLI.
+type LocalManager struct {
+    Client  *LocalClient
+    CertDir string
+    // Minimum remaining validity; certificates expiring sooner are reissued.
+    MinRemain time.Duration
+}
+
+func (m *LocalManager) Ensure(host string) (Cert, error) { return m.ensure(host, m.MinRemain) }
+
+// Renew reissues the certificate when it expires within MinRemain (a day when unset).
+// tailscaled itself serves a cached certificate until it is close to expiry.
+func (m *LocalManager) Renew(host string) (Cert, error) {
+    remain := m.MinRemain
+    if remain == 0 { remain = 24 * time.Hour }
+    return m.ensure(host, remain)
+}
+
+func (m *LocalManager) ensure(host string, minRemain time.Duration) (Cert, error) {
+    c := Cert{
+        Host:    host,
+        Path:    filepath.Join(m.CertDir, host+".crt"),
+        KeyPath: filepath.Join(m.CertDir, host+".key"),
+    }
+    if exp, ok := readCertExpiry(c.Path); ok && fileExists(c.KeyPath) == nil {
+        c.Expiry = exp
+        if minRemain == 0 || time.Until(exp) > minRemain { return c, nil }
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+    defer cancel()
+    certPEM, keyPEM, err := m.Client.CertPair(ctx, host)
+    if err != nil { return c, err }
+    if err := os.MkdirAll(m.CertDir, 0o755); err != nil { return c, err }
+    if err := fsx.WriteFileAtomic(c.KeyPath, keyPEM, 0o600); err != nil { return c, err }
+    if err := fsx.WriteFileAtomic(c.Path, certPEM, 0o644); err != nil { return c, err }
+    if exp, ok := readCertExpiry(c.Path); ok { c.Expiry = exp }
+    return c, nil
+}
@@ -3,11 +3,31 @@ package tailscale
 import (
     "encoding/json"
     "io"
+    "strings"
 )
 
 // Status contains a minimal subset of tailscale status --json we care about.
 type Status struct {
-    MagicDNSEnabled bool `json:"MagicDNSEnabled"`
+    MagicDNSEnabled bool           `json:"MagicDNSEnabled"`
+    MagicDNSSuffix  string         `json:"MagicDNSSuffix"` // e.g. tn.ts.net
+    Self            *PeerStatus    `json:"Self"`
+    CurrentTailnet  *TailnetStatus `json:"CurrentTailnet"`
+    CertDomains     []string       `json:"CertDomains"` // names tailscaled can issue certificates for
+    TailscaleIPs    []string       `json:"TailscaleIPs"`
+}
+
+// PeerStatus describes a node; Self is the local one.
+type PeerStatus struct {
+    HostName     string   `json:"HostName"` // the machine's own hostname
+    DNSName      string   `json:"DNSName"`  // MagicDNS name, e.g. host1.tn.ts.net. (trailing dot)
+    TailscaleIPs []string `json:"TailscaleIPs"`
+}
+
+// TailnetStatus describes the tailnet the node is logged into.
+type TailnetStatus struct {
+    Name            string `json:"Name"`
+    MagicDNSSuffix  string `json:"MagicDNSSuffix"`
+    MagicDNSEnabled bool   `json:"MagicDNSEnabled"`
 }
 
 // ParseStatus parses `tailscale status --json` output (or a subset) into Status.
@@ -15,6 +35,34 @@ func ParseStatus(r io.Reader) (Status, error) {
     var s Status
     dec := json.NewDecoder(r)
     err := dec.Decode(&s)
+    if t := s.CurrentTailnet; t != nil {
+        if s.MagicDNSSuffix == "" { s.MagicDNSSuffix = t.MagicDNSSuffix }
+        s.MagicDNSEnabled = s.MagicDNSEnabled || t.MagicDNSEnabled
+    }
+    if len(s.TailscaleIPs) == 0 && s.Self != nil { s.TailscaleIPs = s.Self.TailscaleIPs }
     return s, err
 }
 
+// Identity returns the node's names as TailWhale uses them, <host>.<tailnet>.ts.net: host is
+// the first label of the MagicDNS name and tailnet the MagicDNS suffix without .ts.net.
+// Either is empty when the status does not tell (e.g. the node is logged out).
+func (s Status) Identity() (host, tailnet string) {
+    suffix := strings.TrimSuffix(strings.ToLower(s.MagicDNSSuffix), ".")
+    tailnet = strings.TrimSuffix(suffix, ".ts.net")
+    if tailnet == suffix { tailnet = "" } // not a ts.net name (e.g. a custom control server)
+    if s.Self != nil {
+        name := strings.TrimSuffix(strings.ToLower(s.Self.DNSName), ".")
+        host, _, _ = strings.Cut(name, ".")
+        if host == "" { host = strings.ToLower(s.Self.HostName) }
+    }
+    return host, tailnet
+}
+
+// CanIssue reports whether tailscaled can issue a certificate for domain.
+func (s Status) CanIssue(domain string) bool {
+    domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+    for _, d := range s.CertDomains {
+        if strings.EqualFold(strings.TrimSuffix(d, "."), domain) { return true }
+    }
+    return false
+}
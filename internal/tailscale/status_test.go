@@ -12,3 +12,21 @@ func TestParseStatus(t *testing.T){
     if !s.MagicDNSEnabled { t.Fatal("expected MagicDNSEnabled true") }
 }
 
+
+func TestParseStatusIdentity(t *testing.T){
+    json := `{
+        "TailscaleIPs": ["100.101.102.103", "fd7a:115c:a1e0::1"],
+        "Self": {"HostName": "Build-Box", "DNSName": "host1.tn.ts.net.", "TailscaleIPs": ["100.101.102.103"]},
+        "MagicDNSSuffix": "tn.ts.net",
+        "CurrentTailnet": {"Name": "user@example.com", "MagicDNSSuffix": "tn.ts.net", "MagicDNSEnabled": true},
+        "CertDomains": ["host1.tn.ts.net"]
+    }`
+    s, err := ParseStatus(strings.NewReader(json))
+    if err != nil { t.Fatal(err) }
+    if host, tailnet := s.Identity(); host != "host1" || tailnet != "tn" { t.Fatalf("identity = %q %q", host, tailnet) }
+    if !s.MagicDNSEnabled || s.CurrentTailnet.Name != "user@example.com" || len(s.TailscaleIPs) != 2 { t.Fatalf("unexpected status: %+v", s) }
+    if !s.CanIssue("HOST1.tn.ts.net.") || s.CanIssue("app.host1.tn.ts.net") { t.Fatal("CanIssue does not follow CertDomains") }
+
+    s, _ = ParseStatus(strings.NewReader(`{"Self": {"HostName": "box", "DNSName": ""}}`))
+    if host, tailnet := s.Identity(); host != "box" || tailnet != "" { t.Fatalf("logged-out identity = %q %q", host, tailnet) }
+}
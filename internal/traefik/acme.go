@@ -0,0 +1,121 @@
+package traefik
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "strings"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+    "github.com/frnwtr/tailwhale/internal/mapx"
+)
+
+// DefaultACMEResolver is the resolver name certificates are exported under.
+const DefaultACMEResolver = "tailwhale"
+
+// ACMEStore exports certificates into a Traefik acme.json file, for instances that
+// only read certificates from a resolver store. Other resolvers, the account and
+// certificates for domains outside ts.net are kept as they are; the resolver's ts.net
+// certificates are TailWhale's and are removed once their host is no longer exported.
+// Traefik reads acme.json only at startup, so it must be restarted to pick up changes.
+type ACMEStore struct {
+    Path     string
+    Resolver string // defaults to DefaultACMEResolver
+    // Skipped, when set, is told about hosts whose certificate files do not exist, such as
+    // placeholder paths for certificates not issued yet. They are left out rather than failing the export.
+    Skipped func(host string, err error)
+}
+
+// acmeCert mirrors Traefik's stored certificate: base64 PEM per domain.
+type acmeCert struct {
+    Domain      acmeDomain `json:"domain"`
+    Certificate string     `json:"certificate"`
+    Key         string     `json:"key"`
+    Store       string     `json:"Store"`
+}
+
+type acmeDomain struct {
+    Main string   `json:"main"`
+    SANs []string `json:"sans,omitempty"`
+}
+
+// Write renders certs into the store and writes it atomically with 0600 permissions.
+// The file is left untouched when nothing changed. A host whose certificate cannot be
+// read keeps its previous entry; the others are still written and the error is returned,
+// unless the files do not exist, which is passed to Skipped.
+func (s ACMEStore) Write(certs TLSConfig) error {
+    resolver := s.Resolver
+    if resolver == "" { resolver = DefaultACMEResolver }
+    existing, err := os.ReadFile(s.Path)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) { return err }
+    data, failed, err := renderACME(existing, resolver, certs)
+    if err != nil { return err }
+    if !bytes.Equal(existing, data) {
+        if err := fsx.WriteFileAtomic(s.Path, data, 0o600); err != nil { return err }
+    }
+    var errs []error
+    for _, host := range mapx.SortedKeys(failed) {
+        switch err := failed[host]; {
+        case !errors.Is(err, fs.ErrNotExist):
+            errs = append(errs, fmt.Errorf("acme store: %s: %w", host, err))
+        case s.Skipped != nil:
+            s.Skipped(host, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// renderACME replaces the resolver's certificates with the hosts in certs, keeping foreign
+// domains. Hosts whose files cannot be read keep their old entry and are returned in failed.
+func renderACME(existing []byte, resolver string, certs TLSConfig) (data []byte, failed map[string]error, err error) {
+    doc := map[string]map[string]json.RawMessage{}
+    if len(bytes.TrimSpace(existing)) > 0 {
+        if err := json.Unmarshal(existing, &doc); err != nil { return nil, nil, fmt.Errorf("acme store: %w", err) }
+    }
+    res := doc[resolver]
+    if res == nil { res = map[string]json.RawMessage{} }
+    var kept []acmeCert
+    if raw, ok := res["Certificates"]; ok && string(raw) != "null" {
+        if err := json.Unmarshal(raw, &kept); err != nil { return nil, nil, fmt.Errorf("acme store: %s certificates: %w", resolver, err) }
+    }
+    previous := map[string]acmeCert{}
+    out := []acmeCert{}
+    for _, c := range kept {
+        if strings.HasSuffix(c.Domain.Main, ".ts.net") { previous[c.Domain.Main] = c; continue }
+        out = append(out, c)
+    }
+    failed = map[string]error{}
+    for _, host := range mapx.SortedKeys(certs) {
+        c := certs[host]
+        cert, err := os.ReadFile(c.CertFile)
+        var key []byte
+        if err == nil { key, err = os.ReadFile(c.KeyFile) }
+        if err != nil {
+            failed[host] = err
+            if old, ok := previous[host]; ok { out = append(out, old) }
+            continue
+        }
+        store := "default"
+        if len(c.Stores) > 0 { store = c.Stores[0] }
+        out = append(out, acmeCert{
+            Domain:      acmeDomain{Main: host},
+            Certificate: base64.StdEncoding.EncodeToString(cert),
+            Key:         base64.StdEncoding.EncodeToString(key),
+            Store:       store,
+        })
+    }
+    sort.SliceStable(out, func(i, j int) bool { return out[i].Domain.Main < out[j].Domain.Main })
+    raw, err := json.Marshal(out)
+    if err != nil { return nil, nil, err }
+    res["Certificates"] = raw
+    if _, ok := res["Account"]; !ok { res["Account"] = json.RawMessage("null") }
+    doc[resolver] = res
+    if data, err = json.MarshalIndent(doc, "", "  "); err != nil { return nil, nil, err }
+    return append(data, '\n'), failed, nil
+}
+
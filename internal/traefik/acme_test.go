@@ -0,0 +1,54 @@
+package traefik
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestACMEStoreWritesResolverCertificates(t *testing.T){
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "web.crt"), []byte(testCertPEM), 0o644); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(filepath.Join(dir, "web.key"), []byte(testKeyPEM), 0o600); err != nil { t.Fatal(err) }
+    path := filepath.Join(dir, "acme.json")
+    existing := `{"le":{"Account":{"Email":"ops@example.com"},"Certificates":[]},
+        "tailwhale":{"Account":null,"Certificates":[
+            {"domain":{"main":"legacy.example.com"},"certificate":"Zm9v","key":"YmFy","Store":"default"},
+            {"domain":{"main":"gone.tn.ts.net"},"certificate":"b2xk","key":"b2xk","Store":"default"},
+            {"domain":{"main":"db.tn.ts.net"},"certificate":"b2xk","key":"b2xk","Store":"default"},
+            {"domain":{"main":"web.tn.ts.net"},"certificate":"b2xk","key":"b2xk","Store":"default"}]}}`
+    if err := os.WriteFile(path, []byte(existing), 0o600); err != nil { t.Fatal(err) }
+
+    var skipped []string
+    s := ACMEStore{Path: path, Skipped: func(host string, _ error){ skipped = append(skipped, host) }}
+    certs := TLSConfig{
+        "web.tn.ts.net": {CertFile: filepath.Join(dir, "web.crt"), KeyFile: filepath.Join(dir, "web.key")},
+        "db.tn.ts.net":  {CertFile: dir, KeyFile: filepath.Join(dir, "web.key")}, // a directory: unreadable
+        "new.tn.ts.net": {CertFile: filepath.Join(dir, "new.crt"), KeyFile: filepath.Join(dir, "new.key")},
+    }
+    err := s.Write(certs)
+    if err == nil || !strings.Contains(err.Error(), "db.tn.ts.net") || strings.Contains(err.Error(), "new.tn.ts.net") { t.Fatalf("expected only the unreadable db certificate to be reported, got %v", err) }
+    if len(skipped) != 1 || skipped[0] != "new.tn.ts.net" { t.Fatalf("expected the not yet issued certificate to be skipped, got %v", skipped) }
+
+    b, _ := os.ReadFile(path)
+    var doc map[string]struct {
+        Account      map[string]string
+        Certificates []acmeCert
+    }
+    if err := json.Unmarshal(b, &doc); err != nil { t.Fatalf("invalid acme.json: %v\n%s", err, b) }
+    if doc["le"].Account["Email"] != "ops@example.com" { t.Fatalf("other resolver not preserved: %s", b) }
+    got := doc["tailwhale"].Certificates
+    // gone is no longer exported; db keeps its previous entry while its files are unreadable; new has none yet.
+    if len(got) != 3 || got[0].Domain.Main != "db.tn.ts.net" || got[1].Domain.Main != "legacy.example.com" || got[2].Domain.Main != "web.tn.ts.net" { t.Fatalf("unexpected certificates: %+v", got) }
+    if key, _ := base64.StdEncoding.DecodeString(got[2].Key); string(key) != testKeyPEM { t.Fatalf("key not exported: %q", key) }
+    if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o600 { t.Fatalf("expected 0600, got %v", fi.Mode().Perm()) }
+
+    delete(certs, "db.tn.ts.net")
+    if err := s.Write(certs); err != nil { t.Fatal(err) }
+    before, _ := os.Stat(path)
+    if err := s.Write(certs); err != nil { t.Fatal(err) }
+    if after, _ := os.Stat(path); !after.ModTime().Equal(before.ModTime()) { t.Fatal("unchanged store should not be rewritten") }
+}
@@ -0,0 +1,87 @@
+package traefik
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+)
+
+// DirHeader is the first line of every file DirPublisher writes. Only files starting with it
+// are ever removed, so hand-written files in the same directory are safe.
+const DirHeader = "# Managed by TailWhale; regenerated on every sync."
+
+// DirPublisher maintains one file per hostname in a directory watched by Traefik's
+// providers.file.directory. Unchanged files are not rewritten, so Traefik only reloads
+// for services that actually changed.
+type DirPublisher struct {
+    Dir    string
+    Format Format
+    Mode   fs.FileMode // defaults to 0644; use 0600 when certificates are inlined
+}
+
+// DirChanges lists the file names touched by a publish.
+type DirChanges struct {
+    Written []string
+    Removed []string
+}
+
+func (p DirPublisher) Publish(cfg Config) error {
+    _, err := p.Sync(cfg)
+    return err
+}
+
+// Sync writes changed files and removes TailWhale files for hosts no longer present.
+func (p DirPublisher) Sync(cfg Config) (DirChanges, error) {
+    var ch DirChanges
+    ext := ".yml"
+    if p.Format == FormatTOML { ext = ".toml" }
+    want := map[string][]byte{}
+    for key, part := range Split(cfg) {
+        if !hasEntries(reflect.ValueOf(part)) { continue }
+        data := MarshalConfigYAML(part)
+        if p.Format == FormatTOML { data = MarshalConfigTOML(part) }
+        want[fileStem(key)+ext] = append([]byte(DirHeader+"\n"), data...)
+    }
+    names := make([]string, 0, len(want))
+    for n := range want { names = append(names, n) }
+    sort.Strings(names)
+    for _, n := range names {
+        path := filepath.Join(p.Dir, n)
+        if cur, err := os.ReadFile(path); err == nil && bytes.Equal(cur, want[n]) { continue }
+        if err := fsx.WriteFileAtomic(path, want[n], fileMode(p.Mode)); err != nil { return ch, err }
+        ch.Written = append(ch.Written, n)
+    }
+    entries, err := os.ReadDir(p.Dir)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) { return ch, err }
+    for _, e := range entries {
+        n := e.Name()
+        if e.IsDir() || want[n] != nil || !(strings.HasSuffix(n, ".yml") || strings.HasSuffix(n, ".toml")) { continue }
+        if !managedFile(filepath.Join(p.Dir, n)) { continue }
+        if err := os.Remove(filepath.Join(p.Dir, n)); err != nil && !errors.Is(err, fs.ErrNotExist) { return ch, err }
+        ch.Removed = append(ch.Removed, n)
+    }
+    return ch, nil
+}
+
+// fileStem turns a part key (a hostname, possibly with a scheme) into a safe file name.
+func fileStem(key string) string {
+    if i := strings.Index(key, "://"); i >= 0 { key = key[i+3:] }
+    return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(key)
+}
+
+// managedFile reports whether the file starts with DirHeader.
+func managedFile(path string) bool {
+    f, err := os.Open(path)
+    if err != nil { return false }
+    defer f.Close()
+    line, _ := bufio.NewReader(f).ReadString('\n')
+    return strings.TrimRight(line, "\r\n") == DirHeader
+}
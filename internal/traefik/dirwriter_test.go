@@ -0,0 +1,45 @@
+package traefik
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestDirPublisherWritesOnlyChangedFiles(t *testing.T){
+    dir := t.TempDir()
+    foreign := filepath.Join(dir, "ops.yml")
+    if err := os.WriteFile(foreign, []byte("http: {}\n"), 0o644); err != nil { t.Fatal(err) }
+    routes := []Route{
+        {Name: "a", Host: "a.example", Servers: []string{"http://a:80"}},
+        {Name: "b", Host: "b.example", Servers: []string{"http://b:80"}},
+    }
+    certs := TLSConfig{"a.example": {CertFile: "/c/a.crt", KeyFile: "/c/a.key"}, "b.example": {CertFile: "/c/b.crt", KeyFile: "/c/b.key"}}
+    p := DirPublisher{Dir: dir}
+    ch, err := p.Sync(Build(routes, certs, Options{TLSProfile: "intermediate"}))
+    if err != nil { t.Fatal(err) }
+    if strings.Join(ch.Written, ",") != "_shared.yml,a.example.yml,b.example.yml" { t.Fatalf("unexpected writes: %v", ch.Written) }
+    a, _ := os.ReadFile(filepath.Join(dir, "a.example.yml"))
+    if !strings.HasPrefix(string(a), DirHeader+"\nhttp:\n") || strings.Contains(string(a), "b.example") || !strings.Contains(string(a), "/c/a.crt") {
+        t.Fatalf("unexpected a.example.yml:\n%s", a)
+    }
+    shared, _ := os.ReadFile(filepath.Join(dir, "_shared.yml"))
+    if !strings.Contains(string(shared), "tailwhale-intermediate:") { t.Fatalf("shared profile missing:\n%s", shared) }
+
+    routes[1].Servers = []string{"http://b:8080"}
+    ch, err = p.Sync(Build(routes, certs, Options{TLSProfile: "intermediate"}))
+    if err != nil { t.Fatal(err) }
+    if strings.Join(ch.Written, ",") != "b.example.yml" || len(ch.Removed) != 0 { t.Fatalf("expected only b rewritten: %+v", ch) }
+
+    delete(certs, "b.example")
+    ch, err = p.Sync(Build(routes[:1], certs, Options{TLSProfile: "intermediate"}))
+    if err != nil { t.Fatal(err) }
+    if len(ch.Written) != 0 || strings.Join(ch.Removed, ",") != "b.example.yml" { t.Fatalf("expected stale b removed: %+v", ch) }
+    if _, err := os.Stat(foreign); err != nil { t.Fatalf("foreign file must survive: %v", err) }
+}
+
+func TestSplitWithoutParts(t *testing.T){
+    var cfg Config
+    if parts := Split(cfg); len(parts) != 1 { t.Fatalf("expected a single shared part: %v", parts) }
+}
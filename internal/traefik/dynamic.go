@@ -0,0 +1,504 @@
+package traefik
+
+import (
+    "reflect"
+    "sort"
+    "strconv"
+)
+
+// Default entry point names used when Options leaves them empty.
+const (
+    DefaultEntryPoint    = "websecure"
+    DefaultTCPEntryPoint = "websecure"
+    DefaultUDPEntryPoint = "udp"
+    DefaultWebEntryPoint = "web"
+)
+
+// TailnetSourceRange is the default ipAllowList: Tailscale's CGNAT and ULA ranges.
+var TailnetSourceRange = []string{"100.64.0.0/10", "fd7a:115c:a1e0::/48"}
+
+// Route protocols.
+const (
+    ProtocolHTTP = "http"
+    ProtocolTCP  = "tcp"
+    ProtocolUDP  = "udp"
+)
+
+// Route is the routing input for one discovered service.
+// The traefik package does not depend on core; the orchestrator translates services into routes.
+type Route struct {
+    Name          string   // unique router/service name
+    Host          string   // hostname matched by the router (Host or HostSNI)
+    Protocol      string   // http (default), tcp or udp
+    EntryPoint    string   // overrides the protocol's default entry point
+    PathPrefix    string   // http only: also match this path prefix (e.g. /web) and strip it before forwarding
+    Servers       []string // backend URLs for http (http://app:8080), addresses for tcp/udp (app:5432)
+    Middlewares   Middlewares
+    TLS           RouteTLS
+    LoadBalancing LoadBalancing // http only
+    Variants      []Variant     // http only: weighted backends sharing the route (canary, blue/green)
+}
+
+// Variant is one weighted set of servers behind a route. Servers of the route still lists
+// the servers of every variant with traffic, for protocols without weighted services.
+type Variant struct {
+    Name    string   // suffix of the variant's service, <route>-<name>
+    Weight  int      // relative share of traffic; variants with 0 get none
+    Servers []string
+}
+
+// LoadBalancing tunes how an http route spreads traffic over its servers.
+type LoadBalancing struct {
+    StickyCookie   string // cookie pinning clients to a server ("true" lets Traefik name it); empty disables
+    HealthPath     string // active health check path; empty disables health checks
+    HealthInterval string // e.g. 10s; empty keeps Traefik's default
+    HealthTimeout  string // e.g. 3s; empty keeps Traefik's default
+}
+
+// Middlewares is the per-route protection requested through labels (http routes only).
+type Middlewares struct {
+    AllowList          []string          // ipAllowList source ranges; empty disables
+    BasicAuthUsersFile string            // htpasswd-style file readable by Traefik
+    Headers            map[string]string // custom response headers
+    STSSeconds         int               // Strict-Transport-Security max-age; 0 disables
+    Redirect           bool              // add an HTTP router on the web entry point redirecting to HTTPS
+}
+
+// Options tunes Build. Zero values fall back to the Default*EntryPoint names.
+type Options struct {
+    EntryPoint    string
+    TCPEntryPoint string
+    UDPEntryPoint string
+    WebEntryPoint string              // plain HTTP entry point used by redirect routers
+    TLSProfile    string              // default TLS options profile (see TLSProfiles); empty keeps Traefik's defaults
+    ClientCAs     map[string][]string // named CA bundles (file paths as seen by Traefik) for mTLS
+
+    sharedOptions map[string]string // route name -> options entry it shares with an earlier route, set by Build
+}
+
+// Config is the dynamic configuration TailWhale renders for Traefik's file provider.
+// Field tags follow Traefik's own key names so the same value renders to YAML or JSON.
+type Config struct {
+    HTTP *HTTPConfig `json:"http,omitempty"`
+    TCP  *TCPConfig  `json:"tcp,omitempty"`
+    UDP  *UDPConfig  `json:"udp,omitempty"`
+    TLS  *TLSBlock   `json:"tls,omitempty"`
+
+    // parts is the same configuration split per hostname (plus SharedPart), as recorded by Build.
+    parts map[string]Config
+}
+
+// SharedPart keys the entries of a split config that belong to no single host,
+// such as shared TLS option profiles. It cannot collide with a hostname.
+const SharedPart = "_shared"
+
+// HTTPConfig holds the http section of the dynamic configuration.
+type HTTPConfig struct {
+    Routers     map[string]Router     `json:"routers,omitempty"`
+    Services    map[string]Service    `json:"services,omitempty"`
+    Middlewares map[string]Middleware `json:"middlewares,omitempty"`
+}
+
+// Router matches requests and forwards them to a service.
+type Router struct {
+    EntryPoints []string   `json:"entryPoints,omitempty"`
+    Rule        string     `json:"rule,omitempty"`
+    Middlewares []string   `json:"middlewares,omitempty"`
+    Service     string     `json:"service,omitempty"`
+    TLS         *RouterTLS `json:"tls,omitempty"`
+}
+
+// RouterTLS enables TLS on a router; an empty value renders as `tls: {}`.
+// Passthrough is only valid on tcp routers.
+type RouterTLS struct {
+    Options     string `json:"options,omitempty"`
+    Passthrough bool   `json:"passthrough,omitempty"`
+}
+
+// Service describes where Traefik sends matched traffic.
+type Service struct {
+    LoadBalancer *LoadBalancer `json:"loadBalancer,omitempty"`
+    Weighted     *Weighted     `json:"weighted,omitempty"`
+}
+
+// Weighted splits traffic between other services in proportion to their weights.
+type Weighted struct {
+    Services []WeightedService `json:"services,omitempty"`
+    Sticky   *Sticky           `json:"sticky,omitempty"`
+}
+
+// WeightedService references a service by name with its share of traffic.
+type WeightedService struct {
+    Name   string `json:"name,omitempty"`
+    Weight int    `json:"weight,omitempty"`
+}
+
+// LoadBalancer lists the backend servers of a service.
+type LoadBalancer struct {
+    Servers     []Server     `json:"servers,omitempty"`
+    Sticky      *Sticky      `json:"sticky,omitempty"`
+    HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+}
+
+// Sticky pins a client to one server with a cookie.
+type Sticky struct {
+    Cookie *StickyCookie `json:"cookie,omitempty"`
+}
+
+// StickyCookie configures the sticky session cookie; an empty name lets Traefik derive one.
+type StickyCookie struct {
+    Name     string `json:"name,omitempty"`
+    Secure   bool   `json:"secure,omitempty"`
+    HTTPOnly bool   `json:"httpOnly,omitempty"`
+}
+
+// HealthCheck removes servers failing GET Path from the rotation until they recover.
+type HealthCheck struct {
+    Path     string `json:"path,omitempty"`
+    Interval string `json:"interval,omitempty"`
+    Timeout  string `json:"timeout,omitempty"`
+}
+
+// Server is a single backend URL.
+type Server struct {
+    URL string `json:"url,omitempty"`
+}
+
+// Middleware is one entry of http.middlewares; exactly one field is set.
+type Middleware struct {
+    IPAllowList    *IPAllowList    `json:"ipAllowList,omitempty"`
+    BasicAuth      *BasicAuth      `json:"basicAuth,omitempty"`
+    Headers        *Headers        `json:"headers,omitempty"`
+    RedirectScheme *RedirectScheme `json:"redirectScheme,omitempty"`
+    StripPrefix    *StripPrefix    `json:"stripPrefix,omitempty"`
+}
+
+// IPAllowList rejects clients outside SourceRange.
+type IPAllowList struct {
+    SourceRange []string `json:"sourceRange,omitempty"`
+}
+
+// BasicAuth checks credentials against an htpasswd file.
+type BasicAuth struct {
+    UsersFile string `json:"usersFile,omitempty"`
+}
+
+// Headers adds response headers, including HSTS.
+type Headers struct {
+    CustomResponseHeaders map[string]string `json:"customResponseHeaders,omitempty"`
+    STSSeconds            int               `json:"stsSeconds,omitempty"`
+    STSIncludeSubdomains  bool              `json:"stsIncludeSubdomains,omitempty"`
+}
+
+// RedirectScheme redirects requests to another scheme.
+type RedirectScheme struct {
+    Scheme    string `json:"scheme,omitempty"`
+    Permanent bool   `json:"permanent,omitempty"`
+}
+
+// StripPrefix removes a path prefix before the request is forwarded.
+type StripPrefix struct {
+    Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// TCPConfig holds the tcp section of the dynamic configuration.
+type TCPConfig struct {
+    Routers  map[string]TCPRouter  `json:"routers,omitempty"`
+    Services map[string]TCPService `json:"services,omitempty"`
+}
+
+// TCPRouter matches TLS connections by SNI; a non-nil TLS terminates them with the matching certificate.
+type TCPRouter struct {
+    EntryPoints []string   `json:"entryPoints,omitempty"`
+    Rule        string     `json:"rule,omitempty"`
+    Service     string     `json:"service,omitempty"`
+    TLS         *RouterTLS `json:"tls,omitempty"`
+}
+
+// TCPService forwards connections to backend addresses.
+type TCPService struct {
+    LoadBalancer *AddressLoadBalancer `json:"loadBalancer,omitempty"`
+}
+
+// AddressLoadBalancer lists host:port backends for tcp and udp services.
+type AddressLoadBalancer struct {
+    Servers []AddressServer `json:"servers,omitempty"`
+}
+
+// AddressServer is a single host:port backend.
+type AddressServer struct {
+    Address string `json:"address,omitempty"`
+}
+
+// UDPConfig holds the udp section of the dynamic configuration.
+type UDPConfig struct {
+    Routers  map[string]UDPRouter  `json:"routers,omitempty"`
+    Services map[string]UDPService `json:"services,omitempty"`
+}
+
+// UDPRouter binds an entry point to a service; UDP has no rules, so each service needs its own entry point.
+type UDPRouter struct {
+    EntryPoints []string `json:"entryPoints,omitempty"`
+    Service     string   `json:"service,omitempty"`
+}
+
+// UDPService forwards datagrams to backend addresses.
+type UDPService struct {
+    LoadBalancer *AddressLoadBalancer `json:"loadBalancer,omitempty"`
+}
+
+// TLSBlock holds the tls section of the dynamic configuration.
+type TLSBlock struct {
+    Certificates []Certificate        `json:"certificates,omitempty"`
+    Options      map[string]TLSOption `json:"options,omitempty"`
+}
+
+// Certificate is one entry of tls.certificates.
+type Certificate struct {
+    CertFile string   `json:"certFile,omitempty"`
+    KeyFile  string   `json:"keyFile,omitempty"`
+    Stores   []string `json:"stores,omitempty"`
+}
+
+// Build renders routes and certificates into a complete dynamic configuration.
+// Routes without servers, or requiring an unknown mTLS CA, still get their certificate but no router.
+func Build(routes []Route, certs TLSConfig, opt Options) Config {
+    parts := map[string]*Config{}
+    part := func(key string) *Config {
+        if parts[key] == nil { parts[key] = &Config{} }
+        return parts[key]
+    }
+    opt.sharedOptions = shareOptions(routes, opt)
+    for _, r := range routes {
+        if r.Name == "" || r.Host == "" || len(r.Servers) == 0 || missingClientCA(r, opt) { continue }
+        switch r.Protocol {
+        case ProtocolTCP:
+            addTCP(part(r.Host), part(SharedPart), r, entryPoint(r.EntryPoint, opt.TCPEntryPoint, DefaultTCPEntryPoint), opt)
+        case ProtocolUDP:
+            addUDP(part(r.Host), r, entryPoint(r.EntryPoint, opt.UDPEntryPoint, DefaultUDPEntryPoint))
+        default:
+            addHTTP(part(r.Host), part(SharedPart), r, entryPoint(r.EntryPoint, opt.EntryPoint, DefaultEntryPoint), entryPoint(opt.WebEntryPoint, DefaultWebEntryPoint), opt)
+        }
+    }
+    for h, c := range certs {
+        p := part(h)
+        if p.TLS == nil { p.TLS = &TLSBlock{} }
+        p.TLS.Certificates = append(p.TLS.Certificates, certificate(c))
+    }
+    return assemble(parts)
+}
+
+// Split returns cfg divided per hostname, plus SharedPart for common entries.
+// Configs not produced by Build come back whole under SharedPart.
+func Split(cfg Config) map[string]Config {
+    if cfg.parts == nil { return map[string]Config{SharedPart: cfg} }
+    out := make(map[string]Config, len(cfg.parts))
+    for k, p := range cfg.parts { out[k] = p }
+    return out
+}
+
+// assemble merges parts in key order (so certificates stay sorted by host) and keeps them for Split.
+func assemble(parts map[string]*Config) Config {
+    keys := make([]string, 0, len(parts))
+    for k := range parts { keys = append(keys, k) }
+    sort.Strings(keys)
+    var cfg Config
+    for _, k := range keys {
+        p := *parts[k]
+        if !hasEntries(reflect.ValueOf(p)) { continue }
+        mergeInto(&cfg, p)
+        if cfg.parts == nil { cfg.parts = map[string]Config{} }
+        cfg.parts[k] = p
+    }
+    return cfg
+}
+
+// mergeInto adds src's second-level entries (maps and lists) to dst.
+func mergeInto(dst *Config, src Config) {
+    dv, sv := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src)
+    for i := 0; i < sv.NumField(); i++ {
+        if !sv.Type().Field(i).IsExported() || sv.Field(i).IsNil() { continue }
+        if dv.Field(i).IsNil() { dv.Field(i).Set(reflect.New(sv.Field(i).Type().Elem())) }
+        d, s := dv.Field(i).Elem(), sv.Field(i).Elem()
+        for j := 0; j < s.NumField(); j++ {
+            sf, df := s.Field(j), d.Field(j)
+            switch sf.Kind() {
+            case reflect.Map:
+                if sf.Len() == 0 { continue }
+                if df.IsNil() { df.Set(reflect.MakeMap(sf.Type())) }
+                for _, k := range sf.MapKeys() { df.SetMapIndex(k, sf.MapIndex(k)) }
+            case reflect.Slice:
+                df.Set(reflect.AppendSlice(df, sf))
+            }
+        }
+    }
+}
+
+func addHTTP(cfg, shared *Config, r Route, ep, web string, opt Options) {
+    if cfg.HTTP == nil {
+        cfg.HTTP = &HTTPConfig{Routers: map[string]Router{}, Services: map[string]Service{}}
+    }
+    cfg.HTTP.Routers[r.Name] = Router{
+        EntryPoints: []string{ep},
+        Rule:        httpRule(r),
+        Middlewares: addMiddlewares(cfg.HTTP, r),
+        Service:     r.Name,
+        TLS:         routerTLS(cfg, shared, r, opt),
+    }
+    if len(r.Variants) > 0 {
+        addWeighted(cfg.HTTP, r)
+    } else {
+        cfg.HTTP.Services[r.Name] = Service{LoadBalancer: loadBalancer(r, r.Servers)}
+    }
+    if r.Middlewares.Redirect {
+        name := r.Name + "-redirect"
+        setMiddleware(cfg.HTTP, name, Middleware{RedirectScheme: &RedirectScheme{Scheme: "https", Permanent: true}})
+        cfg.HTTP.Routers[name] = Router{
+            EntryPoints: []string{web},
+            Rule:        httpRule(r),
+            Middlewares: []string{name},
+            Service:     "noop@internal",
+        }
+    }
+}
+
+// addWeighted points the route's service at one load balancer per variant with traffic.
+// Sticky sessions also pin clients to a variant, so a canary user stays on the canary.
+// VariantSeparator joins a route's name and a variant's into the variant's service name.
+// Route names never contain it, so variant services can't clash with other routes.
+const VariantSeparator = "."
+
+func addWeighted(h *HTTPConfig, r Route) {
+    w := &Weighted{Sticky: sticky(r.LoadBalancing, "_variant")}
+    for _, v := range r.Variants {
+        if v.Weight <= 0 || len(v.Servers) == 0 { continue }
+        name := r.Name + VariantSeparator + v.Name
+        h.Services[name] = Service{LoadBalancer: loadBalancer(r, v.Servers)}
+        w.Services = append(w.Services, WeightedService{Name: name, Weight: v.Weight})
+    }
+    h.Services[r.Name] = Service{Weighted: w}
+}
+
+func loadBalancer(r Route, servers []string) *LoadBalancer {
+    lb := &LoadBalancer{Sticky: sticky(r.LoadBalancing, "")}
+    for _, u := range servers { lb.Servers = append(lb.Servers, Server{URL: u}) }
+    if r.LoadBalancing.HealthPath != "" {
+        lb.HealthCheck = &HealthCheck{Path: r.LoadBalancing.HealthPath, Interval: r.LoadBalancing.HealthInterval, Timeout: r.LoadBalancing.HealthTimeout}
+    }
+    return lb
+}
+
+// sticky returns the sticky cookie settings, or nil when sticky sessions are off. Named
+// cookies get suffix so the weighted level and the variant's servers don't share one;
+// for "true" Traefik derives a distinct name per service itself.
+func sticky(lb LoadBalancing, suffix string) *Sticky {
+    c := lb.StickyCookie
+    if c == "" { return nil }
+    cookie := &StickyCookie{Name: c + suffix, Secure: true, HTTPOnly: true}
+    if c == "true" { cookie.Name = "" }
+    return &Sticky{Cookie: cookie}
+}
+
+// addMiddlewares defines the route's middlewares and returns their names in evaluation order:
+// the allowlist first so rejected clients never reach basic auth, the path prefix stripped last.
+func addMiddlewares(h *HTTPConfig, r Route) []string {
+    m := r.Middlewares
+    var names []string
+    if len(m.AllowList) > 0 {
+        name := r.Name + "-allowlist"
+        setMiddleware(h, name, Middleware{IPAllowList: &IPAllowList{SourceRange: m.AllowList}})
+        names = append(names, name)
+    }
+    if m.BasicAuthUsersFile != "" {
+        name := r.Name + "-auth"
+        setMiddleware(h, name, Middleware{BasicAuth: &BasicAuth{UsersFile: m.BasicAuthUsersFile}})
+        names = append(names, name)
+    }
+    if len(m.Headers) > 0 || m.STSSeconds > 0 {
+        name := r.Name + "-headers"
+        setMiddleware(h, name, Middleware{Headers: &Headers{
+            CustomResponseHeaders: m.Headers,
+            STSSeconds:            m.STSSeconds,
+            STSIncludeSubdomains:  m.STSSeconds > 0,
+        }})
+        names = append(names, name)
+    }
+    if r.PathPrefix != "" {
+        name := r.Name + "-stripprefix"
+        setMiddleware(h, name, Middleware{StripPrefix: &StripPrefix{Prefixes: []string{r.PathPrefix}}})
+        names = append(names, name)
+    }
+    return names
+}
+
+func setMiddleware(h *HTTPConfig, name string, m Middleware) {
+    if h.Middlewares == nil { h.Middlewares = map[string]Middleware{} }
+    h.Middlewares[name] = m
+}
+
+func addTCP(cfg, shared *Config, r Route, ep string, opt Options) {
+    if cfg.TCP == nil {
+        cfg.TCP = &TCPConfig{Routers: map[string]TCPRouter{}, Services: map[string]TCPService{}}
+    }
+    tls := &RouterTLS{Passthrough: true}
+    if !r.TLS.Passthrough { tls = routerTLS(cfg, shared, r, opt) }
+    cfg.TCP.Routers[r.Name] = TCPRouter{
+        EntryPoints: []string{ep},
+        Rule:        HostSNIRule(r.Host),
+        Service:     r.Name,
+        TLS:         tls,
+    }
+    cfg.TCP.Services[r.Name] = TCPService{LoadBalancer: addressLB(r.Servers)}
+}
+
+func addUDP(cfg *Config, r Route, ep string) {
+    if cfg.UDP == nil {
+        cfg.UDP = &UDPConfig{Routers: map[string]UDPRouter{}, Services: map[string]UDPService{}}
+    }
+    cfg.UDP.Routers[r.Name] = UDPRouter{EntryPoints: []string{ep}, Service: r.Name}
+    cfg.UDP.Services[r.Name] = UDPService{LoadBalancer: addressLB(r.Servers)}
+}
+
+func addressLB(servers []string) *AddressLoadBalancer {
+    lb := &AddressLoadBalancer{}
+    for _, a := range servers { lb.Servers = append(lb.Servers, AddressServer{Address: a}) }
+    return lb
+}
+
+// entryPoint picks the first non-empty name.
+func entryPoint(names ...string) string {
+    for _, n := range names {
+        if n != "" { return n }
+    }
+    return ""
+}
+
+// HostRule returns a Traefik Host() matcher for host.
+func HostRule(host string) string {
+    return "Host(`" + host + "`)"
+}
+
+// httpRule matches the route's host and, with a path prefix, the prefix itself or any path
+// below it (/web and /web/..., not /webapp).
+func httpRule(r Route) string {
+    if r.PathPrefix == "" { return HostRule(r.Host) }
+    return HostRule(r.Host) + " && (Path(`" + r.PathPrefix + "`) || PathPrefix(`" + r.PathPrefix + "/`))"
+}
+
+// PortEntryPoint names the entry point serving port-routed services on port, e.g. tailwhale-8443.
+// Traefik's static configuration must define it with address :<port>.
+func PortEntryPoint(port int) string {
+    return "tailwhale-" + strconv.Itoa(port)
+}
+
+// HostSNIRule returns a Traefik HostSNI() matcher for host.
+func HostSNIRule(host string) string {
+    return "HostSNI(`" + host + "`)"
+}
+
+// certificate converts a TLSCert into a tls.certificates entry.
+func certificate(c TLSCert) Certificate {
+    stores := c.Stores
+    if len(stores) == 0 { stores = []string{"default"} }
+    return Certificate{CertFile: c.CertFile, KeyFile: c.KeyFile, Stores: stores}
+}
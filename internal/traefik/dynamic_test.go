@@ -0,0 +1,116 @@
+package traefik
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestBuildAndMarshalConfigYAML(t *testing.T){
+    routes := []Route{
+        {Name: "web", Host: "web.host1.tn.ts.net", Servers: []string{"http://172.18.0.2:8080"}},
+        {Name: "noport", Host: "noport.host1.tn.ts.net"},
+    }
+    certs := TLSConfig{"web.host1.tn.ts.net": {CertFile: "/certs/web.crt", KeyFile: "/certs/web.key"}}
+    got := string(MarshalConfigYAML(Build(routes, certs, Options{})))
+    want := "http:\n" +
+        "  routers:\n" +
+        "    web:\n" +
+        "      entryPoints:\n        - \"websecure\"\n" +
+        "      rule: \"Host(`web.host1.tn.ts.net`)\"\n" +
+        "      service: \"web\"\n" +
+        "      tls: {}\n" +
+        "  services:\n" +
+        "    web:\n" +
+        "      loadBalancer:\n" +
+        "        servers:\n" +
+        "          - url: \"http://172.18.0.2:8080\"\n" +
+        "tls:\n" +
+        "  certificates:\n" +
+        "    - certFile: \"/certs/web.crt\"\n" +
+        "      keyFile: \"/certs/web.key\"\n" +
+        "      stores:\n        - \"default\"\n"
+    if got != want {
+        t.Fatalf("unexpected YAML\n--- got ---\n%s\n--- want ---\n%s", got, want)
+    }
+}
+
+func TestMarshalConfigYAMLEscapes(t *testing.T){
+    certs := TLSConfig{"a.example": {CertFile: "/certs/we\"ird\\name.crt", KeyFile: "/certs/a.key"}}
+    got := string(MarshalConfigYAML(Build(nil, certs, Options{EntryPoint: "https"})))
+    if !strings.Contains(got, `certFile: "/certs/we\"ird\\name.crt"`) {
+        t.Fatalf("expected escaped path, got:\n%s", got)
+    }
+    if strings.Contains(got, "http:") {
+        t.Fatalf("expected no http section without routes:\n%s", got)
+    }
+}
+
+func TestBuildTCPAndUDP(t *testing.T){
+    routes := []Route{
+        {Name: "db", Host: "db.host1.tn.ts.net", Protocol: ProtocolTCP, Servers: []string{"db:5432"}},
+        {Name: "game", Host: "game.host1.tn.ts.net", Protocol: ProtocolUDP, EntryPoint: "game", Servers: []string{"game:27015"}},
+        {Name: "dns", Host: "dns.host1.tn.ts.net", Protocol: ProtocolUDP, Servers: []string{"dns:53"}},
+    }
+    cfg := Build(routes, nil, Options{TCPEntryPoint: "postgres", UDPEntryPoint: "udp53"})
+    if cfg.HTTP != nil { t.Fatalf("unexpected http section: %+v", cfg.HTTP) }
+    r := cfg.TCP.Routers["db"]
+    if r.Rule != "HostSNI(`db.host1.tn.ts.net`)" || r.TLS == nil || r.EntryPoints[0] != "postgres" { t.Fatalf("unexpected tcp router: %+v", r) }
+    if cfg.TCP.Services["db"].LoadBalancer.Servers[0].Address != "db:5432" { t.Fatalf("unexpected tcp service: %+v", cfg.TCP.Services["db"]) }
+    if cfg.UDP.Routers["game"].EntryPoints[0] != "game" || cfg.UDP.Routers["dns"].EntryPoints[0] != "udp53" { t.Fatalf("unexpected udp routers: %+v", cfg.UDP.Routers) }
+    out := string(MarshalConfigYAML(cfg))
+    if !strings.Contains(out, "tcp:\n  routers:\n    db:\n") || !strings.Contains(out, "          - address: \"dns:53\"\n") {
+        t.Fatalf("unexpected YAML:\n%s", out)
+    }
+}
+
+func TestBuildMiddlewares(t *testing.T){
+    routes := []Route{{
+        Name: "admin", Host: "admin.host1.tn.ts.net", Servers: []string{"http://admin:80"},
+        Middlewares: Middlewares{AllowList: TailnetSourceRange, BasicAuthUsersFile: "/secrets/htpasswd", STSSeconds: 600, Redirect: true},
+    }}
+    cfg := Build(routes, nil, Options{})
+    r := cfg.HTTP.Routers["admin"]
+    if strings.Join(r.Middlewares, ",") != "admin-allowlist,admin-auth,admin-headers" { t.Fatalf("unexpected middlewares: %v", r.Middlewares) }
+    if cfg.HTTP.Middlewares["admin-allowlist"].IPAllowList.SourceRange[1] != "fd7a:115c:a1e0::/48" { t.Fatalf("unexpected allowlist: %+v", cfg.HTTP.Middlewares) }
+    red := cfg.HTTP.Routers["admin-redirect"]
+    if red.EntryPoints[0] != "web" || red.TLS != nil || red.Middlewares[0] != "admin-redirect" { t.Fatalf("unexpected redirect router: %+v", red) }
+    out := string(MarshalConfigYAML(cfg))
+    if !strings.Contains(out, "      redirectScheme:\n        scheme: \"https\"\n        permanent: true\n") {
+        t.Fatalf("unexpected YAML:\n%s", out)
+    }
+}
+
+func TestBuildWeightedVariants(t *testing.T){
+    routes := []Route{{
+        Name: "api", Host: "api.host1.tn.ts.net", Servers: []string{"http://10.0.0.1:80", "http://10.0.0.2:80"},
+        LoadBalancing: LoadBalancing{StickyCookie: "sid"},
+        Variants: []Variant{
+            {Name: "blue", Weight: 90, Servers: []string{"http://10.0.0.1:80"}},
+            {Name: "green", Weight: 10, Servers: []string{"http://10.0.0.2:80"}},
+            {Name: "old", Weight: 0, Servers: []string{"http://10.0.0.3:80"}},
+        },
+    }}
+    cfg := Build(routes, nil, Options{})
+    w := cfg.HTTP.Services["api"].Weighted
+    if w == nil || len(w.Services) != 2 || w.Services[0] != (WeightedService{"api.blue", 90}) || w.Services[1] != (WeightedService{"api.green", 10}) { t.Fatalf("unexpected weighted service: %+v", cfg.HTTP.Services["api"]) }
+    if w.Sticky.Cookie.Name != "sid_variant" || cfg.HTTP.Services["api.green"].LoadBalancer.Sticky.Cookie.Name != "sid" { t.Fatalf("unexpected sticky cookies: %+v", cfg.HTTP.Services) }
+    if _, ok := cfg.HTTP.Services["api-old"]; ok { t.Fatal("drained variant still has a service") }
+    out := string(MarshalConfigYAML(cfg))
+    if !strings.Contains(out, "    api:\n      weighted:\n        services:\n          - name: \"api.blue\"\n            weight: 90\n") {
+        t.Fatalf("unexpected YAML:\n%s", out)
+    }
+}
+
+func TestBuildPathPrefixRoute(t *testing.T){
+    routes := []Route{{
+        Name: "web", Host: "host1.tn.ts.net", PathPrefix: "/web", Servers: []string{"http://10.0.0.1:80"},
+        Middlewares: Middlewares{AllowList: TailnetSourceRange, Redirect: true},
+    }}
+    cfg := Build(routes, nil, Options{})
+    r := cfg.HTTP.Routers["web"]
+    if r.Rule != "Host(`host1.tn.ts.net`) && (Path(`/web`) || PathPrefix(`/web/`))" { t.Fatalf("unexpected rule: %s", r.Rule) }
+    if len(r.Middlewares) != 2 || r.Middlewares[1] != "web-stripprefix" { t.Fatalf("strip prefix should run last: %v", r.Middlewares) }
+    if sp := cfg.HTTP.Middlewares["web-stripprefix"].StripPrefix; sp == nil || len(sp.Prefixes) != 1 || sp.Prefixes[0] != "/web" { t.Fatalf("unexpected middleware: %+v", cfg.HTTP.Middlewares) }
+    if cfg.HTTP.Routers["web-redirect"].Rule != r.Rule { t.Fatalf("redirect router should match the same paths: %+v", cfg.HTTP.Routers["web-redirect"]) }
+    if out := string(MarshalConfigYAML(cfg)); !strings.Contains(out, "      stripPrefix:\n        prefixes:\n          - \"/web\"\n") { t.Fatalf("unexpected YAML:\n%s", out) }
+}
@@ -0,0 +1,70 @@
+package traefik
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// HTTPPublisher serves the latest config as JSON for Traefik's providers.http.
+// Responses carry ETag and Last-Modified, which only change when the rendered JSON does,
+// so conditional polls are answered with 304 Not Modified.
+type HTTPPublisher struct {
+    mu       sync.RWMutex
+    body     []byte
+    etag     string
+    modified time.Time
+    // Now is used for Last-Modified; defaults to time.Now.
+    Now func() time.Time
+}
+
+func (p *HTTPPublisher) Publish(cfg Config) error {
+    body, err := json.Marshal(cfg)
+    if err != nil { return err }
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.body != nil && bytes.Equal(body, p.body) { return nil }
+    now := time.Now
+    if p.Now != nil { now = p.Now }
+    sum := sha256.Sum256(body)
+    p.body = body
+    p.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+    p.modified = now().UTC().Truncate(time.Second)
+    return nil
+}
+
+// Snapshot restores the previously served config, validators included.
+func (p *HTTPPublisher) Snapshot() (func() error, error) {
+    p.mu.RLock()
+    body, etag, modified := p.body, p.etag, p.modified
+    p.mu.RUnlock()
+    return func() error {
+        p.mu.Lock()
+        defer p.mu.Unlock()
+        p.body, p.etag, p.modified = body, etag, modified
+        return nil
+    }, nil
+}
+
+func (p *HTTPPublisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        w.Header().Set("Allow", "GET, HEAD")
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    p.mu.RLock()
+    body, etag, modified := p.body, p.etag, p.modified
+    p.mu.RUnlock()
+    if body == nil {
+        // Not synced yet: an empty config would make Traefik drop every route.
+        http.Error(w, "no configuration published yet", http.StatusServiceUnavailable)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("ETag", etag)
+    http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
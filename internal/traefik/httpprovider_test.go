@@ -0,0 +1,60 @@
+package traefik
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestHTTPPublisherConditionalRequests(t *testing.T){
+    now := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+    p := &HTTPPublisher{Now: func() time.Time { return now }}
+    srv := httptest.NewServer(p)
+    defer srv.Close()
+
+    resp, err := http.Get(srv.URL)
+    if err != nil { t.Fatal(err) }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusServiceUnavailable { t.Fatalf("expected 503 before first publish, got %d", resp.StatusCode) }
+
+    if err := p.Publish(mergeTestConfig()); err != nil { t.Fatal(err) }
+    resp, err = http.Get(srv.URL)
+    if err != nil { t.Fatal(err) }
+    var got Config
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil { t.Fatal(err) }
+    resp.Body.Close()
+    if got.HTTP.Routers["web"].Rule != "Host(`web.host1.tn.ts.net`)" || got.TLS.Certificates[0].CertFile != "/certs/web.crt" {
+        t.Fatalf("unexpected config: %+v", got)
+    }
+    etag := resp.Header.Get("ETag")
+    if etag == "" || resp.Header.Get("Last-Modified") != "Mon, 01 Sep 2025 12:00:00 GMT" { t.Fatalf("missing cache headers: %v", resp.Header) }
+
+    // Republishing identical content keeps validators stable.
+    now = now.Add(time.Minute)
+    _ = p.Publish(mergeTestConfig())
+    req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+    req.Header.Set("If-None-Match", etag)
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil { t.Fatal(err) }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusNotModified { t.Fatalf("expected 304, got %d", resp.StatusCode) }
+
+    _ = p.Publish(Config{})
+    resp, err = http.DefaultClient.Do(req)
+    if err != nil { t.Fatal(err) }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag { t.Fatalf("expected new content, got %d %s", resp.StatusCode, resp.Header.Get("ETag")) }
+}
+
+type failingPublisher struct{ calls int }
+
+func (f *failingPublisher) Publish(Config) error { f.calls++; return errors.New("boom") }
+
+func TestPublishersRunAll(t *testing.T){
+    a, b := &failingPublisher{}, &failingPublisher{}
+    if err := (Publishers{a, b}).Publish(Config{}); err == nil { t.Fatal("expected error") }
+    if a.calls != 1 || b.calls != 1 { t.Fatalf("expected every publisher to run: %d %d", a.calls, b.calls) }
+}
@@ -0,0 +1,62 @@
+package traefik
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+)
+
+// InlinePublisher embeds the PEM contents of every certificate and key into the config
+// before handing it on, so Traefik needs no access to the cert dir. The output holds
+// private keys: pair it with 0600 file publishers or an HTTP provider on a private address.
+type InlinePublisher struct {
+    Publisher Publisher
+}
+
+func (p InlinePublisher) Publish(cfg Config) error {
+    inlined, err := InlineCertificates(cfg)
+    if err != nil { return err }
+    return p.Publisher.Publish(inlined)
+}
+
+// Snapshot delegates to the wrapped publisher so verification can roll back inlined output.
+func (p InlinePublisher) Snapshot() (func() error, error) {
+    if s, ok := p.Publisher.(Snapshotter); ok { return s.Snapshot() }
+    return nil, nil
+}
+
+// InlineCertificates returns cfg with certFile/keyFile replaced by the PEM they point to.
+// Errors name the file but never include its contents.
+func InlineCertificates(cfg Config) (Config, error) {
+    read := map[string]string{}
+    pem := func(path string) (string, error) {
+        if s, ok := read[path]; ok { return s, nil }
+        b, err := os.ReadFile(path)
+        if err != nil { return "", fmt.Errorf("inline certificate: %w", err) }
+        if !bytes.Contains(b, []byte("-----BEGIN ")) { return "", fmt.Errorf("inline certificate: %s is not PEM", path) }
+        read[path] = string(b)
+        return read[path], nil
+    }
+    inline := func(c Config) (Config, error) {
+        if c.TLS == nil || len(c.TLS.Certificates) == 0 { return c, nil }
+        tls := *c.TLS
+        tls.Certificates = make([]Certificate, len(c.TLS.Certificates))
+        for i, cert := range c.TLS.Certificates {
+            var err error
+            if cert.CertFile, err = pem(cert.CertFile); err != nil { return c, err }
+            if cert.KeyFile, err = pem(cert.KeyFile); err != nil { return c, err }
+            tls.Certificates[i] = cert
+        }
+        c.TLS = &tls
+        return c, nil
+    }
+    out, err := inline(cfg)
+    if err != nil { return Config{}, err }
+    if cfg.parts == nil { return out, nil }
+    out.parts = make(map[string]Config, len(cfg.parts))
+    for k, part := range cfg.parts {
+        if out.parts[k], err = inline(part); err != nil { return Config{}, err }
+    }
+    return out, nil
+}
+
@@ -0,0 +1,51 @@
+package traefik
+
+import (
+    "reflect"
+    "strconv"
+    "strings"
+)
+
+// DefaultKVPrefix is Traefik's default rootKey for KV providers.
+const DefaultKVPrefix = "traefik"
+
+// KVPairs flattens cfg into Traefik KV provider keys, e.g. traefik/http/routers/app/rule
+// or traefik/tls/certificates/0/certFile. Keys follow the JSON field names, slices are
+// indexed from 0 and an enabled empty section (`tls: {}`) is stored as "true".
+func KVPairs(cfg Config, prefix string) map[string]string {
+    if prefix == "" { prefix = DefaultKVPrefix }
+    out := map[string]string{}
+    kvMapping(out, strings.TrimSuffix(prefix, "/"), reflect.ValueOf(cfg))
+    return out
+}
+
+func kvMapping(out map[string]string, key string, v reflect.Value) {
+    v = deref(v)
+    switch v.Kind() {
+    case reflect.Struct:
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+            if !f.IsExported() { continue }
+            if name := fieldName(f); name != "" { kvEntry(out, key+"/"+name, v.Field(i)) }
+        }
+    case reflect.Map:
+        for _, k := range v.MapKeys() { kvEntry(out, key+"/"+k.String(), v.MapIndex(k)) }
+    }
+}
+
+func kvEntry(out map[string]string, key string, v reflect.Value) {
+    if isEmpty(v) { return }
+    d := deref(v)
+    switch d.Kind() {
+    case reflect.Struct, reflect.Map:
+        if !hasEntries(d) { out[key] = "true"; return }
+        kvMapping(out, key, d)
+    case reflect.Slice, reflect.Array:
+        for i := 0; i < d.Len(); i++ { kvEntry(out, key+"/"+strconv.Itoa(i), d.Index(i)) }
+    case reflect.String:
+        out[key] = d.String()
+    default:
+        out[key] = yamlScalar(d)
+    }
+}
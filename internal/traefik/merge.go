@@ -0,0 +1,495 @@
+package traefik
+
+import (
+    "bytes"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+)
+
+// Markers delimit the blocks TailWhale owns inside a user-managed dynamic config file.
+// Everything outside them is left untouched.
+const (
+    MarkerBegin = "# BEGIN TailWhale managed block (do not edit)"
+    MarkerEnd   = "# END TailWhale managed block"
+)
+
+// Format is the syntax of a dynamic configuration file.
+type Format int
+
+const (
+    FormatYAML Format = iota
+    FormatTOML
+)
+
+// FormatFor picks the format from the file extension; anything but .toml is YAML.
+func FormatFor(path string) Format {
+    if strings.EqualFold(filepath.Ext(path), ".toml") { return FormatTOML }
+    return FormatYAML
+}
+
+// CollisionError reports a foreign entry that would be clobbered by a TailWhale entry.
+type CollisionError struct {
+    Entry  string // dotted path of the foreign entry, e.g. http.routers.web
+    Reason string
+}
+
+func (e *CollisionError) Error() string {
+    return "refusing to overwrite foreign entry " + e.Entry + ": " + e.Reason
+}
+
+// WriteMerged merges cfg into the dynamic config file at path and writes it atomically.
+// A missing file is treated as empty.
+func WriteMerged(path string, cfg Config) error {
+    return writeMerged(path, cfg, 0o644)
+}
+
+func writeMerged(path string, cfg Config, mode fs.FileMode) error {
+    existing, err := os.ReadFile(path)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) { return err }
+    data, err := Merge(existing, cfg, FormatFor(path))
+    if err != nil { return err }
+    return fsx.WriteFileAtomic(path, data, mode)
+}
+
+// Merge replaces TailWhale's managed blocks in existing with cfg, preserving all other bytes.
+// In YAML, entries are inserted into the matching foreign sections (http.routers, tls.certificates, ...)
+// so the document keeps a single key per section; in TOML one block is appended at the end.
+// Foreign entries sharing a name or a Host/HostSNI hostname with cfg cause a *CollisionError,
+// as do foreign TOML values the block would redefine, e.g. `certificates = [...]` under [tls].
+func Merge(existing []byte, cfg Config, f Format) ([]byte, error) {
+    lines := stripManaged(splitLines(existing))
+    if f == FormatTOML { return mergeTOML(lines, cfg) }
+    return mergeYAML(lines, cfg)
+}
+
+// slot is one second-level collection of a Config, e.g. http.routers or tls.certificates.
+type slot struct {
+    top, sub string
+    v        reflect.Value
+}
+
+// slots lists the non-empty second-level collections of cfg, grouped by top-level section.
+func slots(cfg Config) []slot {
+    var out []slot
+    cv := reflect.ValueOf(cfg)
+    for i := 0; i < cv.NumField(); i++ {
+        sec := deref(cv.Field(i))
+        if sec.Kind() != reflect.Struct { continue }
+        top := fieldName(cv.Type().Field(i))
+        for j := 0; j < sec.NumField(); j++ {
+            if !isEmpty(sec.Field(j)) {
+                out = append(out, slot{top: top, sub: fieldName(sec.Type().Field(j)), v: sec.Field(j)})
+            }
+        }
+    }
+    return out
+}
+
+// names returns the entry names of a map slot (none for sequences).
+func (s slot) names() []string {
+    d := deref(s.v)
+    if d.Kind() != reflect.Map { return nil }
+    var out []string
+    for _, k := range d.MapKeys() { out = append(out, k.String()) }
+    return out
+}
+
+var ruleHost = regexp.MustCompile("`([^`]+)`")
+
+// ownedHosts collects the hostnames matched by cfg's routers.
+func ownedHosts(cfg Config) map[string]bool {
+    hosts := map[string]bool{}
+    add := func(rule string) {
+        for _, m := range ruleHost.FindAllStringSubmatch(rule, -1) { hosts[m[1]] = true }
+    }
+    if cfg.HTTP != nil {
+        for _, r := range cfg.HTTP.Routers { add(r.Rule) }
+    }
+    if cfg.TCP != nil {
+        for _, r := range cfg.TCP.Routers { add(r.Rule) }
+    }
+    return hosts
+}
+
+// checkRule reports a collision when a foreign router rule matches one of our hostnames.
+func checkRule(entry, rule string, hosts map[string]bool) error {
+    for _, m := range ruleHost.FindAllStringSubmatch(rule, -1) {
+        if hosts[m[1]] { return &CollisionError{Entry: entry, Reason: "rule matches TailWhale host " + m[1]} }
+    }
+    return nil
+}
+
+func splitLines(b []byte) []string {
+    if len(b) == 0 { return nil }
+    s := strings.TrimSuffix(string(b), "\n")
+    return strings.Split(s, "\n")
+}
+
+// stripManaged drops every line from a begin marker through its end marker.
+func stripManaged(lines []string) []string {
+    var out []string
+    inside := false
+    for _, l := range lines {
+        t := strings.TrimSpace(l)
+        switch {
+        case t == MarkerBegin:
+            inside = true
+        case t == MarkerEnd && inside:
+            inside = false
+        case !inside:
+            out = append(out, l)
+        }
+    }
+    return out
+}
+
+func joinLines(lines []string) []byte {
+    if len(lines) == 0 { return nil }
+    return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// managedBlock wraps rendered content in markers at the given indentation.
+func managedBlock(content []byte, indent int) []string {
+    pad := strings.Repeat(" ", indent)
+    out := []string{pad + MarkerBegin}
+    out = append(out, splitLines(content)...)
+    return append(out, pad+MarkerEnd)
+}
+
+// --- YAML ---
+
+// yamlNode is one mapping key or sequence item of a block-style YAML document.
+type yamlNode struct {
+    indent   int
+    key      string // empty for sequence items
+    value    string // raw inline value after "key:"
+    item     bool
+    line     int // first line index
+    last     int // last line index belonging to the node
+    children []*yamlNode
+}
+
+func (n *yamlNode) child(key string) *yamlNode {
+    for _, c := range n.children {
+        if !c.item && c.key == key { return c }
+    }
+    return nil
+}
+
+// childIndent returns the indentation of n's children, or n.indent+2 when it has none.
+func (n *yamlNode) childIndent() int {
+    if len(n.children) > 0 { return n.children[0].indent }
+    return n.indent + 2
+}
+
+// parseYAMLOutline builds a key/item tree from block-style YAML, enough to locate sections.
+// Lines it does not understand (block scalars, flow continuations) extend the enclosing node.
+func parseYAMLOutline(lines []string) *yamlNode {
+    root := &yamlNode{indent: -1, line: -1, last: -1}
+    stack := []*yamlNode{root}
+    scalarIndent := -1 // indentation of a block scalar (| or >) whose content is being skipped
+    for i, l := range lines {
+        t := strings.TrimSpace(l)
+        if t == "" || strings.HasPrefix(t, "#") || t == "---" || t == "..." { continue }
+        indent := len(l) - len(strings.TrimLeft(l, " "))
+        if scalarIndent >= 0 && indent > scalarIndent {
+            for _, n := range stack { n.last = i }
+            continue
+        }
+        scalarIndent = -1
+        var nodes []*yamlNode
+        rest, col := t, indent
+        if rest == "-" || strings.HasPrefix(rest, "- ") {
+            nodes = append(nodes, &yamlNode{indent: indent, item: true, line: i})
+            trimmed := strings.TrimLeft(strings.TrimPrefix(rest, "-"), " ")
+            col = indent + len(rest) - len(trimmed)
+            rest = trimmed
+        }
+        if k, v, ok := splitYAMLKey(rest); ok {
+            nodes = append(nodes, &yamlNode{indent: col, key: k, value: v, line: i})
+            if strings.HasPrefix(v, "|") || strings.HasPrefix(v, ">") { scalarIndent = col }
+        }
+        if len(nodes) == 0 {
+            for _, n := range stack { n.last = i }
+            continue
+        }
+        first := nodes[0]
+        for len(stack) > 1 {
+            top := stack[len(stack)-1]
+            compactSeq := first.item && !top.item && top.value == "" && top.indent == first.indent
+            if top.indent < first.indent || compactSeq { break }
+            stack = stack[:len(stack)-1]
+        }
+        for _, n := range nodes {
+            parent := stack[len(stack)-1]
+            parent.children = append(parent.children, n)
+            stack = append(stack, n)
+        }
+        for _, n := range stack { n.last = i }
+    }
+    return root
+}
+
+// splitYAMLKey splits `key: value` (key optionally quoted) and strips trailing comments from value.
+func splitYAMLKey(s string) (string, string, bool) {
+    var key, rest string
+    if s != "" && (s[0] == '"' || s[0] == '\'') {
+        end := closingQuote(s)
+        if end < 0 { return "", "", false }
+        key, rest = unquoteYAML(s[:end+1]), s[end+1:]
+        if !strings.HasPrefix(rest, ":") { return "", "", false }
+        rest = rest[1:]
+    } else {
+        idx := strings.Index(s, ": ")
+        if idx < 0 {
+            if !strings.HasSuffix(s, ":") { return "", "", false }
+            idx = len(s) - 1
+        }
+        key, rest = s[:idx], s[idx+1:]
+        if strings.ContainsAny(key, "{}[],") { return "", "", false }
+    }
+    if rest != "" && rest[0] != ' ' { return "", "", false }
+    return key, stripYAMLComment(strings.TrimSpace(rest)), true
+}
+
+func closingQuote(s string) int {
+    q := s[0]
+    for i := 1; i < len(s); i++ {
+        switch {
+        case q == '"' && s[i] == '\\':
+            i++
+        case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+            i++
+        case s[i] == q:
+            return i
+        }
+    }
+    return -1
+}
+
+func stripYAMLComment(v string) string {
+    if v != "" && (v[0] == '"' || v[0] == '\'') {
+        if end := closingQuote(v); end >= 0 { return v[:end+1] }
+        return v
+    }
+    if i := strings.Index(v, " #"); i >= 0 { return strings.TrimSpace(v[:i]) }
+    return v
+}
+
+// unquoteYAML returns the plain value of a scalar; double-quoted escapes follow Go's subset.
+func unquoteYAML(v string) string {
+    switch {
+    case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+        if s, err := strconv.Unquote(v); err == nil { return s }
+        return v[1 : len(v)-1]
+    case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+        return strings.ReplaceAll(v[1:len(v)-1], "''", "'")
+    }
+    return v
+}
+
+type insertion struct {
+    at    int // insert before this line index
+    lines []string
+}
+
+func mergeYAML(lines []string, cfg Config) ([]byte, error) {
+    root := parseYAMLOutline(lines)
+    hosts := ownedHosts(cfg)
+    for _, top := range root.children {
+        for _, sub := range top.children {
+            if sub.key != "routers" || (top.key != "http" && top.key != "tcp") { continue }
+            for _, r := range sub.children {
+                if rule := r.child("rule"); rule != nil {
+                    if err := checkRule(top.key+".routers."+r.key, unquoteYAML(rule.value), hosts); err != nil { return nil, err }
+                }
+            }
+        }
+    }
+    var ins []insertion
+    var appendTop []slot
+    for _, s := range slots(cfg) {
+        topNode := root.child(s.top)
+        if topNode == nil {
+            appendTop = append(appendTop, s)
+            continue
+        }
+        if topNode.value != "" {
+            return nil, &CollisionError{Entry: s.top, Reason: "inline value cannot be merged (line " + strconv.Itoa(topNode.line+1) + ")"}
+        }
+        subNode := topNode.child(s.sub)
+        if subNode == nil {
+            indent := topNode.childIndent()
+            var b bytes.Buffer
+            writeEntry(&b, s.sub, s.v, indent)
+            ins = append(ins, insertion{at: topNode.last + 1, lines: managedBlock(b.Bytes(), indent)})
+            continue
+        }
+        if subNode.value != "" {
+            return nil, &CollisionError{Entry: s.top + "." + s.sub, Reason: "inline value cannot be merged (line " + strconv.Itoa(subNode.line+1) + ")"}
+        }
+        for _, name := range s.names() {
+            if subNode.child(name) != nil {
+                return nil, &CollisionError{Entry: s.top + "." + s.sub + "." + name, Reason: "name is already defined"}
+            }
+        }
+        indent := subNode.childIndent()
+        var b bytes.Buffer
+        if deref(s.v).Kind() == reflect.Map {
+            writeMapping(&b, s.v, indent)
+        } else {
+            writeSequence(&b, deref(s.v), indent)
+        }
+        ins = append(ins, insertion{at: subNode.last + 1, lines: managedBlock(b.Bytes(), indent)})
+    }
+    var out []string
+    for i := 0; i <= len(lines); i++ {
+        for _, in := range ins {
+            if in.at == i { out = append(out, in.lines...) }
+        }
+        if i < len(lines) { out = append(out, lines[i]) }
+    }
+    if len(appendTop) > 0 {
+        var rest Config
+        rv := reflect.ValueOf(&rest).Elem()
+        for _, s := range appendTop {
+            for i := 0; i < rv.NumField(); i++ {
+                if fieldName(rv.Type().Field(i)) != s.top { continue }
+                if rv.Field(i).IsNil() { rv.Field(i).Set(reflect.New(rv.Field(i).Type().Elem())) }
+                sec := rv.Field(i).Elem()
+                for j := 0; j < sec.NumField(); j++ {
+                    if fieldName(sec.Type().Field(j)) == s.sub { sec.Field(j).Set(s.v) }
+                }
+            }
+        }
+        out = append(out, managedBlock(MarshalConfigYAML(rest), 0)...)
+    }
+    return joinLines(out), nil
+}
+
+// --- TOML ---
+
+func mergeTOML(lines []string, cfg Config) ([]byte, error) {
+    hosts := ownedHosts(cfg)
+    owned := map[string]bool{}
+    for _, s := range slots(cfg) {
+        for _, n := range s.names() { owned[s.top+"."+s.sub+"."+n] = true }
+    }
+    var table []string
+    for _, l := range lines {
+        t := strings.TrimSpace(l)
+        if strings.HasPrefix(t, "[") {
+            table = parseTOMLHeader(t)
+            if len(table) >= 3 && owned[strings.Join(table[:3], ".")] {
+                return nil, &CollisionError{Entry: strings.Join(table[:3], "."), Reason: "name is already defined"}
+            }
+            continue
+        }
+        if len(table) == 3 && table[1] == "routers" && (table[0] == "http" || table[0] == "tcp") {
+            if k, v, ok := strings.Cut(t, "="); ok && strings.TrimSpace(k) == "rule" {
+                if err := checkRule(strings.Join(table, "."), unquoteYAML(strings.TrimSpace(v)), hosts); err != nil { return nil, err }
+            }
+        }
+    }
+    data := MarshalConfigTOML(cfg)
+    if err := checkTOMLRedefinitions(lines, splitLines(data)); err != nil { return nil, err }
+    out := append([]string(nil), lines...)
+    if len(data) > 0 {
+        if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" { out = append(out, "") }
+        out = append(out, managedBlock(data, 0)...)
+    }
+    return joinLines(out), nil
+}
+
+// tomlDefs records where a TOML document defines each dotted path: tables by their
+// [header], arrays of tables by their [[header]], and keys assigned a value outside them.
+type tomlDefs struct {
+    tables, arrays, keys map[string]int // path -> line index
+}
+
+var tomlKeyPart = regexp.MustCompile(`^[A-Za-z0-9_\-."' ]+$`)
+
+func scanTOML(lines []string) tomlDefs {
+    d := tomlDefs{tables: map[string]int{}, arrays: map[string]int{}, keys: map[string]int{}}
+    var table []string
+    inArray := false // keys of array items belong to that item only
+    for i, l := range lines {
+        t := strings.TrimSpace(l)
+        if strings.HasPrefix(t, "[") {
+            table = parseTOMLHeader(t)
+            path := strings.Join(table, ".")
+            inArray = strings.HasPrefix(t, "[[")
+            if inArray {
+                d.arrays[path] = i
+            } else if _, ok := d.tables[path]; !ok {
+                d.tables[path] = i
+            }
+            continue
+        }
+        k, _, ok := strings.Cut(t, "=")
+        if !ok || inArray || strings.HasPrefix(t, "#") || !tomlKeyPart.MatchString(strings.TrimSpace(k)) { continue }
+        key := append(append([]string(nil), table...), parseTOMLHeader(k)...)
+        d.keys[strings.Join(key, ".")] = i
+    }
+    return d
+}
+
+// checkTOMLRedefinitions refuses a block that would define a table or key the foreign
+// lines already define, e.g. [[tls.certificates]] below `certificates = [...]` in [tls]:
+// TOML allows each to be defined only once, so Traefik would reject the whole file.
+func checkTOMLRedefinitions(foreign, block []string) error {
+    have, ours := scanTOML(foreign), scanTOML(block)
+    conflict := func(path string, line int, what string) error {
+        return &CollisionError{Entry: path, Reason: what + " on line " + strconv.Itoa(line+1) + " and cannot be extended by TailWhale's block"}
+    }
+    var paths []string
+    for p := range ours.tables { paths = append(paths, p) }
+    for p := range ours.arrays { paths = append(paths, p) }
+    for p := range ours.keys { paths = append(paths, p) }
+    sort.Strings(paths)
+    for _, p := range paths {
+        keys := strings.Split(p, ".")
+        for n := 1; n <= len(keys); n++ {
+            if line, ok := have.keys[strings.Join(keys[:n], ".")]; ok { return conflict(strings.Join(keys[:n], "."), line, "set as a value") }
+        }
+        if line, ok := have.tables[p]; ok { return conflict(p, line, "defined as a table") }
+        if line, ok := have.arrays[p]; ok && !isArray(ours, p) { return conflict(p, line, "defined as an array of tables") }
+    }
+    return nil
+}
+
+func isArray(d tomlDefs, path string) bool {
+    _, ok := d.arrays[path]
+    return ok
+}
+
+// parseTOMLHeader splits `[a.b."c.d"]` or `[[a.b]]` into its keys.
+func parseTOMLHeader(t string) []string {
+    t = strings.TrimSpace(strings.Trim(stripYAMLComment(t), "[]"))
+    var keys []string
+    for t != "" {
+        t = strings.TrimLeft(t, " ")
+        var k string
+        if t[0] == '"' || t[0] == '\'' {
+            end := closingQuote(t)
+            if end < 0 { break }
+            k, t = unquoteYAML(t[:end+1]), t[end+1:]
+        } else {
+            i := strings.IndexByte(t, '.')
+            if i < 0 { i = len(t) }
+            k, t = strings.TrimSpace(t[:i]), t[i:]
+        }
+        keys = append(keys, k)
+        t = strings.TrimPrefix(strings.TrimLeft(t, " "), ".")
+    }
+    return keys
+}
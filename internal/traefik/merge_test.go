@@ -0,0 +1,118 @@
+package traefik
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func mergeTestConfig() Config {
+    routes := []Route{{Name: "web", Host: "web.host1.tn.ts.net", Servers: []string{"http://web:80"}}}
+    certs := TLSConfig{"web.host1.tn.ts.net": {CertFile: "/certs/web.crt", KeyFile: "/certs/web.key"}}
+    return Build(routes, certs, Options{})
+}
+
+func TestMergeYAMLPreservesForeignEntries(t *testing.T){
+    foreign := "# ops-managed\n" +
+        "http:\n" +
+        "  routers:\n" +
+        "    dashboard:   # keep me\n" +
+        "      rule: 'Host(`traefik.example`)'\n" +
+        "      service: api@internal\n" +
+        "tls:\n" +
+        "  options:\n" +
+        "    default:\n" +
+        "      minVersion: VersionTLS12\n" +
+        "  certificates:\n" +
+        "  - certFile: /ops/wild.crt\n" +
+        "    keyFile: /ops/wild.key\n"
+    got, err := Merge([]byte(foreign), mergeTestConfig(), FormatYAML)
+    if err != nil { t.Fatal(err) }
+    s := string(got)
+    for _, l := range strings.Split(strings.TrimSuffix(foreign, "\n"), "\n") {
+        if !strings.Contains(s, l+"\n") { t.Fatalf("foreign line %q lost:\n%s", l, s) }
+    }
+    if strings.Count(s, "\nhttp:") != 1 || strings.Count(s, "\ntls:") != 1 { t.Fatalf("duplicate sections:\n%s", s) }
+    want := "      service: api@internal\n" +
+        "    " + MarkerBegin + "\n" +
+        "    web:\n"
+    if !strings.Contains(s, want) { t.Fatalf("router not merged into http.routers:\n%s", s) }
+    want = "    keyFile: /ops/wild.key\n" +
+        "  " + MarkerBegin + "\n" +
+        "  - certFile: \"/certs/web.crt\"\n"
+    if !strings.Contains(s, want) { t.Fatalf("certificate not merged into compact sequence:\n%s", s) }
+    if !strings.Contains(s, "  " + MarkerBegin + "\n  services:\n") { t.Fatalf("missing http.services block:\n%s", s) }
+
+    again, err := Merge(got, mergeTestConfig(), FormatYAML)
+    if err != nil { t.Fatal(err) }
+    if string(again) != s { t.Fatalf("merge not idempotent:\n--- first ---\n%s\n--- second ---\n%s", s, again) }
+    empty, err := Merge(got, Config{}, FormatYAML)
+    if err != nil { t.Fatal(err) }
+    if string(empty) != foreign { t.Fatalf("removing managed blocks should restore the foreign file:\n%s", empty) }
+}
+
+func TestMergeYAMLEmptyFile(t *testing.T){
+    got, err := Merge(nil, mergeTestConfig(), FormatYAML)
+    if err != nil { t.Fatal(err) }
+    want := MarkerBegin + "\n" + string(MarshalConfigYAML(mergeTestConfig())) + MarkerEnd + "\n"
+    if string(got) != want { t.Fatalf("unexpected output:\n%s", got) }
+}
+
+func TestMergeRefusesCollisions(t *testing.T){
+    cases := map[string]string{
+        "name": "http:\n  routers:\n    web:\n      rule: \"Host(`other.example`)\"\n",
+        "host": "http:\n  routers:\n    legacy:\n      rule: \"Host(`web.host1.tn.ts.net`) && PathPrefix(`/`)\"\n",
+    }
+    for name, foreign := range cases {
+        _, err := Merge([]byte(foreign), mergeTestConfig(), FormatYAML)
+        var ce *CollisionError
+        if !errors.As(err, &ce) { t.Fatalf("%s: expected collision, got %v", name, err) }
+    }
+    _, err := Merge([]byte("[http.routers.legacy]\n  rule = \"Host(`web.host1.tn.ts.net`)\"\n"), mergeTestConfig(), FormatTOML)
+    var ce *CollisionError
+    if !errors.As(err, &ce) || ce.Entry != "http.routers.legacy" { t.Fatalf("expected toml collision, got %v", err) }
+}
+
+func TestMergeTOML(t *testing.T){
+    foreign := "[http.routers.dashboard]\n  rule = \"Host(`traefik.example`)\"\n  service = \"api@internal\"\n"
+    got, err := Merge([]byte(foreign), mergeTestConfig(), FormatTOML)
+    if err != nil { t.Fatal(err) }
+    s := string(got)
+    if !strings.HasPrefix(s, foreign) { t.Fatalf("foreign content changed:\n%s", s) }
+    for _, want := range []string{
+        "[http.routers.web]\nentryPoints = [\"websecure\"]\nrule = \"Host(`web.host1.tn.ts.net`)\"\nservice = \"web\"\n",
+        "\n[http.routers.web.tls]\n",
+        "[[http.services.web.loadBalancer.servers]]\nurl = \"http://web:80\"\n",
+        "[[tls.certificates]]\ncertFile = \"/certs/web.crt\"\n",
+    } {
+        if !strings.Contains(s, want) { t.Fatalf("missing %q in:\n%s", want, s) }
+    }
+    again, err := Merge(got, mergeTestConfig(), FormatTOML)
+    if err != nil || string(again) != s { t.Fatalf("toml merge not idempotent: %v\n%s", err, again) }
+}
+
+func TestMergeTOMLExistingTLSTable(t *testing.T){
+    foreign := "[tls]\n\n[tls.options.modern]\n  minVersion = \"VersionTLS13\"\n\n[[tls.certificates]]\n  certFile = \"/certs/other.crt\"\n  keyFile = \"/certs/other.key\"\n"
+    got, err := Merge([]byte(foreign), mergeTestConfig(), FormatTOML)
+    if err != nil { t.Fatal(err) }
+    if s := string(got); strings.Count(s, "[tls]") != 1 || strings.Count(s, "[[tls.certificates]]") != 2 { t.Fatalf("expected the certificates appended to the existing array:\n%s", s) }
+
+    for entry, foreign := range map[string]string{
+        "tls.certificates": "[tls]\ncertificates = [ { certFile = \"/certs/other.crt\", keyFile = \"/certs/other.key\" } ]\n",
+        "tls":              "tls = { }\n",
+    } {
+        _, err := Merge([]byte(foreign), mergeTestConfig(), FormatTOML)
+        var ce *CollisionError
+        if !errors.As(err, &ce) || ce.Entry != entry || !strings.Contains(err.Error(), "line ") { t.Fatalf("expected a collision on %s, got %v", entry, err) }
+    }
+}
+
+func TestWriteMergedCreatesFile(t *testing.T){
+    path := filepath.Join(t.TempDir(), "dyn", "tls.yml")
+    if err := WriteMerged(path, mergeTestConfig()); err != nil { t.Fatal(err) }
+    b, err := os.ReadFile(path)
+    if err != nil { t.Fatal(err) }
+    if !strings.HasPrefix(string(b), MarkerBegin+"\nhttp:\n") { t.Fatalf("unexpected file:\n%s", b) }
+}
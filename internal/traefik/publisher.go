@@ -0,0 +1,42 @@
+package traefik
+
+import (
+    "errors"
+    "io/fs"
+)
+
+// Publisher delivers a rendered dynamic configuration to Traefik.
+type Publisher interface {
+    Publish(cfg Config) error
+}
+
+// FilePublisher merges the config into a file watched by Traefik's file provider.
+type FilePublisher struct {
+    Path string
+    Mode fs.FileMode // defaults to 0644; use 0600 when certificates are inlined
+}
+
+func (p FilePublisher) Publish(cfg Config) error {
+    return writeMerged(p.Path, cfg, fileMode(p.Mode))
+}
+
+func fileMode(m fs.FileMode) fs.FileMode {
+    if m == 0 { return 0o644 }
+    return m
+}
+
+// PublisherFunc adapts a function to Publisher.
+type PublisherFunc func(cfg Config) error
+
+func (f PublisherFunc) Publish(cfg Config) error { return f(cfg) }
+
+// Publishers fans a config out to several publishers; every publisher runs even if one fails.
+type Publishers []Publisher
+
+func (ps Publishers) Publish(cfg Config) error {
+    var errs []error
+    for _, p := range ps {
+        if err := p.Publish(cfg); err != nil { errs = append(errs, err) }
+    }
+    return errors.Join(errs...)
+}
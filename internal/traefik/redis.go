@@ -0,0 +1,128 @@
+package traefik
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/frnwtr/tailwhale/internal/mapx"
+    "github.com/frnwtr/tailwhale/internal/redisx"
+)
+
+// RedisPublisher writes the config as Traefik KV keys into Redis for providers.redis,
+// so every Traefik replica reading the same Redis picks it up. The keys it wrote are
+// remembered in a set outside the tree; keys of removed services are deleted in the
+// same MULTI/EXEC that writes the new ones, and keys other tools put under the prefix are left alone.
+type RedisPublisher struct {
+    Addr     string
+    Password string
+    DB       int
+    Prefix   string // Traefik's rootKey; defaults to DefaultKVPrefix
+    Timeout  time.Duration
+}
+
+func (p RedisPublisher) Publish(cfg Config) error {
+    return p.store(KVPairs(cfg, p.Prefix))
+}
+
+// Snapshot captures the keys currently managed by TailWhale so a rejected publish can be undone.
+func (p RedisPublisher) Snapshot() (func() error, error) {
+    c, err := p.dial()
+    if err != nil { return nil, err }
+    defer c.Close()
+    r, err := c.Do("SMEMBERS", p.managedSet())
+    if err != nil { return nil, fmt.Errorf("redis publisher: %w", err) }
+    keys, err := redisx.Strings(r)
+    if err != nil { return nil, fmt.Errorf("redis publisher: %w", err) }
+    pairs := map[string]string{}
+    if len(keys) > 0 {
+        r, err := c.Do(append([]string{"MGET"}, keys...)...)
+        if err != nil { return nil, fmt.Errorf("redis publisher: %w", err) }
+        vals, err := redisx.Strings(r)
+        if err != nil { return nil, fmt.Errorf("redis publisher: %w", err) }
+        for i, k := range keys {
+            if vals[i] != "" { pairs[k] = vals[i] }
+        }
+    }
+    return func() error { return p.store(pairs) }, nil
+}
+
+// managedSet names the set of keys written by TailWhale. It lives outside the prefix so Traefik never reads it.
+func (p RedisPublisher) managedSet() string {
+    prefix := p.Prefix
+    if prefix == "" { prefix = DefaultKVPrefix }
+    return "tailwhale:keys:" + prefix
+}
+
+func (p RedisPublisher) dial() (*redisx.Conn, error) {
+    c, err := redisx.Dial(p.Addr, redisx.Options{Password: p.Password, DB: p.DB, Timeout: p.Timeout})
+    if err != nil { return nil, fmt.Errorf("redis publisher: %w", err) }
+    return c, nil
+}
+
+// store replaces the managed keys with pairs, retrying when another client changed them mid-way.
+func (p RedisPublisher) store(pairs map[string]string) error {
+    c, err := p.dial()
+    if err != nil { return err }
+    defer c.Close()
+    for attempt := 0; attempt < 3; attempt++ {
+        done, err := p.commit(c, pairs)
+        if err != nil { return fmt.Errorf("redis publisher: %w", err) }
+        if done { return nil }
+    }
+    return errors.New("redis publisher: keys kept changing during the transaction")
+}
+
+// commit runs one optimistic transaction and reports false when a watched key changed before EXEC.
+// Unchanged values are not rewritten, and nothing is sent when the store is already up to date.
+func (p RedisPublisher) commit(c *redisx.Conn, pairs map[string]string) (bool, error) {
+    set := p.managedSet()
+    keys := mapx.SortedKeys(pairs)
+    if _, err := c.Do(append([]string{"WATCH", set}, keys...)...); err != nil { return false, err }
+    r, err := c.Do("SMEMBERS", set)
+    if err != nil { return false, err }
+    old, err := redisx.Strings(r)
+    if err != nil { return false, err }
+    var stale []string
+    for _, k := range old {
+        if _, ok := pairs[k]; !ok { stale = append(stale, k) }
+    }
+    sort.Strings(stale)
+    var changed []string
+    if len(keys) > 0 {
+        r, err := c.Do(append([]string{"MGET"}, keys...)...)
+        if err != nil { return false, err }
+        cur, err := redisx.Strings(r)
+        if err != nil { return false, err }
+        for i, k := range keys {
+            if cur[i] != pairs[k] { changed = append(changed, k, pairs[k]) }
+        }
+    }
+    if len(stale) == 0 && len(changed) == 0 && len(old) == len(keys) {
+        _, err := c.Do("UNWATCH")
+        return true, err
+    }
+    cmds := [][]string{{"MULTI"}}
+    if len(stale) > 0 { cmds = append(cmds, append([]string{"DEL"}, stale...)) }
+    if len(changed) > 0 { cmds = append(cmds, append([]string{"MSET"}, changed...)) }
+    cmds = append(cmds, []string{"DEL", set})
+    if len(keys) > 0 { cmds = append(cmds, append([]string{"SADD", set}, keys...)) }
+    cmds = append(cmds, []string{"EXEC"})
+    replies, err := c.Pipeline(cmds)
+    if err != nil { return false, err }
+    for _, r := range replies[:len(replies)-1] {
+        if e, ok := r.(redisx.Error); ok { return false, e }
+    }
+    switch res := replies[len(replies)-1].(type) {
+    case nil:
+        return false, nil
+    case redisx.Error:
+        return false, res
+    case []any:
+        for _, r := range res {
+            if e, ok := r.(redisx.Error); ok { return false, e }
+        }
+    }
+    return true, nil
+}
@@ -0,0 +1,77 @@
+package traefik
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/frnwtr/tailwhale/internal/redisx"
+)
+
+func redisTestConfig(hosts ...string) Config {
+    var routes []Route
+    certs := TLSConfig{}
+    for _, h := range hosts {
+        name := strings.SplitN(h, ".", 2)[0]
+        routes = append(routes, Route{Name: name, Host: h, Servers: []string{"http://" + name + ":8080"}})
+        certs[h] = TLSCert{CertFile: "/certs/" + h + ".crt", KeyFile: "/certs/" + h + ".key"}
+    }
+    return Build(routes, certs, Options{})
+}
+
+func TestKVPairs(t *testing.T){
+    got := KVPairs(redisTestConfig("web.host1.tn.ts.net"), "")
+    want := map[string]string{
+        "traefik/http/routers/web/entryPoints/0":               "websecure",
+        "traefik/http/routers/web/rule":                        "Host(`web.host1.tn.ts.net`)",
+        "traefik/http/routers/web/service":                     "web",
+        "traefik/http/routers/web/tls":                         "true",
+        "traefik/http/services/web/loadBalancer/servers/0/url": "http://web:8080",
+        "traefik/tls/certificates/0/certFile":                  "/certs/web.host1.tn.ts.net.crt",
+        "traefik/tls/certificates/0/keyFile":                   "/certs/web.host1.tn.ts.net.key",
+        "traefik/tls/certificates/0/stores/0":                  "default",
+    }
+    if !reflect.DeepEqual(got, want) { t.Fatalf("unexpected pairs:\n%v\nwant\n%v", got, want) }
+    if kv := KVPairs(Config{}, "edge/"); len(kv) != 0 { t.Fatalf("empty config should have no keys: %v", kv) }
+}
+
+func TestRedisPublisherReplacesStaleKeys(t *testing.T){
+    srv, err := redisx.NewFakeServer("")
+    if err != nil { t.Fatal(err) }
+    defer srv.Close()
+    srv.Set("edge/http/middlewares/manual/headers/customResponseHeaders/X-Test", "kept")
+    p := RedisPublisher{Addr: srv.Addr(), Prefix: "edge"}
+    if err := p.Publish(redisTestConfig("web.host1.tn.ts.net", "api.host1.tn.ts.net")); err != nil { t.Fatal(err) }
+    kv := srv.Strings()
+    if kv["edge/http/routers/api/rule"] != "Host(`api.host1.tn.ts.net`)" { t.Fatalf("api router missing: %v", kv) }
+    if kv["edge/tls/certificates/1/certFile"] != "/certs/web.host1.tn.ts.net.crt" { t.Fatalf("certificates not indexed by host order: %v", kv) }
+
+    if err := p.Publish(redisTestConfig("web.host1.tn.ts.net")); err != nil { t.Fatal(err) }
+    kv = srv.Strings()
+    for k := range kv {
+        if strings.Contains(k, "/api") || strings.HasPrefix(k, "edge/tls/certificates/1/") { t.Fatalf("stale key %s survived", k) }
+    }
+    if kv["edge/tls/certificates/0/certFile"] != "/certs/web.host1.tn.ts.net.crt" { t.Fatalf("certificate not moved to index 0: %v", kv) }
+    if kv["edge/http/middlewares/manual/headers/customResponseHeaders/X-Test"] != "kept" { t.Fatal("foreign key under the prefix was deleted") }
+    if srv.Execs() != 2 { t.Fatalf("expected one transaction per change, got %d", srv.Execs()) }
+
+    if err := p.Publish(redisTestConfig("web.host1.tn.ts.net")); err != nil { t.Fatal(err) }
+    if srv.Execs() != 2 { t.Fatal("unchanged config should not open a transaction") }
+}
+
+func TestRedisPublisherSnapshotRestores(t *testing.T){
+    srv, err := redisx.NewFakeServer("s3cret")
+    if err != nil { t.Fatal(err) }
+    defer srv.Close()
+    p := RedisPublisher{Addr: srv.Addr(), Password: "s3cret", DB: 2}
+    if err := p.Publish(redisTestConfig("web.host1.tn.ts.net")); err != nil { t.Fatal(err) }
+    before := srv.Strings()
+    restore, err := p.Snapshot()
+    if err != nil { t.Fatal(err) }
+    if err := p.Publish(redisTestConfig("api.host1.tn.ts.net")); err != nil { t.Fatal(err) }
+    if err := restore(); err != nil { t.Fatal(err) }
+    if got := srv.Strings(); !reflect.DeepEqual(got, before) { t.Fatalf("restore mismatch:\n%v\nwant\n%v", got, before) }
+
+    bad := RedisPublisher{Addr: srv.Addr(), Password: "wrong"}
+    if err := bad.Publish(Config{}); err == nil || !strings.Contains(err.Error(), "WRONGPASS") { t.Fatalf("expected auth error, got %v", err) }
+}
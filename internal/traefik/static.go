@@ -0,0 +1,25 @@
+package traefik
+
+import (
+    "bytes"
+    "reflect"
+)
+
+// StaticConfig is the part of Traefik's static configuration TailWhale generates: the entry
+// points of port-routed services. Traefik reads it only at startup, so it has to be merged
+// into traefik.yml and Traefik restarted when a port is added.
+type StaticConfig struct {
+    EntryPoints map[string]StaticEntryPoint `json:"entryPoints,omitempty"`
+}
+
+// StaticEntryPoint is one entry of entryPoints.
+type StaticEntryPoint struct {
+    Address string `json:"address,omitempty"` // e.g. :8443, or :8443/udp
+}
+
+// MarshalStaticYAML renders a StaticConfig the same way MarshalConfigYAML renders dynamic config.
+func MarshalStaticYAML(sc StaticConfig) []byte {
+    var b bytes.Buffer
+    writeMapping(&b, reflect.ValueOf(sc), 0)
+    return b.Bytes()
+}
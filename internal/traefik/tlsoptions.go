@@ -0,0 +1,159 @@
+package traefik
+
+import (
+    "crypto/tls"
+    "reflect"
+    "strings"
+)
+
+// TLS versions as named by Traefik.
+const (
+    VersionTLS10 = "VersionTLS10"
+    VersionTLS11 = "VersionTLS11"
+    VersionTLS12 = "VersionTLS12"
+    VersionTLS13 = "VersionTLS13"
+)
+
+// TLSProfiles are the predefined option sets selectable by name, after Mozilla's guidelines.
+// TLS 1.3 suites are not configurable in Traefik, so modern needs no cipher list.
+var TLSProfiles = map[string]TLSOption{
+    "modern": {MinVersion: VersionTLS13},
+    "intermediate": {MinVersion: VersionTLS12, CipherSuites: []string{
+        "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+        "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+        "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+        "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+        "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+        "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305",
+    }},
+}
+
+// TLSOption is one entry of tls.options.
+type TLSOption struct {
+    MinVersion   string      `json:"minVersion,omitempty"`
+    CipherSuites []string    `json:"cipherSuites,omitempty"`
+    ClientAuth   *ClientAuth `json:"clientAuth,omitempty"`
+}
+
+// ClientAuthRequireAndVerify rejects clients without a certificate signed by one of CAFiles.
+const ClientAuthRequireAndVerify = "RequireAndVerifyClientCert"
+
+// ClientAuth configures mutual TLS for the routers using an option.
+type ClientAuth struct {
+    CAFiles        []string `json:"caFiles,omitempty"`
+    ClientAuthType string   `json:"clientAuthType,omitempty"`
+}
+
+// RouteTLS carries per-route TLS settings requested through labels.
+type RouteTLS struct {
+    Profile      string   // named profile; empty uses Options.TLSProfile
+    MinVersion   string   // overrides the profile's minimum version
+    CipherSuites []string // overrides the profile's cipher suites
+    Floor        string   // minimum version enforced whatever the profile or labels say
+    ClientCA     string   // name of a CA bundle in Options.ClientCAs; requires client certificates
+    Passthrough  bool     // tcp routes: hand the TLS stream to the backend, which terminates it
+}
+
+// KnownCipherSuite reports whether name is a cipher suite Traefik (Go's crypto/tls) accepts.
+func KnownCipherSuite(name string) bool {
+    for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+        if cs.Name == name { return true }
+    }
+    return false
+}
+
+// tlsOption resolves the options a route's router should reference. Routes using the
+// default profile unchanged share one `tailwhale-<profile>` entry; customized routes
+// (including mTLS) get an entry named after the route. An empty name means Traefik's defaults.
+func tlsOption(r Route, opt Options) (string, TLSOption) {
+    profile := r.TLS.Profile
+    if profile == "" { profile = opt.TLSProfile }
+    base := TLSProfiles[strings.ToLower(profile)]
+    o := TLSOption{MinVersion: base.MinVersion, CipherSuites: base.CipherSuites}
+    if r.TLS.MinVersion != "" { o.MinVersion = r.TLS.MinVersion }
+    if len(r.TLS.CipherSuites) > 0 { o.CipherSuites = r.TLS.CipherSuites }
+    if versionRank(o.MinVersion) < versionRank(r.TLS.Floor) { o.MinVersion = r.TLS.Floor }
+    if r.TLS.ClientCA != "" {
+        o.ClientAuth = &ClientAuth{CAFiles: opt.ClientCAs[r.TLS.ClientCA], ClientAuthType: ClientAuthRequireAndVerify}
+    }
+    if reflect.DeepEqual(o, TLSOption{}) { return "", o }
+    if r.TLS.Profile == "" && reflect.DeepEqual(o, base) { return "tailwhale-" + strings.ToLower(profile), o }
+    return r.Name, o
+}
+
+// routerTLS registers the route's TLS options (if any) and returns the router's tls block.
+// Shared profile options go to shared, route-specific ones to cfg.
+func routerTLS(cfg, shared *Config, r Route, opt Options) *RouterTLS {
+    name, o := tlsOption(r, opt)
+    if name == "" { return &RouterTLS{} }
+    if n := opt.sharedOptions[r.Name]; n != "" { name = n }
+    if name != r.Name { cfg = shared }
+    if cfg.TLS == nil { cfg.TLS = &TLSBlock{} }
+    if cfg.TLS.Options == nil { cfg.TLS.Options = map[string]TLSOption{} }
+    cfg.TLS.Options[name] = o
+    return &RouterTLS{Options: name}
+}
+
+// shareOptions points routes with route-specific options at the entry of an earlier route
+// on the same host and entry point when the options are equal. Traefik applies one set of
+// options per host and falls back to its defaults when routers name different entries,
+// which would drop the client certificate checks of mTLS routes sharing the node's name.
+func shareOptions(routes []Route, opt Options) map[string]string {
+    type entry struct {
+        name string
+        o    TLSOption
+    }
+    shared := map[string]string{}
+    seen := map[string][]entry{} // host and entry point -> route-specific entries
+    for _, r := range routes {
+        if r.Protocol == ProtocolUDP || r.TLS.Passthrough { continue }
+        name, o := tlsOption(r, opt)
+        if name != r.Name { continue }
+        k := r.Host + " " + r.EntryPoint
+        for _, e := range seen[k] {
+            if reflect.DeepEqual(e.o, o) { shared[r.Name] = e.name; break }
+        }
+        if shared[r.Name] == "" { seen[k] = append(seen[k], entry{name, o}) }
+    }
+    return shared
+}
+
+// missingClientCA reports a route asking for mTLS with a CA bundle that is not configured.
+// Such routes get no router at all: serving them without client verification would fail open.
+func missingClientCA(r Route, opt Options) bool {
+    return r.TLS.ClientCA != "" && len(opt.ClientCAs[r.TLS.ClientCA]) == 0
+}
+
+// versionRank orders Traefik version names; unknown or empty ranks lowest.
+func versionRank(v string) int {
+    switch v {
+    case VersionTLS10:
+        return 1
+    case VersionTLS11:
+        return 2
+    case VersionTLS12:
+        return 3
+    case VersionTLS13:
+        return 4
+    }
+    return 0
+}
+
+// NormalizeTLSVersion accepts "1.2", "tls1.2", "TLS12" or "VersionTLS12" and returns Traefik's name.
+func NormalizeTLSVersion(s string) string {
+    v := strings.ToLower(strings.TrimSpace(s))
+    v = strings.TrimPrefix(v, "version")
+    v = strings.TrimPrefix(v, "tls")
+    v = strings.TrimPrefix(strings.TrimPrefix(v, "v"), " ")
+    switch strings.ReplaceAll(v, ".", "") {
+    case "10":
+        return VersionTLS10
+    case "11":
+        return VersionTLS11
+    case "12":
+        return VersionTLS12
+    case "13":
+        return VersionTLS13
+    }
+    return ""
+}
@@ -0,0 +1,61 @@
+package traefik
+
+import "testing"
+
+func TestBuildTLSOptions(t *testing.T){
+    routes := []Route{
+        {Name: "web", Host: "web.example", Servers: []string{"http://web:80"}},
+        {Name: "api", Host: "api.example", Servers: []string{"http://api:80"}, TLS: RouteTLS{MinVersion: VersionTLS13}},
+        {Name: "pub", Host: "pub.example", Servers: []string{"http://pub:80"}, TLS: RouteTLS{Profile: "modern"}},
+        {Name: "db", Host: "db.example", Protocol: ProtocolTCP, Servers: []string{"db:5432"}},
+    }
+    cfg := Build(routes, nil, Options{TLSProfile: "intermediate"})
+    if got := cfg.HTTP.Routers["web"].TLS.Options; got != "tailwhale-intermediate" { t.Fatalf("web options: %q", got) }
+    if got := cfg.TCP.Routers["db"].TLS.Options; got != "tailwhale-intermediate" { t.Fatalf("db options: %q", got) }
+    if got := cfg.HTTP.Routers["api"].TLS.Options; got != "api" { t.Fatalf("api options: %q", got) }
+    api := cfg.TLS.Options["api"]
+    if api.MinVersion != VersionTLS13 || len(api.CipherSuites) != 6 { t.Fatalf("api should keep profile ciphers with its own version: %+v", api) }
+    if cfg.TLS.Options["pub"].MinVersion != VersionTLS13 { t.Fatalf("pub should use modern: %+v", cfg.TLS.Options) }
+}
+
+func TestTLSFloorAndDefaults(t *testing.T){
+    routes := []Route{
+        {Name: "plain", Host: "plain.example", Servers: []string{"http://plain:80"}},
+        {Name: "funnel", Host: "funnel.example", Servers: []string{"http://funnel:80"}, TLS: RouteTLS{MinVersion: VersionTLS10, Floor: VersionTLS12}},
+    }
+    cfg := Build(routes, nil, Options{})
+    if cfg.HTTP.Routers["plain"].TLS.Options != "" { t.Fatalf("expected Traefik defaults without profile: %+v", cfg.HTTP.Routers["plain"]) }
+    if cfg.TLS.Options["funnel"].MinVersion != VersionTLS12 { t.Fatalf("floor not enforced: %+v", cfg.TLS.Options) }
+}
+
+func TestNormalizeTLSVersion(t *testing.T){
+    for in, want := range map[string]string{"1.2": VersionTLS12, "TLS1.3": VersionTLS13, "VersionTLS11": VersionTLS11, "tls10": VersionTLS10, "ssl3": ""} {
+        if got := NormalizeTLSVersion(in); got != want { t.Fatalf("%s: got %q want %q", in, got, want) }
+    }
+}
+
+func TestBuildMutualTLS(t *testing.T){
+    routes := []Route{
+        {Name: "admin", Host: "admin.example", Servers: []string{"http://admin:80"}, TLS: RouteTLS{ClientCA: "internal"}},
+        {Name: "typo", Host: "typo.example", Servers: []string{"http://typo:80"}, TLS: RouteTLS{ClientCA: "intrenal"}},
+    }
+    certs := TLSConfig{"typo.example": {CertFile: "/certs/typo.crt", KeyFile: "/certs/typo.key"}}
+    cfg := Build(routes, certs, Options{ClientCAs: map[string][]string{"internal": {"/ca/internal.pem"}}})
+    if cfg.HTTP.Routers["admin"].TLS.Options != "admin" { t.Fatalf("admin router should reference its options: %+v", cfg.HTTP.Routers["admin"]) }
+    ca := cfg.TLS.Options["admin"].ClientAuth
+    if ca == nil || ca.ClientAuthType != ClientAuthRequireAndVerify || ca.CAFiles[0] != "/ca/internal.pem" { t.Fatalf("unexpected clientAuth: %+v", ca) }
+    if _, ok := cfg.HTTP.Routers["typo"]; ok { t.Fatal("route with unknown CA must not be routed") }
+    if len(cfg.TLS.Certificates) != 1 { t.Fatalf("certificate should still be issued: %+v", cfg.TLS) }
+}
+
+func TestBuildSharesOptionsOnOneHost(t *testing.T){
+    routes := []Route{
+        {Name: "admin", Host: "host1.example", PathPrefix: "/admin", Servers: []string{"http://admin:80"}, TLS: RouteTLS{ClientCA: "internal"}},
+        {Name: "vault", Host: "host1.example", PathPrefix: "/vault", Servers: []string{"http://vault:80"}, TLS: RouteTLS{ClientCA: "internal"}},
+        {Name: "other", Host: "other.example", Servers: []string{"http://other:80"}, TLS: RouteTLS{ClientCA: "internal"}},
+    }
+    cfg := Build(routes, nil, Options{ClientCAs: map[string][]string{"internal": {"/ca/internal.pem"}}})
+    r := cfg.HTTP.Routers
+    if r["admin"].TLS.Options != "admin" || r["vault"].TLS.Options != "admin" || r["other"].TLS.Options != "other" { t.Fatalf("routers on one host should share their options: %+v", r) }
+    if _, ok := cfg.TLS.Options["vault"]; ok { t.Fatal("no separate entry expected for vault") }
+}
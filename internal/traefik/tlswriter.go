@@ -9,13 +9,15 @@ import (
 type TLSCert struct {
     CertFile string
     KeyFile  string
+    Stores   []string // certificate stores for the dynamic config; defaults to ["default"]
 }
 
 // TLSConfig is a minimal structure for Traefik's file provider.
 type TLSConfig map[string]TLSCert
 
 // MarshalYAML deterministically renders a TLSConfig to a Traefik-compatible YAML snippet.
-// We avoid importing a YAML lib to keep dependencies minimal at this stage.
+// We avoid importing a YAML lib to keep dependencies minimal at this stage; values are
+// emitted as escaped double-quoted scalars.
 func MarshalYAML(cfg TLSConfig) []byte {
     var hosts []string
     for h := range cfg {
@@ -26,10 +28,10 @@ func MarshalYAML(cfg TLSConfig) []byte {
     b.WriteString("tls:\n  certificates:\n")
     for _, h := range hosts {
         c := cfg[h]
-        b.WriteString("    - certFile: \"" + c.CertFile + "\"\n")
-        b.WriteString("      keyFile: \"" + c.KeyFile + "\"\n")
+        b.WriteString("    - certFile: " + yamlQuote(c.CertFile) + "\n")
+        b.WriteString("      keyFile: " + yamlQuote(c.KeyFile) + "\n")
         b.WriteString("      stores:\n        - default\n")
-        b.WriteString("      sans:\n        - \"" + h + "\"\n")
+        b.WriteString("      sans:\n        - " + yamlQuote(h) + "\n")
     }
     return b.Bytes()
 }
@@ -0,0 +1,156 @@
+package traefik
+
+import (
+    "bytes"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// MarshalConfigTOML renders a dynamic Config as TOML tables, in the same order as MarshalConfigYAML.
+func MarshalConfigTOML(cfg Config) []byte {
+    var b bytes.Buffer
+    writeTOMLTable(&b, nil, reflect.ValueOf(cfg), false)
+    return b.Bytes()
+}
+
+type tomlEntry struct {
+    key string
+    v   reflect.Value
+}
+
+// writeTOMLTable writes the table at path: a header with its scalar keys, then nested tables.
+// Headers of tables that only hold other tables are left implicit, except empty tables such as `tls: {}`.
+func writeTOMLTable(b *bytes.Buffer, path []string, v reflect.Value, arrayItem bool) {
+    v = deref(v)
+    var scalars, tables, arrays []tomlEntry
+    for _, e := range tomlEntries(v) {
+        d := deref(e.v)
+        switch {
+        case d.Kind() == reflect.Struct || d.Kind() == reflect.Map:
+            tables = append(tables, e)
+        case (d.Kind() == reflect.Slice || d.Kind() == reflect.Array) && isTableKind(d.Type().Elem()):
+            arrays = append(arrays, e)
+        default:
+            scalars = append(scalars, e)
+        }
+    }
+    if len(path) > 0 && (arrayItem || len(scalars) > 0 || (len(tables) == 0 && len(arrays) == 0)) {
+        if b.Len() > 0 { b.WriteString("\n") }
+        if arrayItem {
+            b.WriteString("[[" + tomlPath(path) + "]]\n")
+        } else {
+            b.WriteString("[" + tomlPath(path) + "]\n")
+        }
+    }
+    for _, e := range scalars {
+        b.WriteString(tomlKey(e.key) + " = " + tomlValue(deref(e.v)) + "\n")
+    }
+    for _, e := range tables {
+        writeTOMLTable(b, append(append([]string(nil), path...), e.key), e.v, false)
+    }
+    for _, e := range arrays {
+        d := deref(e.v)
+        for i := 0; i < d.Len(); i++ {
+            writeTOMLTable(b, append(append([]string(nil), path...), e.key), d.Index(i), true)
+        }
+    }
+}
+
+// tomlEntries lists the non-empty entries of a struct (declaration order) or map (sorted keys).
+func tomlEntries(v reflect.Value) []tomlEntry {
+    var out []tomlEntry
+    switch v.Kind() {
+    case reflect.Struct:
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+            if !f.IsExported() { continue }
+            if name := fieldName(f); name != "" && !isEmpty(v.Field(i)) {
+                out = append(out, tomlEntry{name, v.Field(i)})
+            }
+        }
+    case reflect.Map:
+        keys := make([]string, 0, v.Len())
+        for _, k := range v.MapKeys() { keys = append(keys, k.String()) }
+        sort.Strings(keys)
+        for _, k := range keys {
+            if mv := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())); !isEmpty(mv) {
+                out = append(out, tomlEntry{k, mv})
+            }
+        }
+    }
+    return out
+}
+
+func isTableKind(t reflect.Type) bool {
+    for t.Kind() == reflect.Pointer { t = t.Elem() }
+    return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+}
+
+func tomlValue(v reflect.Value) string {
+    switch v.Kind() {
+    case reflect.Slice, reflect.Array:
+        parts := make([]string, 0, v.Len())
+        for i := 0; i < v.Len(); i++ { parts = append(parts, tomlValue(deref(v.Index(i)))) }
+        return "[" + strings.Join(parts, ", ") + "]"
+    case reflect.String:
+        return tomlQuote(v.String())
+    }
+    return yamlScalar(v)
+}
+
+func tomlPath(path []string) string {
+    parts := make([]string, len(path))
+    for i, p := range path { parts[i] = tomlKey(p) }
+    return strings.Join(parts, ".")
+}
+
+// tomlKey leaves bare keys plain and quotes anything else (including dots).
+func tomlKey(k string) string {
+    if k == "" { return `""` }
+    for _, r := range k {
+        if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+            return tomlQuote(k)
+        }
+    }
+    return k
+}
+
+// tomlQuote returns a TOML basic string, escaping only what TOML allows.
+func tomlQuote(s string) string {
+    var b strings.Builder
+    b.WriteByte('"')
+    for _, r := range s {
+        switch r {
+        case '"':
+            b.WriteString(`\"`)
+        case '\\':
+            b.WriteString(`\\`)
+        case '\b':
+            b.WriteString(`\b`)
+        case '\t':
+            b.WriteString(`\t`)
+        case '\n':
+            b.WriteString(`\n`)
+        case '\f':
+            b.WriteString(`\f`)
+        case '\r':
+            b.WriteString(`\r`)
+        default:
+            if r < 0x20 || r == 0x7f {
+                b.WriteString(`\u` + leftPad(strconv.FormatInt(int64(r), 16), 4))
+            } else {
+                b.WriteRune(r)
+            }
+        }
+    }
+    b.WriteByte('"')
+    return b.String()
+}
+
+func leftPad(s string, n int) string {
+    for len(s) < n { s = "0" + s }
+    return s
+}
@@ -0,0 +1,284 @@
+package traefik
+
+import (
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/frnwtr/tailwhale/internal/fsx"
+    "github.com/frnwtr/tailwhale/internal/mapx"
+)
+
+// Verifier checks through Traefik's API that a published config was loaded.
+type Verifier struct {
+    // BaseURL of Traefik's API, e.g. http://traefik:8080 (api.insecure or a routed api@internal).
+    BaseURL string
+    // Provider is the suffix Traefik gives our routers: "file", "http" or "redis".
+    Provider string
+    // ProbeAddr optionally names a TLS entry point (host:port) to check served certificates by SNI.
+    ProbeAddr string
+    // Timeout bounds how long to wait for Traefik to reload; Interval is the polling period.
+    Timeout  time.Duration
+    Interval time.Duration
+    Client   *http.Client
+}
+
+// Report is the outcome of a verification, keyed by router name (one per service).
+type Report struct {
+    Errors     map[string][]string // routers Traefik loaded with errors or disabled
+    Missing    []string            // routers Traefik never loaded
+    CertErrors map[string]string   // hosts served with a certificate that does not cover them
+    Overview   Overview
+    RolledBack bool
+}
+
+// OK reports whether every router loaded cleanly.
+func (r Report) OK() bool {
+    return len(r.Errors) == 0 && len(r.Missing) == 0 && len(r.CertErrors) == 0
+}
+
+// Overview holds the router error counts from /api/overview.
+type Overview struct {
+    HTTP overviewSection `json:"http"`
+    TCP  overviewSection `json:"tcp"`
+    UDP  overviewSection `json:"udp"`
+}
+
+type overviewSection struct {
+    Routers struct {
+        Total    int `json:"total"`
+        Warnings int `json:"warnings"`
+        Errors   int `json:"errors"`
+    } `json:"routers"`
+}
+
+// apiRouter is the subset of /api/{http,tcp,udp}/routers entries we check.
+type apiRouter struct {
+    Name    string     `json:"name"`
+    Status  string     `json:"status"`
+    Error   []string   `json:"error"`
+    Rule    string     `json:"rule,omitempty"`
+    Service string     `json:"service,omitempty"`
+    TLS     *RouterTLS `json:"tls,omitempty"`
+}
+
+// routerSpec is what a published router should look like once Traefik has loaded it.
+type routerSpec struct {
+    Rule    string
+    Service string
+    TLS     *RouterTLS
+}
+
+// VerifyError is returned by VerifiedPublisher when Traefik rejected the new config.
+type VerifyError struct {
+    Report Report
+}
+
+func (e *VerifyError) Error() string {
+    var parts []string
+    for _, n := range mapx.SortedKeys(e.Report.Errors) {
+        parts = append(parts, n+": "+strings.Join(e.Report.Errors[n], "; "))
+    }
+    for _, n := range e.Report.Missing { parts = append(parts, n+": not loaded") }
+    for _, h := range mapx.SortedKeys(e.Report.CertErrors) { parts = append(parts, h+": "+e.Report.CertErrors[h]) }
+    msg := "traefik rejected config: " + strings.Join(parts, ", ")
+    if e.Report.RolledBack { msg += " (previous config restored)" }
+    return msg
+}
+
+// Verify polls Traefik until every router in cfg is loaded with cfg's rule, service and tls and is
+// enabled without errors, or Timeout elapses. Routers that still carry the previous definition count
+// as not yet reloaded. An unreachable API is returned as an error, not as a failed report.
+func (v Verifier) Verify(ctx context.Context, cfg Config) (Report, error) {
+    timeout, interval := v.Timeout, v.Interval
+    if timeout == 0 { timeout = 10 * time.Second }
+    if interval == 0 { interval = 500 * time.Millisecond }
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+    want := routerSpecs(cfg)
+    var last *Report // latest complete report, kept when the final poll is cut short by the timeout
+    for {
+        rep, err := v.check(ctx, want)
+        if err == nil && len(rep.Missing) == 0 && len(rep.Errors) == 0 {
+            rep.CertErrors = v.probe(ctx, cfg)
+            return rep, nil
+        }
+        if err == nil { last = &rep }
+        select {
+        case <-ctx.Done():
+            if err != nil && last != nil { return *last, nil }
+            return rep, err
+        case <-time.After(interval):
+        }
+    }
+}
+
+func (v Verifier) check(ctx context.Context, want map[string]map[string]routerSpec) (Report, error) {
+    rep := Report{Errors: map[string][]string{}}
+    if err := v.get(ctx, "/api/overview", &rep.Overview); err != nil { return rep, err }
+    provider := v.Provider
+    if provider == "" { provider = "file" }
+    for _, kind := range []string{"http", "tcp", "udp"} {
+        if len(want[kind]) == 0 { continue }
+        var routers []apiRouter
+        if err := v.get(ctx, "/api/"+kind+"/routers?per_page=1000", &routers); err != nil { return rep, err }
+        loaded := map[string]apiRouter{}
+        for _, r := range routers { loaded[r.Name] = r }
+        for _, name := range mapx.SortedKeys(want[kind]) {
+            r, ok := loaded[name+"@"+provider]
+            switch {
+            case !ok:
+                rep.Missing = append(rep.Missing, name)
+            case !want[kind][name].matches(r, provider):
+                rep.Errors[name] = []string{"loaded definition differs from the published one (reload pending?)"}
+            case len(r.Error) > 0:
+                rep.Errors[name] = r.Error
+            case r.Status != "" && r.Status != "enabled":
+                rep.Errors[name] = []string{"status " + r.Status}
+            }
+        }
+    }
+    sort.Strings(rep.Missing)
+    return rep, nil
+}
+
+func (v Verifier) get(ctx context.Context, path string, out any) error {
+    c := v.Client
+    if c == nil { c = http.DefaultClient }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(v.BaseURL, "/")+path, nil)
+    if err != nil { return err }
+    resp, err := c.Do(req)
+    if err != nil { return err }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK { return fmt.Errorf("traefik api %s: %s", path, resp.Status) }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// probe dials ProbeAddr with each HTTP/TCP router host as SNI and checks the served certificate covers it.
+func (v Verifier) probe(ctx context.Context, cfg Config) map[string]string {
+    if v.ProbeAddr == "" { return nil }
+    errs := map[string]string{}
+    for host := range ownedHosts(cfg) {
+        d := tls.Dialer{NetDialer: &net.Dialer{}, Config: &tls.Config{ServerName: host, InsecureSkipVerify: true}}
+        conn, err := d.DialContext(ctx, "tcp", v.ProbeAddr)
+        if err != nil {
+            errs[host] = err.Error()
+            continue
+        }
+        certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+        conn.Close()
+        if len(certs) == 0 || certs[0].VerifyHostname(host) != nil {
+            errs[host] = "served certificate does not cover host"
+        }
+    }
+    if len(errs) == 0 { return nil }
+    return errs
+}
+
+// routerSpecs lists cfg's routers per protocol.
+func routerSpecs(cfg Config) map[string]map[string]routerSpec {
+    out := map[string]map[string]routerSpec{}
+    if cfg.HTTP != nil {
+        out["http"] = map[string]routerSpec{}
+        for n, r := range cfg.HTTP.Routers { out["http"][n] = routerSpec{Rule: r.Rule, Service: r.Service, TLS: r.TLS} }
+    }
+    if cfg.TCP != nil {
+        out["tcp"] = map[string]routerSpec{}
+        for n, r := range cfg.TCP.Routers { out["tcp"][n] = routerSpec{Rule: r.Rule, Service: r.Service, TLS: r.TLS} }
+    }
+    if cfg.UDP != nil {
+        out["udp"] = map[string]routerSpec{}
+        for n, r := range cfg.UDP.Routers { out["udp"][n] = routerSpec{Service: r.Service} }
+    }
+    return out
+}
+
+// matches reports whether Traefik's view of a router agrees with the spec. Traefik qualifies
+// names from the same provider with "@provider", so that suffix is ignored.
+func (s routerSpec) matches(r apiRouter, provider string) bool {
+    unqualify := func(n string) string { return strings.TrimSuffix(n, "@"+provider) }
+    if r.Rule != s.Rule || unqualify(r.Service) != unqualify(s.Service) { return false }
+    if (r.TLS == nil) != (s.TLS == nil) { return false }
+    return r.TLS == nil || (unqualify(r.TLS.Options) == unqualify(s.TLS.Options) && r.TLS.Passthrough == s.TLS.Passthrough)
+}
+
+// Snapshotter is implemented by publishers that can restore what they last replaced.
+type Snapshotter interface {
+    // Snapshot captures the current output and returns a function restoring it.
+    Snapshot() (restore func() error, err error)
+}
+
+// VerifiedPublisher publishes, verifies through Traefik's API and rolls back on rejection.
+type VerifiedPublisher struct {
+    Publisher Publisher
+    Verifier  Verifier
+}
+
+func (p VerifiedPublisher) Publish(cfg Config) error {
+    var restore func() error
+    if s, ok := p.Publisher.(Snapshotter); ok {
+        r, err := s.Snapshot()
+        if err != nil { return err }
+        restore = r
+    }
+    if err := p.Publisher.Publish(cfg); err != nil { return err }
+    rep, err := p.Verifier.Verify(context.Background(), cfg)
+    if err != nil && rep.OK() { return fmt.Errorf("verify: %w", err) }
+    if rep.OK() { return nil }
+    if restore != nil {
+        if rerr := restore(); rerr != nil { return errors.Join(&VerifyError{Report: rep}, fmt.Errorf("rollback: %w", rerr)) }
+        rep.RolledBack = true
+    }
+    return &VerifyError{Report: rep}
+}
+
+// Snapshot restores the file's previous content, or removes it if it did not exist.
+func (p FilePublisher) Snapshot() (func() error, error) {
+    return snapshotFiles([]string{p.Path}, fileMode(p.Mode))
+}
+
+// Snapshot restores the directory's managed files as they were.
+func (p DirPublisher) Snapshot() (func() error, error) {
+    entries, err := os.ReadDir(p.Dir)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) { return nil, err }
+    var paths []string
+    for _, e := range entries {
+        if path := filepath.Join(p.Dir, e.Name()); !e.IsDir() && managedFile(path) { paths = append(paths, path) }
+    }
+    restoreFiles, err := snapshotFiles(paths, fileMode(p.Mode))
+    if err != nil { return nil, err }
+    return func() error {
+        // Drop files created by the rejected publish, then put the old ones back.
+        if _, err := p.Sync(Config{}); err != nil { return err }
+        return restoreFiles()
+    }, nil
+}
+
+func snapshotFiles(paths []string, mode fs.FileMode) (func() error, error) {
+    saved := map[string][]byte{}
+    for _, path := range paths {
+        b, err := os.ReadFile(path)
+        if err != nil && !errors.Is(err, fs.ErrNotExist) { return nil, err }
+        saved[path] = b
+    }
+    return func() error {
+        for path, b := range saved {
+            if b == nil {
+                if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) { return err }
+                continue
+            }
+            if err := fsx.WriteFileAtomic(path, b, mode); err != nil { return err }
+        }
+        return nil
+    }, nil
+}
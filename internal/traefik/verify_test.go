@@ -0,0 +1,128 @@
+package traefik
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// fakeTraefikAPI serves /api/overview and /api/http/routers from routers.
+func fakeTraefikAPI(t *testing.T, routers func() []apiRouter) *httptest.Server {
+    t.Helper()
+    mux := http.NewServeMux()
+    mux.HandleFunc("/api/overview", func(w http.ResponseWriter, r *http.Request) {
+        _, _ = w.Write([]byte(`{"http":{"routers":{"total":1,"warnings":0,"errors":0}}}`))
+    })
+    mux.HandleFunc("/api/http/routers", func(w http.ResponseWriter, r *http.Request) {
+        _ = json.NewEncoder(w).Encode(routers())
+    })
+    srv := httptest.NewServer(mux)
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+// loadedWeb is the web router of mergeTestConfig as Traefik's API reports it.
+func loadedWeb(status string, errs ...string) apiRouter {
+    r := mergeTestConfig().HTTP.Routers["web"]
+    return apiRouter{Name: "web@file", Status: status, Error: errs, Rule: r.Rule, Service: r.Service + "@file", TLS: r.TLS}
+}
+
+func TestVerifierAcceptsLoadedRouters(t *testing.T){
+    api := fakeTraefikAPI(t, func() []apiRouter {
+        return []apiRouter{loadedWeb("enabled"), {Name: "dashboard@internal", Status: "enabled"}}
+    })
+    v := Verifier{BaseURL: api.URL, Timeout: time.Second, Interval: 10 * time.Millisecond}
+    rep, err := v.Verify(context.Background(), mergeTestConfig())
+    if err != nil || !rep.OK() { t.Fatalf("expected clean report, got %+v, %v", rep, err) }
+    if rep.Overview.HTTP.Routers.Total != 1 { t.Fatalf("overview not parsed: %+v", rep.Overview) }
+}
+
+func TestVerifiedPublisherRollsBackRejectedConfig(t *testing.T){
+    api := fakeTraefikAPI(t, func() []apiRouter {
+        return []apiRouter{loadedWeb("disabled", "unknown TLS options: web@file")}
+    })
+    path := filepath.Join(t.TempDir(), "tls.yml")
+    previous := []byte("# previous\n")
+    if err := os.WriteFile(path, previous, 0o644); err != nil { t.Fatal(err) }
+    p := VerifiedPublisher{
+        Publisher: FilePublisher{Path: path},
+        Verifier:  Verifier{BaseURL: api.URL, Timeout: 200 * time.Millisecond, Interval: 10 * time.Millisecond},
+    }
+    err := p.Publish(mergeTestConfig())
+    var ve *VerifyError
+    if !errors.As(err, &ve) { t.Fatalf("expected VerifyError, got %v", err) }
+    if !ve.Report.RolledBack || ve.Report.Errors["web"][0] != "unknown TLS options: web@file" { t.Fatalf("unexpected report: %+v", ve.Report) }
+    if !strings.Contains(err.Error(), "web: unknown TLS options") { t.Fatalf("unexpected message: %v", err) }
+    got, _ := os.ReadFile(path)
+    if string(got) != string(previous) { t.Fatalf("previous config not restored:\n%s", got) }
+}
+
+func TestVerifiedPublisherReportsMissingRouters(t *testing.T){
+    api := fakeTraefikAPI(t, func() []apiRouter { return nil })
+    dir := t.TempDir()
+    p := VerifiedPublisher{
+        Publisher: DirPublisher{Dir: dir},
+        Verifier:  Verifier{BaseURL: api.URL, Timeout: 100 * time.Millisecond, Interval: 10 * time.Millisecond},
+    }
+    var ve *VerifyError
+    if err := p.Publish(mergeTestConfig()); !errors.As(err, &ve) || ve.Report.Missing[0] != "web" { t.Fatalf("expected missing router, got %v", err) }
+    entries, _ := os.ReadDir(dir)
+    if len(entries) != 0 { t.Fatalf("rejected files should be removed, found %d", len(entries)) }
+}
+
+func TestVerifierKeepsLastReportWhenFinalPollTimesOut(t *testing.T){
+    var polls atomic.Int32
+    mux := http.NewServeMux()
+    mux.HandleFunc("/api/overview", func(w http.ResponseWriter, r *http.Request) {
+        if polls.Add(1) > 1 { <-r.Context().Done(); return } // Traefik stalls until the deadline
+        _, _ = w.Write([]byte(`{}`))
+    })
+    mux.HandleFunc("/api/http/routers", func(w http.ResponseWriter, r *http.Request) {
+        _ = json.NewEncoder(w).Encode([]apiRouter{loadedWeb("disabled", "bad rule")})
+    })
+    api := httptest.NewServer(mux)
+    t.Cleanup(api.Close)
+    v := Verifier{BaseURL: api.URL, Timeout: 100 * time.Millisecond, Interval: 10 * time.Millisecond}
+    rep, err := v.Verify(context.Background(), mergeTestConfig())
+    if err != nil || rep.Errors["web"][0] != "bad rule" { t.Fatalf("expected the last complete report, got %+v, %v", rep, err) }
+}
+
+func TestVerifierWaitsForTheReloadedDefinition(t *testing.T){
+    var polls atomic.Int32
+    api := fakeTraefikAPI(t, func() []apiRouter {
+        if polls.Add(1) <= 3 { // the previous config: same name, enabled, old rule
+            return []apiRouter{{Name: "web@file", Status: "enabled", Rule: "Host(`old.host1.tn.ts.net`)", Service: "web@file"}}
+        }
+        return []apiRouter{loadedWeb("enabled")}
+    })
+    v := Verifier{BaseURL: api.URL, Timeout: time.Second, Interval: 10 * time.Millisecond}
+    rep, err := v.Verify(context.Background(), mergeTestConfig())
+    if err != nil || !rep.OK() || polls.Load() < 4 { t.Fatalf("expected to wait for the new definition, got %+v, %v after %d polls", rep, err, polls.Load()) }
+
+    stale := fakeTraefikAPI(t, func() []apiRouter {
+        return []apiRouter{{Name: "web@file", Status: "enabled", Rule: "Host(`old.host1.tn.ts.net`)", Service: "web@file"}}
+    })
+    v.BaseURL, v.Timeout = stale.URL, 100*time.Millisecond
+    rep, err = v.Verify(context.Background(), mergeTestConfig())
+    if err != nil || rep.OK() || !strings.Contains(rep.Errors["web"][0], "differs") { t.Fatalf("expected a stale router report, got %+v, %v", rep, err) }
+}
+
+func TestVerifiedPublisherUnreachableAPIKeepsConfig(t *testing.T){
+    path := filepath.Join(t.TempDir(), "tls.yml")
+    p := VerifiedPublisher{
+        Publisher: FilePublisher{Path: path},
+        Verifier:  Verifier{BaseURL: "http://127.0.0.1:1", Timeout: 50 * time.Millisecond, Interval: 10 * time.Millisecond},
+    }
+    err := p.Publish(mergeTestConfig())
+    var ve *VerifyError
+    if err == nil || errors.As(err, &ve) { t.Fatalf("expected plain verify error, got %v", err) }
+    if _, err := os.Stat(path); err != nil { t.Fatalf("config should stay in place: %v", err) }
+}
@@ -0,0 +1,162 @@
+package traefik
+
+import (
+    "bytes"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// MarshalConfigYAML renders a dynamic Config as block-style YAML.
+// Struct fields keep declaration order, map keys are sorted and strings are always double-quoted,
+// so the output is deterministic and safe for any hostname or path.
+func MarshalConfigYAML(cfg Config) []byte {
+    var b bytes.Buffer
+    writeMapping(&b, reflect.ValueOf(cfg), 0)
+    return b.Bytes()
+}
+
+// writeMapping writes the non-empty entries of a struct or string-keyed map at the given indent.
+func writeMapping(b *bytes.Buffer, v reflect.Value, indent int) {
+    v = deref(v)
+    switch v.Kind() {
+    case reflect.Struct:
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+            f := t.Field(i)
+            if !f.IsExported() { continue }
+            name := fieldName(f)
+            if name == "" { continue }
+            writeEntry(b, name, v.Field(i), indent)
+        }
+    case reflect.Map:
+        keys := make([]string, 0, v.Len())
+        for _, k := range v.MapKeys() { keys = append(keys, k.String()) }
+        sort.Strings(keys)
+        for _, k := range keys {
+            writeEntry(b, k, v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())), indent)
+        }
+    }
+}
+
+// writeEntry writes `key: value`, descending into nested mappings and sequences.
+func writeEntry(b *bytes.Buffer, key string, v reflect.Value, indent int) {
+    if isEmpty(v) { return }
+    pad := strings.Repeat(" ", indent)
+    b.WriteString(pad + yamlKey(key) + ":")
+    d := deref(v)
+    switch d.Kind() {
+    case reflect.Struct, reflect.Map:
+        if !hasEntries(d) {
+            b.WriteString(" {}\n")
+            return
+        }
+        b.WriteString("\n")
+        writeMapping(b, d, indent+2)
+    case reflect.Slice, reflect.Array:
+        b.WriteString("\n")
+        writeSequence(b, d, indent+2)
+    default:
+        b.WriteString(" " + yamlScalar(d) + "\n")
+    }
+}
+
+// writeSequence writes block sequence items; mapping items start on the dash line.
+func writeSequence(b *bytes.Buffer, v reflect.Value, indent int) {
+    pad := strings.Repeat(" ", indent)
+    for i := 0; i < v.Len(); i++ {
+        it := deref(v.Index(i))
+        switch it.Kind() {
+        case reflect.Struct, reflect.Map:
+            if !hasEntries(it) {
+                b.WriteString(pad + "- {}\n")
+                continue
+            }
+            var item bytes.Buffer
+            writeMapping(&item, it, indent+2)
+            b.WriteString(pad + "- " + strings.TrimPrefix(item.String(), pad+"  "))
+        default:
+            b.WriteString(pad + "- " + yamlScalar(it) + "\n")
+        }
+    }
+}
+
+func deref(v reflect.Value) reflect.Value {
+    for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+        if v.IsNil() { return v }
+        v = v.Elem()
+    }
+    return v
+}
+
+// fieldName returns the key from the json tag, or "" when the field is skipped.
+func fieldName(f reflect.StructField) string {
+    tag := f.Tag.Get("json")
+    if tag == "-" { return "" }
+    if name, _, _ := strings.Cut(tag, ","); name != "" { return name }
+    return f.Name
+}
+
+// isEmpty mirrors omitempty. A non-nil pointer to an empty struct is kept so `tls: {}` survives.
+func isEmpty(v reflect.Value) bool {
+    switch v.Kind() {
+    case reflect.Invalid:
+        return true
+    case reflect.Pointer, reflect.Interface:
+        return v.IsNil()
+    case reflect.Map, reflect.Slice, reflect.String, reflect.Array:
+        return v.Len() == 0
+    case reflect.Bool:
+        return !v.Bool()
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return v.Int() == 0
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return v.Uint() == 0
+    case reflect.Struct:
+        return !hasEntries(v)
+    }
+    return false
+}
+
+func hasEntries(v reflect.Value) bool {
+    switch v.Kind() {
+    case reflect.Map:
+        return v.Len() > 0
+    case reflect.Struct:
+        for i := 0; i < v.NumField(); i++ {
+            if v.Type().Field(i).IsExported() && !isEmpty(v.Field(i)) { return true }
+        }
+    }
+    return false
+}
+
+func yamlScalar(v reflect.Value) string {
+    switch v.Kind() {
+    case reflect.String:
+        return yamlQuote(v.String())
+    case reflect.Bool:
+        return strconv.FormatBool(v.Bool())
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return strconv.FormatInt(v.Int(), 10)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return strconv.FormatUint(v.Uint(), 10)
+    }
+    return "null"
+}
+
+// yamlQuote returns a double-quoted YAML scalar. Go's escape sequences are a subset of YAML's.
+func yamlQuote(s string) string {
+    return strconv.Quote(s)
+}
+
+// yamlKey leaves simple keys plain and quotes anything else.
+func yamlKey(k string) string {
+    if k == "" { return `""` }
+    for _, r := range k {
+        if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+            return yamlQuote(k)
+        }
+    }
+    return k
+}